@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// availabilityLockTTL bounds how long one accept can block others on the same inventory,
+	// it covers loading the bookings from the inventory service and updating the booking
+	availabilityLockTTL = 15 * time.Second
+	// maxAvailabilityDays caps the range of the availability calendar
+	maxAvailabilityDays = 366
+)
+
+// ErrSlotUnavailable is returned when the requested quantity is not free for the period
+var ErrSlotUnavailable = errors.New("the requested quantity is not available for this period")
+
+// ErrInventoryBusy is returned when another booking of the inventory is being checked right now
+var ErrInventoryBusy = errors.New("this inventory is being booked right now, please try again")
+
+// blockingBookingStatuses are the booking states that take stock away from other renters
+var blockingBookingStatuses = []BookingStatus{BookingAccepted, BookingActive}
+
+// BookedPeriod is stock taken by a booking or an imported calendar for a period
+type BookedPeriod struct {
+	BookingID string    `json:"booking_id,omitempty"`
+	Source    string    `json:"source,omitempty"` // set on periods blocked by an imported calendar
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Quantity  float64   `json:"quantity"`
+}
+
+func (p BookedPeriod) overlaps(start, end time.Time) bool {
+	return p.Start.Before(end) && start.Before(p.End)
+}
+
+// peakBooked returns the most stock taken at any moment between start and end
+func peakBooked(periods []BookedPeriod, start, end time.Time) float64 {
+	type change struct {
+		at    time.Time
+		delta float64
+	}
+
+	var changes []change
+	for _, p := range periods {
+		if !p.overlaps(start, end) {
+			continue
+		}
+
+		changes = append(changes,
+			change{at: later(p.Start, start), delta: p.Quantity},
+			change{at: earlier(p.End, end), delta: -p.Quantity},
+		)
+	}
+
+	// at the same instant a period ending frees its stock before the next one takes it
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].at.Equal(changes[j].at) {
+			return changes[i].delta < changes[j].delta
+		}
+		return changes[i].at.Before(changes[j].at)
+	})
+
+	var current, peak float64
+	for _, c := range changes {
+		current += c.delta
+		peak = max(peak, current)
+	}
+
+	return peak
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earlier(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// AvailabilitySlot is one day of the availability calendar
+type AvailabilitySlot struct {
+	Date   string  `json:"date"`
+	Booked float64 `json:"booked"`
+	Free   float64 `json:"free"`
+}
+
+// availabilityCalendar splits from-to into days and reports the stock booked and free on each
+func availabilityCalendar(periods []BookedPeriod, stock float64, from, to time.Time) []AvailabilitySlot {
+	slots := []AvailabilitySlot{}
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		booked := peakBooked(periods, day, day.AddDate(0, 0, 1))
+		slots = append(slots, AvailabilitySlot{
+			Date:   day.Format("2006-01-02"),
+			Booked: booked,
+			Free:   max(stock-booked, 0),
+		})
+	}
+
+	return slots
+}
+
+// bookedPeriods loads the bookings of an inventory that take stock between from and to,
+// along with the periods blocked by its imported calendar
+func (app *Config) bookedPeriods(inventoryID string, from, to time.Time) ([]BookedPeriod, error) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "inventory-bookings"), map[string]interface{}{
+		"inventory_id": inventoryID,
+		"from":         from.Format("2006-01-02"),
+		"to":           to.Format("2006-01-02"),
+		"statuses":     blockingBookingStatuses,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var bookings []BookingSnapshot
+	err = decodeServiceData(jsonFromService.Data, &bookings)
+	if err != nil {
+		return nil, err
+	}
+
+	periods := make([]BookedPeriod, 0, len(bookings))
+	for _, booking := range bookings {
+		window, errs := booking.Times().Window()
+		if len(errs) > 0 {
+			log.Printf("[AVAILABILITY] skipping booking %s: %v", booking.ID, firstError(errs))
+			continue
+		}
+
+		periods = append(periods, BookedPeriod{BookingID: booking.ID, Start: window.Start, End: window.End, Quantity: booking.Quantity})
+	}
+
+	blocks, err := app.calendarBlockedPeriods(context.Background(), inventoryID, from, to)
+	if err != nil {
+		log.Printf("[AVAILABILITY] loading calendar blocks of %s failed: %v", inventoryID, err)
+	}
+
+	return append(periods, blocks...), nil
+}
+
+// lockAvailability keeps two bookings of an inventory from being checked against its capacity and accepted
+// at once, call the returned func to unlock
+func (app *Config) lockAvailability(ctx context.Context, inventoryID string) (func(), error) {
+	unlock, err := app.acquireLock(ctx, fmt.Sprintf("availability:lock:%s", inventoryID), availabilityLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return nil, ErrInventoryBusy
+	}
+
+	return unlock, err
+}
+
+// availabilityErrorStatus is the status to answer an availability check or lock error with
+func availabilityErrorStatus(err error) int {
+	if errors.Is(err, ErrSlotUnavailable) || errors.Is(err, ErrInventoryBusy) {
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+// checkQuoteAvailable turns a renter away early when the quoted period is already taken by accepted bookings.
+// A pending booking takes no stock, the check that counts is made when the owner accepts, see transitionBooking.
+func (app *Config) checkQuoteAvailable(quote RentalQuote) error {
+	periods, err := app.bookedPeriods(quote.InventoryID, quote.Start, quote.End)
+	if err != nil {
+		return err
+	}
+
+	taken := append(periods, BookedPeriod{Start: quote.Start, End: quote.End, Quantity: quote.Quantity})
+	if peakBooked(taken, quote.Start, quote.End) > quote.StockQuantity {
+		return ErrSlotUnavailable
+	}
+
+	return nil
+}
+
+func (app *Config) GetInventoryAvailability(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query param
+	queryParams := r.URL.Query()
+	inventoryID := queryParams.Get("inventory_id")
+	if inventoryID == "" {
+		app.errorJSON(w, errors.New("inventory_id not supplied"), nil)
+		return
+	}
+
+	window, errs := BookingTimes{
+		StartDate: queryParams.Get("from"),
+		EndDate:   queryParams.Get("to"),
+		Timezone:  queryParams.Get("timezone"),
+	}.Window()
+	if len(errs) > 0 {
+		app.errorJSON(w, errors.New("from and to must be in YYYY-MM-DD format and timezone a valid IANA name"), errs)
+		return
+	}
+	from, to := window.Start, window.End
+
+	if !to.After(from) || to.Sub(from) > maxAvailabilityDays*24*time.Hour {
+		app.errorJSON(w, fmt.Errorf("the range must cover between 1 and %d days", maxAvailabilityDays), nil)
+		return
+	}
+
+	item, err := app.getInventory(inventoryID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	periods, err := app.bookedPeriods(inventoryID, from, to)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	stock := max(item.GetQuantity(), 1)
+	if item.GetIsAvailable() == string(Unavailable) {
+		stock = 0
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "inventory availability retrieved",
+		Data: map[string]interface{}{
+			"inventory_id":   inventoryID,
+			"stock_quantity": stock,
+			// per day counts only, the bookings themselves belong to other renters
+			"slots": availabilityCalendar(periods, stock, from, to),
+		},
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeakBooked(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 6, d, 0, 0, 0, 0, time.UTC) }
+
+	periods := []BookedPeriod{
+		{BookingID: "a", Start: day(1), End: day(4), Quantity: 2},
+		{BookingID: "b", Start: day(3), End: day(6), Quantity: 1},
+		{BookingID: "c", Start: day(6), End: day(8), Quantity: 3},
+	}
+
+	t.Log("Checking overlapping bookings add up")
+	assert.Equal(t, 3.0, peakBooked(periods, day(1), day(6)))
+
+	t.Log("Checking back to back bookings don't overlap")
+	assert.Equal(t, 3.0, peakBooked(periods, day(5), day(8)))
+	assert.Equal(t, 1.0, peakBooked(periods, day(4), day(6)))
+	assert.Equal(t, 0.0, peakBooked(periods, day(8), day(9)))
+
+	t.Log("Checking the calendar reports free stock per day")
+	slots := availabilityCalendar(periods, 3, day(2), day(5))
+	assert.Len(t, slots, 3)
+	assert.Equal(t, AvailabilitySlot{Date: "2025-06-02", Booked: 2, Free: 1}, slots[0])
+	assert.Equal(t, AvailabilitySlot{Date: "2025-06-03", Booked: 3, Free: 0}, slots[1])
+	assert.Equal(t, AvailabilitySlot{Date: "2025-06-04", Booked: 1, Free: 2}, slots[2])
+}
+
+func TestLockAvailability(t *testing.T) {
+	app := Config{cache: newTestRedis(t)}
+
+	t.Log("Checking a second accept on the same inventory is told to retry with 409")
+	unlock, err := app.lockAvailability(context.Background(), "inventory-1")
+	assert.NoError(t, err)
+	_, err = app.lockAvailability(context.Background(), "inventory-1")
+	assert.ErrorIs(t, err, ErrInventoryBusy)
+	assert.Equal(t, http.StatusConflict, availabilityErrorStatus(err))
+
+	t.Log("Checking other inventories are not blocked and the lock is free once released")
+	_, err = app.lockAvailability(context.Background(), "inventory-2")
+	assert.NoError(t, err)
+	unlock()
+	_, err = app.lockAvailability(context.Background(), "inventory-1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusConflict, availabilityErrorStatus(ErrSlotUnavailable))
+	assert.Equal(t, http.StatusInternalServerError, availabilityErrorStatus(errors.New("inventory service down")))
+}
@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+
+	"github.com/obynonwane/broker-service/event"
 )
 
 type CreateBookingPayload struct {
@@ -23,9 +25,10 @@ type CreateBookingPayload struct {
 
 	StartDate   string  `json:"start_date" binding:"required"` // e.g., "2025-06-15"
 	EndDate     string  `json:"end_date" binding:"required"`   // e.g., "2025-06-15"
-	EndTime     string  `json:"end_time" binding:"required"`   // e.g., "18:00:00", optional for daily+ rentals
-	StartTime   string  `json:"start_time" binding:"required"` // e.g., "18:00:00", optional for daily+ rentals
+	EndTime     string  `json:"end_time" binding:"required"`   // e.g., "18:00" or "18:00:00", optional for daily+ rentals
+	StartTime   string  `json:"start_time" binding:"required"` // e.g., "18:00" or "18:00:00", optional for daily+ rentals
 	TotalAmount float64 `json:"total_amount" binding:"required"`
+	Timezone    string  `json:"timezone"` // IANA name, defaults to the user's timezone then Africa/Lagos
 }
 
 func (app *Config) CreateBooking(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +57,10 @@ func (app *Config) CreateBooking(w http.ResponseWriter, r *http.Request) {
 
 	log.Println(requestPayload, "THE payload")
 
+	if requestPayload.Timezone == "" {
+		requestPayload.Timezone = userTimezone(user)
+	}
+
 	// Validate the request payload
 	if err := app.ValidateBookingInput(requestPayload); len(err) > 0 {
 		app.errorJSON(w, errors.New("error trying to create booking"), err, http.StatusBadRequest)
@@ -63,6 +70,50 @@ func (app *Config) CreateBooking(w http.ResponseWriter, r *http.Request) {
 	userId := user.Data.(map[string]interface{})["user"].(map[string]interface{})["id"].(string)
 	requestPayload.RenterId = userId
 
+	// price the booking ourselves, the client totals must match
+	quote, err := app.quoteBooking(BookingQuotePayload{
+		InventoryId:       requestPayload.InventoryId,
+		RentalType:        requestPayload.RentalType,
+		OfferPricePerUnit: requestPayload.OfferPricePerUnit,
+		Quantity:          requestPayload.Quantity,
+		StartDate:         requestPayload.StartDate,
+		EndDate:           requestPayload.EndDate,
+		StartTime:         requestPayload.StartTime,
+		EndTime:           requestPayload.EndTime,
+		Timezone:          requestPayload.Timezone,
+	})
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusBadRequest)
+		return
+	}
+
+	if mismatch := quote.checkSubmittedTotals(requestPayload.RentalDuration, requestPayload.SecurityDeposit, requestPayload.TotalAmount); len(mismatch) > 0 {
+		app.errorJSON(w, errors.New("booking totals do not match the quote"), map[string]interface{}{"errors": mismatch, "quote": quote}, http.StatusBadRequest)
+		return
+	}
+
+	if quote.OwnerID == userId {
+		app.errorJSON(w, errors.New("you can not book your own inventory"), nil, http.StatusBadRequest)
+		return
+	}
+
+	// the owner and price come from the inventory, not the client
+	requestPayload.OwnerId = quote.OwnerID
+	requestPayload.OfferPricePerUnit = quote.UnitPrice
+
+	// turn the renter away early if the period is already taken, the bookings are only known once the
+	// inventory service serves them and the accept checks again either way
+	if upstreamEnabled("inventory-bookings") {
+		err = app.checkQuoteAvailable(quote)
+		if errors.Is(err, ErrSlotUnavailable) {
+			app.errorJSON(w, err, nil, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("[AVAILABILITY] checking inventory %s failed: %v", quote.InventoryID, err)
+		}
+	}
+
 	//create some json we will send to authservice
 	jsonData, _ := json.MarshalIndent(requestPayload, "", "\t")
 
@@ -104,6 +155,18 @@ func (app *Config) CreateBooking(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// record the event before answering, the outbox relay publishes it
+	app.recordEventOrPublish(r.Context(), event.BookingCreatedEvent{
+		BookingID:   createdID(jsonFromService.Data),
+		InventoryID: requestPayload.InventoryId,
+		RenterID:    requestPayload.RenterId,
+		OwnerID:     requestPayload.OwnerId,
+		StartDate:   requestPayload.StartDate,
+		EndDate:     requestPayload.EndDate,
+		Quantity:    requestPayload.Quantity,
+		TotalAmount: requestPayload.TotalAmount,
+	}, correlationID(r))
+
 	var payload jsonResponse
 	payload.Error = jsonFromService.Error
 	payload.StatusCode = jsonFromService.StatusCode
@@ -212,7 +275,7 @@ func (app *Config) MyBookings(w http.ResponseWriter, r *http.Request) {
 	payload.Error = jsonFromService.Error
 	payload.StatusCode = jsonFromService.StatusCode
 	payload.Message = jsonFromService.Message
-	payload.Data = jsonFromService.Data
+	payload.Data = app.attachBookingChanges(r.Context(), jsonFromService.Data)
 
 	app.writeJSON(w, http.StatusOK, payload)
 
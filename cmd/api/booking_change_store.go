@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrBookingChangeNotFound is returned for an unknown change id
+var ErrBookingChangeNotFound = errors.New("booking change not found")
+
+// BookingChangeStore keeps every change requested on a booking, they are never deleted
+type BookingChangeStore interface {
+	// Save stores c, saving a change with an existing id replaces it
+	Save(ctx context.Context, c BookingChange) error
+	Get(ctx context.Context, changeID string) (BookingChange, error)
+	// History returns the changes of a booking, oldest first
+	History(ctx context.Context, bookingID string) ([]BookingChange, error)
+	// Histories returns the History of each of bookingIDs at once, a booking without changes gets an empty list
+	Histories(ctx context.Context, bookingIDs []string) (map[string][]BookingChange, error)
+}
+
+// redisBookingChangeStore keeps the changes of a booking in a hash,
+// with a key per change pointing back at its booking
+type redisBookingChangeStore struct {
+	cache *redis.Client
+}
+
+func bookingChangesKey(bookingID string) string {
+	return fmt.Sprintf("booking:changes:%s", bookingID)
+}
+
+func bookingChangeKey(changeID string) string {
+	return fmt.Sprintf("booking:change:%s", changeID)
+}
+
+func (s redisBookingChangeStore) Save(ctx context.Context, c BookingChange) error {
+	rawChange, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.cache.TxPipeline()
+	pipe.HSet(ctx, bookingChangesKey(c.BookingID), c.ID, rawChange)
+	pipe.Set(ctx, bookingChangeKey(c.ID), c.BookingID, 0)
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+func (s redisBookingChangeStore) Get(ctx context.Context, changeID string) (BookingChange, error) {
+	bookingID, err := s.cache.Get(ctx, bookingChangeKey(changeID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return BookingChange{}, ErrBookingChangeNotFound
+	}
+	if err != nil {
+		return BookingChange{}, err
+	}
+
+	rawChange, err := s.cache.HGet(ctx, bookingChangesKey(bookingID), changeID).Result()
+	if errors.Is(err, redis.Nil) {
+		return BookingChange{}, ErrBookingChangeNotFound
+	}
+	if err != nil {
+		return BookingChange{}, err
+	}
+
+	var c BookingChange
+	err = json.Unmarshal([]byte(rawChange), &c)
+
+	return c, err
+}
+
+func (s redisBookingChangeStore) History(ctx context.Context, bookingID string) ([]BookingChange, error) {
+	raw, err := s.cache.HGetAll(ctx, bookingChangesKey(bookingID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeBookingChanges(raw), nil
+}
+
+func (s redisBookingChangeStore) Histories(ctx context.Context, bookingIDs []string) (map[string][]BookingChange, error) {
+	pipe := s.cache.Pipeline()
+	cmds := make(map[string]*redis.MapStringStringCmd, len(bookingIDs))
+	for _, bookingID := range bookingIDs {
+		cmds[bookingID] = pipe.HGetAll(ctx, bookingChangesKey(bookingID))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	histories := make(map[string][]BookingChange, len(bookingIDs))
+	for bookingID, cmd := range cmds {
+		histories[bookingID] = decodeBookingChanges(cmd.Val())
+	}
+
+	return histories, nil
+}
+
+// decodeBookingChanges reads the changes of a booking's hash oldest first, unreadable ones are skipped
+func decodeBookingChanges(raw map[string]string) []BookingChange {
+	changes := make([]BookingChange, 0, len(raw))
+	for _, rawChange := range raw {
+		var c BookingChange
+		if err := json.Unmarshal([]byte(rawChange), &c); err != nil {
+			continue
+		}
+		changes = append(changes, c)
+	}
+
+	sortBookingChanges(changes)
+	return changes
+}
+
+func sortBookingChanges(changes []BookingChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].CreatedAt == changes[j].CreatedAt {
+			return changes[i].ID < changes[j].ID
+		}
+		return changes[i].CreatedAt < changes[j].CreatedAt
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryBookingChangeStore is a stand-in store used in tests
+type memoryBookingChangeStore struct {
+	mu      sync.Mutex
+	changes map[string]BookingChange
+}
+
+func newMemoryBookingChangeStore() *memoryBookingChangeStore {
+	return &memoryBookingChangeStore{changes: make(map[string]BookingChange)}
+}
+
+func (s *memoryBookingChangeStore) Save(ctx context.Context, c BookingChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.changes[c.ID] = c
+	return nil
+}
+
+func (s *memoryBookingChangeStore) Get(ctx context.Context, changeID string) (BookingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.changes[changeID]
+	if !ok {
+		return BookingChange{}, ErrBookingChangeNotFound
+	}
+
+	return c, nil
+}
+
+func (s *memoryBookingChangeStore) History(ctx context.Context, bookingID string) ([]BookingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := []BookingChange{}
+	for _, c := range s.changes {
+		if c.BookingID == bookingID {
+			changes = append(changes, c)
+		}
+	}
+
+	sortBookingChanges(changes)
+	return changes, nil
+}
+
+func (s *memoryBookingChangeStore) Histories(ctx context.Context, bookingIDs []string) (map[string][]BookingChange, error) {
+	histories := make(map[string][]BookingChange, len(bookingIDs))
+	for _, bookingID := range bookingIDs {
+		history, err := s.History(ctx, bookingID)
+		if err != nil {
+			return nil, err
+		}
+		histories[bookingID] = history
+	}
+	return histories, nil
+}
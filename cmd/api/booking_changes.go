@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/obynonwane/broker-service/event"
+)
+
+type BookingChangeStatus string
+
+const (
+	ChangePending   BookingChangeStatus = "pending"
+	ChangeApproved  BookingChangeStatus = "approved"
+	ChangeDeclined  BookingChangeStatus = "declined"
+	ChangeWithdrawn BookingChangeStatus = "withdrawn"
+)
+
+// bookingChangeLockTTL bounds how long one change request or decision can block others on the same booking,
+// it covers the calls to the inventory service
+const bookingChangeLockTTL = 30 * time.Second
+
+// kinds of booking change
+const (
+	BookingExtension  = "extension"  // same start, later end
+	BookingReschedule = "reschedule" // any other date change
+)
+
+// changeableBookingStatuses are the booking states dates can still be changed in
+var changeableBookingStatuses = []BookingStatus{BookingPending, BookingAccepted, BookingActive}
+
+// BookingChange is a date change a renter requested on a booking, kept as its history
+type BookingChange struct {
+	ID             string              `json:"id"`
+	BookingID      string              `json:"booking_id"`
+	Kind           string              `json:"kind"`
+	Status         BookingChangeStatus `json:"status"`
+	RequestedBy    string              `json:"requested_by"`
+	Reason         string              `json:"reason,omitempty"`
+	From           BookingTimes        `json:"from"`
+	To             BookingTimes        `json:"to"`
+	RentalDuration float64             `json:"rental_duration"`
+	PreviousTotal  float64             `json:"previous_total"`
+	TotalAmount    float64             `json:"total_amount"`
+	PriceDelta     float64             `json:"price_delta"` // what the renter pays extra, negative is a refund
+	DecidedBy      string              `json:"decided_by,omitempty"`
+	DecisionReason string              `json:"decision_reason,omitempty"`
+	CreatedAt      int64               `json:"created_at"`
+	DecidedAt      int64               `json:"decided_at,omitempty"`
+}
+
+// classifyBookingChange checks the requested window against the booking and names the change.
+// An active booking has already started so it can only be extended.
+func classifyBookingChange(booking BookingSnapshot, current, requested BookingWindow) (string, error) {
+	if !slices.Contains(changeableBookingStatuses, booking.Status) {
+		return "", fmt.Errorf("a %s booking can not be changed", booking.Status)
+	}
+
+	sameStart := requested.Start.Equal(current.Start)
+	if sameStart && requested.End.Equal(current.End) {
+		return "", errors.New("the new dates are the same as the booking")
+	}
+
+	if sameStart && requested.End.After(current.End) {
+		return BookingExtension, nil
+	}
+
+	if booking.Status == BookingActive {
+		return "", errors.New("an active booking can only be extended")
+	}
+
+	return BookingReschedule, nil
+}
+
+// priceBookingChange prices the booking for its new length at the agreed unit price,
+// and returns the new total and the difference to what was already agreed
+func priceBookingChange(booking BookingSnapshot, units float64) (float64, float64) {
+	subtotal := roundMoney(booking.OfferPricePerUnit * units * booking.Quantity)
+	total := roundMoney(subtotal + booking.SecurityDeposit)
+
+	return total, roundMoney(total - booking.TotalAmount)
+}
+
+type BookingChangePayload struct {
+	BookingID string `json:"booking_id"`
+	StartDate string `json:"start_date"`
+	StartTime string `json:"start_time"`
+	EndDate   string `json:"end_date"`
+	EndTime   string `json:"end_time"`
+	Timezone  string `json:"timezone"`
+	Reason    string `json:"reason"`
+}
+
+type BookingChangeDecisionPayload struct {
+	ChangeID string `json:"change_id"`
+	Reason   string `json:"reason"`
+}
+
+type UpdateBookingDatesPayload struct {
+	BookingID      string        `json:"booking_id"`
+	ChangeID       string        `json:"change_id"`
+	UserID         string        `json:"user_id"`
+	FromStatus     BookingStatus `json:"from_status"` // lets the inventory service reject a concurrent change
+	StartDate      string        `json:"start_date"`
+	StartTime      string        `json:"start_time"`
+	EndDate        string        `json:"end_date"`
+	EndTime        string        `json:"end_time"`
+	Timezone       string        `json:"timezone"`
+	RentalDuration float64       `json:"rental_duration"`
+	TotalAmount    float64       `json:"total_amount"`
+}
+
+// RequestBookingChange lets the renter ask for an extension or new dates, the owner has to approve it
+func (app *Config) RequestBookingChange(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload BookingChangePayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateBookingChangeInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to request booking change"), err, http.StatusBadRequest)
+		return
+	}
+
+	if app.bookingChanges == nil {
+		app.errorJSON(w, errors.New("booking changes are not available"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// one change at a time per booking, the pending change check below relies on it
+	unlock, err := app.lockBookingChanges(r.Context(), requestPayload.BookingID)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	booking, err := app.getBookingSnapshot(requestPayload.BookingID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if booking.RenterID != userId {
+		app.errorJSON(w, errors.New("only the renter can change a booking"), nil, http.StatusForbidden)
+		return
+	}
+
+	// the new dates are read in the booking's timezone unless another one is given
+	if requestPayload.Timezone == "" {
+		requestPayload.Timezone = booking.Timezone
+	}
+	if requestPayload.Timezone == "" {
+		requestPayload.Timezone = userTimezone(user)
+	}
+
+	requestedTimes := BookingTimes{
+		StartDate: requestPayload.StartDate,
+		StartTime: requestPayload.StartTime,
+		EndDate:   requestPayload.EndDate,
+		EndTime:   requestPayload.EndTime,
+		Timezone:  requestPayload.Timezone,
+	}
+
+	current, errs := booking.Times().Window()
+	if len(errs) > 0 {
+		app.errorJSON(w, firstError(errs), nil)
+		return
+	}
+
+	requested, errs := requestedTimes.Window()
+	if len(errs) > 0 {
+		app.errorJSON(w, errors.New("error trying to request booking change"), errs, http.StatusBadRequest)
+		return
+	}
+
+	kind, err := classifyBookingChange(booking, current, requested)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	// an active booking started already, only its new end has to be in the future
+	now := time.Now()
+	if booking.Status == BookingActive {
+		if !requested.End.After(now) {
+			app.errorJSON(w, errors.New("the booking must be extended past now"), nil, http.StatusBadRequest)
+			return
+		}
+		now = current.Start
+	}
+
+	_, units, errs := validateBookingWindow(RentalDuration(booking.RentalType), requestedTimes, now)
+	if len(errs) > 0 {
+		app.errorJSON(w, errors.New("error trying to request booking change"), errs, http.StatusBadRequest)
+		return
+	}
+
+	history, err := app.bookingChanges.History(r.Context(), booking.ID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if slices.ContainsFunc(history, func(c BookingChange) bool { return c.Status == ChangePending }) {
+		app.errorJSON(w, errors.New("this booking already has a pending change"), nil, http.StatusConflict)
+		return
+	}
+
+	// a pending change takes no stock, this turns the renter away early and approving checks again
+	err = app.checkBookingCapacityLocked(r.Context(), booking, requested)
+	if err != nil {
+		app.errorJSON(w, err, nil, availabilityErrorStatus(err))
+		return
+	}
+
+	total, delta := priceBookingChange(booking, units)
+
+	change := BookingChange{
+		ID:             uuid.NewString(),
+		BookingID:      booking.ID,
+		Kind:           kind,
+		Status:         ChangePending,
+		RequestedBy:    userId,
+		Reason:         requestPayload.Reason,
+		From:           booking.Times(),
+		To:             requestedTimes,
+		RentalDuration: units,
+		PreviousTotal:  booking.TotalAmount,
+		TotalAmount:    total,
+		PriceDelta:     delta,
+		CreatedAt:      time.Now().UnixMilli(),
+	}
+
+	err = app.bookingChanges.Save(r.Context(), change)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	app.recordBookingChange(r.Context(), booking, change, userId, correlationID(r))
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "booking change requested",
+		Data:       change,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// bookingChangeDecision is what the owner or renter can do with a pending change
+type bookingChangeDecision struct {
+	Name  string
+	To    BookingChangeStatus
+	Actor string
+}
+
+var (
+	approveBookingChange  = bookingChangeDecision{Name: "approve", To: ChangeApproved, Actor: bookingOwner}
+	declineBookingChange  = bookingChangeDecision{Name: "decline", To: ChangeDeclined, Actor: bookingOwner}
+	withdrawBookingChange = bookingChangeDecision{Name: "withdraw", To: ChangeWithdrawn, Actor: bookingRenter}
+)
+
+func (app *Config) ApproveBookingChange(w http.ResponseWriter, r *http.Request) {
+	app.decideBookingChange(w, r, approveBookingChange)
+}
+
+func (app *Config) DeclineBookingChange(w http.ResponseWriter, r *http.Request) {
+	app.decideBookingChange(w, r, declineBookingChange)
+}
+
+func (app *Config) WithdrawBookingChange(w http.ResponseWriter, r *http.Request) {
+	app.decideBookingChange(w, r, withdrawBookingChange)
+}
+
+// decideBookingChange closes a pending change, an approved change is applied in the inventory service
+func (app *Config) decideBookingChange(w http.ResponseWriter, r *http.Request, decision bookingChangeDecision) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload BookingChangeDecisionPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateBookingChangeDecisionInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, fmt.Errorf("error trying to %s booking change", decision.Name), err, http.StatusBadRequest)
+		return
+	}
+
+	if app.bookingChanges == nil {
+		app.errorJSON(w, errors.New("booking changes are not available"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	change, err := app.bookingChanges.Get(r.Context(), requestPayload.ChangeID)
+	if errors.Is(err, ErrBookingChangeNotFound) {
+		app.errorJSON(w, err, nil, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	unlock, err := app.lockBookingChanges(r.Context(), change.BookingID)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	// read the change again now that no one else can decide it
+	change, err = app.bookingChanges.Get(r.Context(), requestPayload.ChangeID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	booking, err := app.getBookingSnapshot(change.BookingID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if (decision.Actor == bookingOwner && booking.OwnerID != userId) || (decision.Actor == bookingRenter && booking.RenterID != userId) {
+		app.errorJSON(w, fmt.Errorf("only the %s can %s a booking change", decision.Actor, decision.Name), nil, http.StatusForbidden)
+		return
+	}
+
+	if change.Status != ChangePending {
+		app.errorJSON(w, fmt.Errorf("a %s booking change can not be %s", change.Status, decision.To), nil, http.StatusConflict)
+		return
+	}
+
+	if decision.To == ChangeApproved {
+		err = app.applyBookingChange(r.Context(), booking, change, userId)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrSlotUnavailable) || errors.Is(err, ErrInventoryBusy) {
+				status = http.StatusConflict
+			}
+			app.errorJSON(w, err, nil, status)
+			return
+		}
+	}
+
+	change.Status = decision.To
+	change.DecidedBy = userId
+	change.DecisionReason = requestPayload.Reason
+	change.DecidedAt = time.Now().UnixMilli()
+
+	err = app.bookingChanges.Save(r.Context(), change)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	app.recordBookingChange(r.Context(), booking, change, userId, correlationID(r))
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("booking change %s", decision.To),
+		Data:       change,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// lockBookingChanges keeps two change requests or decisions on a booking from racing, call the returned func to unlock
+func (app *Config) lockBookingChanges(ctx context.Context, bookingID string) (func(), error) {
+	unlock, err := app.acquireLock(ctx, fmt.Sprintf("booking:changes:lock:%s", bookingID), bookingChangeLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return nil, errors.New("this booking is being changed, please try again")
+	}
+
+	return unlock, err
+}
+
+// checkBookingCapacityLocked runs checkBookingCapacity under the availability lock of the booking's inventory
+func (app *Config) checkBookingCapacityLocked(ctx context.Context, booking BookingSnapshot, window BookingWindow) error {
+	unlock, err := app.lockAvailability(ctx, booking.InventoryID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return app.checkBookingCapacity(booking, window)
+}
+
+// applyBookingChange re-checks the change against the booking as it is now and updates its dates,
+// the capacity check and the update run under the availability lock so an accept can't take the stock between them
+func (app *Config) applyBookingChange(ctx context.Context, booking BookingSnapshot, change BookingChange, userId string) error {
+	current, errs := booking.Times().Window()
+	if len(errs) > 0 {
+		return firstError(errs)
+	}
+
+	requested, errs := change.To.Window()
+	if len(errs) > 0 {
+		return firstError(errs)
+	}
+
+	// the booking may have moved on since the change was requested
+	if _, err := classifyBookingChange(booking, current, requested); err != nil {
+		return err
+	}
+
+	unlock, err := app.lockAvailability(ctx, booking.InventoryID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = app.checkBookingCapacity(booking, requested)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "update-booking-dates"), UpdateBookingDatesPayload{
+		BookingID:      booking.ID,
+		ChangeID:       change.ID,
+		UserID:         userId,
+		FromStatus:     booking.Status,
+		StartDate:      change.To.StartDate,
+		StartTime:      change.To.StartTime,
+		EndDate:        change.To.EndDate,
+		EndTime:        change.To.EndTime,
+		Timezone:       change.To.Timezone,
+		RentalDuration: change.RentalDuration,
+		TotalAmount:    change.TotalAmount,
+	})
+
+	return err
+}
+
+func (app *Config) recordBookingChange(ctx context.Context, booking BookingSnapshot, change BookingChange, userId, corrID string) {
+	app.recordEventOrPublish(ctx, event.BookingChangeUpdatedEvent{
+		ChangeID:   change.ID,
+		BookingID:  booking.ID,
+		RenterID:   booking.RenterID,
+		OwnerID:    booking.OwnerID,
+		Kind:       change.Kind,
+		Status:     string(change.Status),
+		StartDate:  change.To.StartDate,
+		EndDate:    change.To.EndDate,
+		PriceDelta: change.PriceDelta,
+		ChangedBy:  userId,
+	}, corrID)
+}
+
+// GetBookingChanges returns the change history of a booking to its renter or owner
+func (app *Config) GetBookingChanges(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query param
+	bookingID := r.URL.Query().Get("booking_id")
+	if bookingID == "" {
+		app.errorJSON(w, errors.New("booking_id not supplied"), nil)
+		return
+	}
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	if app.bookingChanges == nil {
+		app.errorJSON(w, errors.New("booking changes are not available"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	booking, err := app.getBookingSnapshot(bookingID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if booking.RenterID != userId && booking.OwnerID != userId {
+		app.errorJSON(w, errors.New("you are not a party to this booking"), nil, http.StatusForbidden)
+		return
+	}
+
+	history, err := app.bookingChanges.History(r.Context(), booking.ID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "booking changes retrieved",
+		Data:       history,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// attachBookingChanges adds a "changes" list to every booking in data, a list of bookings
+// or an object holding one, e.g. a page from the inventory service
+func (app *Config) attachBookingChanges(ctx context.Context, data any) any {
+	if app.bookingChanges == nil {
+		return data
+	}
+
+	switch value := data.(type) {
+	case []interface{}:
+		bookings := make(map[string]map[string]interface{}, len(value))
+		bookingIDs := make([]string, 0, len(value))
+		for _, item := range value {
+			booking, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			bookingID, ok := booking["id"].(string)
+			if !ok || bookingID == "" {
+				continue
+			}
+
+			bookings[bookingID] = booking
+			bookingIDs = append(bookingIDs, bookingID)
+		}
+		if len(bookingIDs) == 0 {
+			return data
+		}
+
+		histories, err := app.bookingChanges.Histories(ctx, bookingIDs)
+		if err != nil {
+			log.Printf("[BOOKING] loading changes of %d bookings failed: %v", len(bookingIDs), err)
+			return data
+		}
+		for bookingID, booking := range bookings {
+			booking["changes"] = histories[bookingID]
+		}
+	case map[string]interface{}:
+		for _, field := range value {
+			if list, ok := field.([]interface{}); ok {
+				app.attachBookingChanges(ctx, list)
+			}
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyBookingChange(t *testing.T) {
+	current, _ := BookingTimes{StartDate: "2030-06-15", EndDate: "2030-06-17"}.Window()
+	extended, _ := BookingTimes{StartDate: "2030-06-15", EndDate: "2030-06-20"}.Window()
+	moved, _ := BookingTimes{StartDate: "2030-06-18", EndDate: "2030-06-20"}.Window()
+
+	booking := BookingSnapshot{ID: "booking-1", Status: BookingAccepted}
+
+	t.Log("Checking a later end on the same start is an extension")
+	kind, err := classifyBookingChange(booking, current, extended)
+	assert.NoError(t, err)
+	assert.Equal(t, BookingExtension, kind)
+
+	t.Log("Checking other date changes are reschedules")
+	kind, err = classifyBookingChange(booking, current, moved)
+	assert.NoError(t, err)
+	assert.Equal(t, BookingReschedule, kind)
+
+	t.Log("Checking unchanged dates are refused")
+	_, err = classifyBookingChange(booking, current, current)
+	assert.Error(t, err)
+
+	t.Log("Checking an active booking can only be extended")
+	booking.Status = BookingActive
+	_, err = classifyBookingChange(booking, current, moved)
+	assert.Error(t, err)
+	_, err = classifyBookingChange(booking, current, extended)
+	assert.NoError(t, err)
+
+	t.Log("Checking a finished booking can not be changed")
+	booking.Status = BookingCompleted
+	_, err = classifyBookingChange(booking, current, extended)
+	assert.Error(t, err)
+}
+
+func TestPriceBookingChange(t *testing.T) {
+	booking := BookingSnapshot{OfferPricePerUnit: 1000, Quantity: 2, SecurityDeposit: 1000, TotalAmount: 7000}
+
+	t.Log("Checking the delta is priced at the agreed unit price")
+	total, delta := priceBookingChange(booking, 5)
+	assert.Equal(t, 11000.0, total)
+	assert.Equal(t, 4000.0, delta)
+
+	total, delta = priceBookingChange(booking, 2)
+	assert.Equal(t, 5000.0, total)
+	assert.Equal(t, -2000.0, delta)
+}
+
+func TestAttachBookingChanges(t *testing.T) {
+	for name, store := range map[string]BookingChangeStore{
+		"memory": newMemoryBookingChangeStore(),
+		"redis":  redisBookingChangeStore{cache: newTestRedis(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := Config{bookingChanges: store}
+			ctx := context.Background()
+
+			_ = store.Save(ctx, BookingChange{ID: "change-2", BookingID: "booking-1", Status: ChangePending, CreatedAt: 2})
+			_ = store.Save(ctx, BookingChange{ID: "change-1", BookingID: "booking-1", Status: ChangeDeclined, CreatedAt: 1})
+
+			t.Log("Checking the history is attached to each booking, oldest first")
+			data := map[string]interface{}{
+				"bookings": []interface{}{
+					map[string]interface{}{"id": "booking-1"},
+					map[string]interface{}{"id": "booking-2"},
+				},
+			}
+			app.attachBookingChanges(ctx, data)
+
+			bookings := data["bookings"].([]interface{})
+			history := bookings[0].(map[string]interface{})["changes"].([]BookingChange)
+			assert.Len(t, history, 2)
+			assert.Equal(t, "change-1", history[0].ID)
+			assert.Empty(t, bookings[1].(map[string]interface{})["changes"])
+
+			t.Log("Checking an unknown change is reported")
+			_, err := store.Get(ctx, "missing")
+			assert.ErrorIs(t, err, ErrBookingChangeNotFound)
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/obynonwane/broker-service/event"
+)
+
+type BookingStatus string
+
+const (
+	BookingPending   BookingStatus = "pending"
+	BookingAccepted  BookingStatus = "accepted"
+	BookingActive    BookingStatus = "active"
+	BookingCompleted BookingStatus = "completed"
+	BookingCancelled BookingStatus = "cancelled"
+	BookingRejected  BookingStatus = "rejected"
+	BookingExpired   BookingStatus = "expired"
+)
+
+// the party of a booking allowed to perform an action
+const (
+	bookingOwner  = "owner"
+	bookingRenter = "renter"
+	bookingSystem = "system" // scheduled jobs, not a user
+)
+
+// ReasonOther needs a free text reason alongside it
+const ReasonOther = "other"
+
+// BookingAction is a lifecycle transition a user can request
+type BookingAction struct {
+	Name        string
+	From        []BookingStatus
+	To          BookingStatus
+	Actor       string
+	ReasonCodes []string // empty means no reason is needed
+}
+
+var (
+	AcceptBookingAction = BookingAction{
+		Name:  "accept",
+		From:  []BookingStatus{BookingPending},
+		To:    BookingAccepted,
+		Actor: bookingOwner,
+	}
+	RejectBookingAction = BookingAction{
+		Name:        "reject",
+		From:        []BookingStatus{BookingPending},
+		To:          BookingRejected,
+		Actor:       bookingOwner,
+		ReasonCodes: []string{"unavailable", "dates_conflict", "renter_unverified", ReasonOther},
+	}
+	CancelBookingAction = BookingAction{
+		Name:        "cancel",
+		From:        []BookingStatus{BookingPending, BookingAccepted},
+		To:          BookingCancelled,
+		Actor:       bookingRenter,
+		ReasonCodes: []string{"change_of_plans", "found_alternative", "owner_unresponsive", ReasonOther},
+	}
+	StartBookingAction = BookingAction{
+		Name:  "start",
+		From:  []BookingStatus{BookingAccepted},
+		To:    BookingActive,
+		Actor: bookingOwner,
+	}
+	CompleteBookingAction = BookingAction{
+		Name:  "complete",
+		From:  []BookingStatus{BookingActive},
+		To:    BookingCompleted,
+		Actor: bookingOwner,
+	}
+	// ExpireBookingAction is taken by the scheduler on requests the owner never answered
+	ExpireBookingAction = BookingAction{
+		Name:  "expire",
+		From:  []BookingStatus{BookingPending},
+		To:    BookingExpired,
+		Actor: bookingSystem,
+	}
+)
+
+// BookingSnapshot is the part of a booking the lifecycle rules need
+type BookingSnapshot struct {
+	ID          string        `json:"id"`
+	InventoryID string        `json:"inventory_id"`
+	RenterID    string        `json:"renter_id"`
+	OwnerID     string        `json:"owner_id"`
+	Status      BookingStatus `json:"status"`
+	StartDate   string        `json:"start_date"`
+	EndDate     string        `json:"end_date"`
+	StartTime   string        `json:"start_time"`
+	EndTime     string        `json:"end_time"`
+	Quantity    float64       `json:"quantity"`
+	Timezone    string        `json:"timezone"`
+
+	RentalType        string  `json:"rental_type"`
+	OfferPricePerUnit float64 `json:"offer_price_per_unit"`
+	SecurityDeposit   float64 `json:"security_deposit"`
+	TotalAmount       float64 `json:"total_amount"`
+}
+
+// Times returns the raw date and time fields of the booking
+func (b BookingSnapshot) Times() BookingTimes {
+	return BookingTimes{
+		StartDate: b.StartDate,
+		StartTime: b.StartTime,
+		EndDate:   b.EndDate,
+		EndTime:   b.EndTime,
+		Timezone:  b.Timezone,
+	}
+}
+
+// validateBookingTransition checks that userID may perform action on booking
+func validateBookingTransition(action BookingAction, booking BookingSnapshot, userID, reasonCode, reason string) error {
+	switch action.Actor {
+	case bookingOwner:
+		if booking.OwnerID != userID {
+			return fmt.Errorf("only the owner can %s a booking", action.Name)
+		}
+	case bookingRenter:
+		if booking.RenterID != userID {
+			return fmt.Errorf("only the renter can %s a booking", action.Name)
+		}
+	}
+
+	if !slices.Contains(action.From, booking.Status) {
+		return fmt.Errorf("a %s booking can not be %s", booking.Status, action.To)
+	}
+
+	if len(action.ReasonCodes) == 0 {
+		return nil
+	}
+
+	if !slices.Contains(action.ReasonCodes, reasonCode) {
+		return fmt.Errorf("reason_code must be one of %v", action.ReasonCodes)
+	}
+
+	if reasonCode == ReasonOther && reason == "" {
+		return errors.New("reason is required when reason_code is other")
+	}
+
+	return nil
+}
+
+type BookingTransitionPayload struct {
+	BookingID  string `json:"booking_id"`
+	ReasonCode string `json:"reason_code"`
+	Reason     string `json:"reason"`
+}
+
+type UpdateBookingStatusPayload struct {
+	BookingID  string        `json:"booking_id"`
+	UserID     string        `json:"user_id"`
+	FromStatus BookingStatus `json:"from_status"` // lets the inventory service reject a concurrent change
+	Status     BookingStatus `json:"status"`
+	ReasonCode string        `json:"reason_code,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+}
+
+func (app *Config) AcceptBooking(w http.ResponseWriter, r *http.Request) {
+	app.transitionBooking(w, r, AcceptBookingAction)
+}
+
+func (app *Config) RejectBooking(w http.ResponseWriter, r *http.Request) {
+	app.transitionBooking(w, r, RejectBookingAction)
+}
+
+func (app *Config) CancelBooking(w http.ResponseWriter, r *http.Request) {
+	app.transitionBooking(w, r, CancelBookingAction)
+}
+
+func (app *Config) StartBooking(w http.ResponseWriter, r *http.Request) {
+	app.transitionBooking(w, r, StartBookingAction)
+}
+
+func (app *Config) CompleteBooking(w http.ResponseWriter, r *http.Request) {
+	app.transitionBooking(w, r, CompleteBookingAction)
+}
+
+// transitionBooking validates action against the current booking, applies it in the
+// inventory service and records a booking.status_changed event
+func (app *Config) transitionBooking(w http.ResponseWriter, r *http.Request, action BookingAction) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload BookingTransitionPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateBookingTransitionInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, fmt.Errorf("error trying to %s booking", action.Name), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	booking, err := app.getBookingSnapshot(requestPayload.BookingID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	err = validateBookingTransition(action, booking, userId, requestPayload.ReasonCode, requestPayload.Reason)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	// accepting takes stock, make sure an overlapping accepted booking didn't already take it. The check and the
+	// update run under the availability lock so two overlapping accepts can't both pass
+	if action.To == BookingAccepted {
+		window, errs := booking.Times().Window()
+		if len(errs) > 0 {
+			app.errorJSON(w, firstError(errs), nil)
+			return
+		}
+
+		unlock, err := app.lockAvailability(r.Context(), booking.InventoryID)
+		if err != nil {
+			app.errorJSON(w, err, nil, availabilityErrorStatus(err))
+			return
+		}
+		defer unlock()
+
+		err = app.checkBookingCapacity(booking, window)
+		if err != nil {
+			app.errorJSON(w, err, nil, availabilityErrorStatus(err))
+			return
+		}
+	}
+
+	jsonFromService, err := app.applyBookingTransition(r.Context(), action, booking, userId, requestPayload.ReasonCode, requestPayload.Reason, correlationID(r))
+	if err != nil {
+		app.errorJSON(w, err, nil, jsonFromService.StatusCode)
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = jsonFromService.Error
+	payload.StatusCode = http.StatusOK
+	payload.Message = jsonFromService.Message
+	payload.Data = jsonFromService.Data
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// applyBookingTransition updates the booking status in the inventory service and records a
+// booking.status_changed event, the transition must already be validated
+func (app *Config) applyBookingTransition(ctx context.Context, action BookingAction, booking BookingSnapshot, userId, reasonCode, reason, corrID string) (jsonResponse, error) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "update-booking-status"), UpdateBookingStatusPayload{
+		BookingID:  booking.ID,
+		UserID:     userId,
+		FromStatus: booking.Status,
+		Status:     action.To,
+		ReasonCode: reasonCode,
+		Reason:     reason,
+	})
+	if err != nil {
+		return jsonFromService, err
+	}
+
+	app.recordEventOrPublish(ctx, event.BookingStatusChangedEvent{
+		BookingID:   booking.ID,
+		InventoryID: booking.InventoryID,
+		RenterID:    booking.RenterID,
+		OwnerID:     booking.OwnerID,
+		From:        string(booking.Status),
+		To:          string(action.To),
+		ReasonCode:  reasonCode,
+		Reason:      reason,
+		ChangedBy:   userId,
+	}, corrID)
+
+	app.onBookingTransition(ctx, booking, action.To, userId, corrID)
+
+	return jsonFromService, nil
+}
+
+// getBookingSnapshot loads the current state of a booking from the inventory service
+func (app *Config) getBookingSnapshot(bookingID string) (BookingSnapshot, error) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "booking-detail"), map[string]string{
+		"booking_id": bookingID,
+	})
+	if err != nil {
+		return BookingSnapshot{}, err
+	}
+
+	var booking BookingSnapshot
+	err = decodeServiceData(jsonFromService.Data, &booking)
+	if err != nil {
+		return BookingSnapshot{}, err
+	}
+
+	if booking.ID == "" {
+		return BookingSnapshot{}, errors.New("booking not found")
+	}
+
+	return booking, nil
+}
+
+// checkBookingCapacity makes sure booking fits next to the accepted bookings of its inventory
+// for window, the booking's own current period is left out
+func (app *Config) checkBookingCapacity(booking BookingSnapshot, window BookingWindow) error {
+	item, err := app.getInventory(booking.InventoryID)
+	if err != nil {
+		return err
+	}
+
+	periods, err := app.bookedPeriods(booking.InventoryID, window.Start, window.End)
+	if err != nil {
+		return err
+	}
+
+	periods = slices.DeleteFunc(periods, func(p BookedPeriod) bool { return p.BookingID == booking.ID })
+	periods = append(periods, BookedPeriod{BookingID: booking.ID, Start: window.Start, End: window.End, Quantity: booking.Quantity})
+	if peakBooked(periods, window.Start, window.End) > max(item.GetQuantity(), 1) {
+		return ErrSlotUnavailable
+	}
+
+	return nil
+}
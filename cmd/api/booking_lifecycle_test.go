@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBookingTransition(t *testing.T) {
+	booking := BookingSnapshot{ID: "booking-1", OwnerID: "owner-1", RenterID: "renter-1", Status: BookingPending}
+
+	t.Log("Checking the owner can accept a pending booking")
+	assert.NoError(t, validateBookingTransition(AcceptBookingAction, booking, "owner-1", "", ""))
+
+	t.Log("Checking the renter can't accept their own booking")
+	assert.Error(t, validateBookingTransition(AcceptBookingAction, booking, "renter-1", "", ""))
+
+	t.Log("Checking a rejection needs a known reason code")
+	assert.Error(t, validateBookingTransition(RejectBookingAction, booking, "owner-1", "", ""))
+	assert.NoError(t, validateBookingTransition(RejectBookingAction, booking, "owner-1", "unavailable", ""))
+	assert.Error(t, validateBookingTransition(RejectBookingAction, booking, "owner-1", ReasonOther, ""))
+	assert.NoError(t, validateBookingTransition(RejectBookingAction, booking, "owner-1", ReasonOther, "item is broken"))
+
+	t.Log("Checking a pending booking can't be completed")
+	assert.Error(t, validateBookingTransition(CompleteBookingAction, booking, "owner-1", "", ""))
+
+	t.Log("Checking an active booking can be completed but not cancelled")
+	booking.Status = BookingActive
+	assert.NoError(t, validateBookingTransition(CompleteBookingAction, booking, "owner-1", "", ""))
+	assert.Error(t, validateBookingTransition(CancelBookingAction, booking, "renter-1", "change_of_plans", ""))
+}
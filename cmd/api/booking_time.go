@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // the alpine image has no zoneinfo
+)
+
+// defaultBookingTimezone is the timezone booking dates and times are read in when none is supplied
+const defaultBookingTimezone = "Africa/Lagos"
+
+// bookingStartGrace tolerates clocks that are slightly behind when a booking starts "now"
+const bookingStartGrace = 5 * time.Minute
+
+// rentalLimit is the shortest and longest rental allowed for a rental type, in its own units
+type rentalLimit struct {
+	min float64
+	max float64
+}
+
+var rentalLimits = map[RentalDuration]rentalLimit{
+	Hourly:   {min: 1, max: 72},
+	Daily:    {min: 1, max: 90},
+	Monthly:  {min: 1, max: 24},
+	Annually: {min: 1, max: 5},
+}
+
+// BookingTimes are the raw date and time fields of a booking request.
+// Dates are YYYY-MM-DD, times are HH:MM or HH:MM:SS in Timezone (an IANA name).
+type BookingTimes struct {
+	StartDate string `json:"start_date"`
+	StartTime string `json:"start_time,omitempty"`
+	EndDate   string `json:"end_date"`
+	EndTime   string `json:"end_time,omitempty"`
+	Timezone  string `json:"timezone,omitempty"`
+}
+
+// BookingWindow is the booked period as timezone-aware instants
+type BookingWindow struct {
+	Start    time.Time
+	End      time.Time
+	Location *time.Location
+}
+
+// bookingLocation loads timezone, falling back to defaultBookingTimezone when it is empty
+func bookingLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		timezone = defaultBookingTimezone
+	}
+
+	return time.LoadLocation(timezone)
+}
+
+// parseClock reads HH:MM or HH:MM:SS
+func parseClock(value string) (time.Duration, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		clock, err := time.Parse(layout, value)
+		if err == nil {
+			return time.Duration(clock.Hour())*time.Hour +
+				time.Duration(clock.Minute())*time.Minute +
+				time.Duration(clock.Second())*time.Second, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid time %q", value)
+}
+
+// Window parses the times into instants. Without an end time the booking runs to the end of
+// the end date. Errors are keyed by request field.
+func (t BookingTimes) Window() (BookingWindow, map[string]string) {
+	errors := map[string]string{}
+
+	loc, err := bookingLocation(t.Timezone)
+	if err != nil {
+		errors["timezone"] = "timezone supplied is invalid"
+		return BookingWindow{}, errors
+	}
+
+	start, err := time.ParseInLocation("2006-01-02", t.StartDate, loc)
+	if err != nil {
+		errors["start_date"] = "start_date must be in YYYY-MM-DD format"
+	}
+
+	end, err := time.ParseInLocation("2006-01-02", t.EndDate, loc)
+	if err != nil {
+		errors["end_date"] = "end_date must be in YYYY-MM-DD format"
+	}
+
+	if t.StartTime != "" {
+		offset, err := parseClock(t.StartTime)
+		if err != nil {
+			errors["start_time"] = "start_time must be in HH:MM or HH:MM:SS format"
+		}
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc).Add(offset)
+	}
+
+	if t.EndTime != "" {
+		offset, err := parseClock(t.EndTime)
+		if err != nil {
+			errors["end_time"] = "end_time must be in HH:MM or HH:MM:SS format"
+		}
+		end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, loc).Add(offset)
+	} else {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	if len(errors) > 0 {
+		return BookingWindow{}, errors
+	}
+
+	return BookingWindow{Start: start, End: end, Location: loc}, errors
+}
+
+// validateBookingWindow parses the times and checks them against the rental type:
+// the booking must end after it starts, must not start in the past and must respect
+// the minimum and maximum rental period. It returns the window and its length in units.
+func validateBookingWindow(rentalType RentalDuration, t BookingTimes, now time.Time) (BookingWindow, float64, map[string]string) {
+	window, errors := t.Window()
+	if len(errors) > 0 {
+		return window, 0, errors
+	}
+
+	if rentalType == Hourly && (t.StartTime == "" || t.EndTime == "") {
+		errors["start_time"] = "start_time and end_time are required for hourly rentals"
+		return window, 0, errors
+	}
+
+	if !window.End.After(window.Start) {
+		errors["end_date"] = "the booking must end after it starts"
+		return window, 0, errors
+	}
+
+	if t.StartTime == "" {
+		// a date-only booking may start today
+		local := now.In(window.Location)
+		today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, window.Location)
+		if window.Start.Before(today) {
+			errors["start_date"] = "start_date can not be in the past"
+		}
+	} else if window.Start.Before(now.Add(-bookingStartGrace)) {
+		errors["start_time"] = "the booking can not start in the past"
+	}
+
+	limit, ok := rentalLimits[rentalType]
+	if !ok {
+		errors["rental_type"] = "rental_type must be either hourly, daily, monthly or annually"
+		return window, 0, errors
+	}
+
+	units, err := rentalUnits(rentalType, window.Start, window.End)
+	if err != nil {
+		errors["end_date"] = err.Error()
+		return window, 0, errors
+	}
+
+	if units < limit.min || units > limit.max {
+		errors["end_date"] = fmt.Sprintf("%s rentals must last between %g and %g %s", rentalType, limit.min, limit.max, rentalUnitName(rentalType))
+	}
+
+	return window, units, errors
+}
+
+func rentalUnitName(rentalType RentalDuration) string {
+	switch rentalType {
+	case Hourly:
+		return "hours"
+	case Daily:
+		return "days"
+	case Monthly:
+		return "months"
+	default:
+		return "years"
+	}
+}
+
+// firstError returns one message of a field error map, for callers that only return an error
+func firstError(errors map[string]string) error {
+	for field, message := range errors {
+		return fmt.Errorf("%s: %s", field, message)
+	}
+
+	return nil
+}
+
+// userTimezone returns the timezone on the user's profile, empty when they have not set one
+func userTimezone(user jsonResponse) string {
+	data, ok := user.Data.(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	profile, ok := data["user"].(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	timezone, _ := profile["timezone"].(string)
+	return timezone
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookingTimesWindow(t *testing.T) {
+	t.Log("Checking times are read in the default timezone")
+	window, errs := BookingTimes{StartDate: "2030-06-15", StartTime: "09:30", EndDate: "2030-06-15", EndTime: "18:00:00"}.Window()
+	assert.Empty(t, errs)
+	assert.Equal(t, defaultBookingTimezone, window.Location.String())
+	assert.Equal(t, time.Date(2030, 6, 15, 8, 30, 0, 0, time.UTC), window.Start.UTC())
+	assert.Equal(t, 8*time.Hour+30*time.Minute, window.End.Sub(window.Start))
+
+	t.Log("Checking a booking without an end time runs to the end of the end date")
+	window, errs = BookingTimes{StartDate: "2030-06-15", EndDate: "2030-06-16", Timezone: "Europe/London"}.Window()
+	assert.Empty(t, errs)
+	assert.Equal(t, 48*time.Hour, window.End.Sub(window.Start))
+
+	t.Log("Checking each bad field is reported")
+	_, errs = BookingTimes{StartDate: "15/06/2030", StartTime: "9am", EndDate: "2030-06-16", EndTime: "25:00"}.Window()
+	assert.Contains(t, errs, "start_date")
+	assert.Contains(t, errs, "start_time")
+	assert.Contains(t, errs, "end_time")
+	assert.NotContains(t, errs, "end_date")
+
+	_, errs = BookingTimes{StartDate: "2030-06-15", EndDate: "2030-06-16", Timezone: "Mars/Base"}.Window()
+	assert.Contains(t, errs, "timezone")
+}
+
+func TestValidateBookingWindow(t *testing.T) {
+	now := time.Date(2030, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	t.Log("Checking a valid daily booking")
+	_, units, errs := validateBookingWindow(Daily, BookingTimes{StartDate: "2030-06-15", EndDate: "2030-06-17"}, now)
+	assert.Empty(t, errs)
+	assert.Equal(t, 3.0, units)
+
+	t.Log("Checking the booking must end after it starts")
+	_, _, errs = validateBookingWindow(Hourly, BookingTimes{StartDate: "2030-06-16", StartTime: "10:00", EndDate: "2030-06-16", EndTime: "09:00"}, now)
+	assert.Contains(t, errs, "end_date")
+
+	t.Log("Checking the booking can not start in the past")
+	_, _, errs = validateBookingWindow(Daily, BookingTimes{StartDate: "2030-06-14", EndDate: "2030-06-17"}, now)
+	assert.Contains(t, errs, "start_date")
+	_, _, errs = validateBookingWindow(Hourly, BookingTimes{StartDate: "2030-06-15", StartTime: "08:00", EndDate: "2030-06-15", EndTime: "18:00"}, now)
+	assert.Contains(t, errs, "start_time")
+
+	t.Log("Checking hourly rentals need times")
+	_, _, errs = validateBookingWindow(Hourly, BookingTimes{StartDate: "2030-06-16", EndDate: "2030-06-16"}, now)
+	assert.Contains(t, errs, "start_time")
+
+	t.Log("Checking the maximum rental period")
+	_, _, errs = validateBookingWindow(Hourly, BookingTimes{StartDate: "2030-06-16", StartTime: "10:00", EndDate: "2030-06-20", EndTime: "10:00"}, now)
+	assert.Contains(t, errs, "end_date")
+	_, units, errs = validateBookingWindow(Monthly, BookingTimes{StartDate: "2030-07-01", EndDate: "2030-08-31"}, now)
+	assert.Empty(t, errs)
+	assert.Equal(t, 2.0, units)
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// maxCalendarSize bounds an imported calendar, fetched or uploaded
+	maxCalendarSize = 2 << 20
+	// calendarFetchTimeout bounds fetching an external calendar
+	calendarFetchTimeout = 15 * time.Second
+	// importedBlockSource marks periods blocked by an imported calendar
+	importedBlockSource = "ical"
+	// maxCalendarRedirects bounds the redirects followed when fetching an external calendar
+	maxCalendarRedirects = 3
+)
+
+// carrierGradeNAT is the shared address space of RFC 6598, it is as internal as the private ranges
+var carrierGradeNAT = net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is routable on the internet, calendars are never fetched from
+// anything inside the cluster or on the host
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || carrierGradeNAT.Contains(ip))
+}
+
+// dialPublicOnly refuses connections to non public addresses, it runs after dns resolution
+// so a public name pointing at an internal address is refused too
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("calendar host %s is not a public address", host)
+	}
+
+	return nil
+}
+
+// checkCalendarRedirect follows a few redirects to http or https urls, every hop is dialled through dialPublicOnly
+func checkCalendarRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxCalendarRedirects {
+		return fmt.Errorf("calendar url redirected more than %d times", maxCalendarRedirects)
+	}
+
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("calendar url redirected to an unsupported %s url", req.URL.Scheme)
+	}
+
+	return nil
+}
+
+// calendarClient fetches user supplied calendar urls, it never goes through a proxy so every address is checked
+var calendarClient = &http.Client{
+	Timeout: calendarFetchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: calendarFetchTimeout,
+	},
+	CheckRedirect: checkCalendarRedirect,
+}
+
+func calendarTokenKey(token string) string {
+	return fmt.Sprintf("calendar:feed:token:%s", token)
+}
+
+func calendarOwnerKey(ownerID string) string {
+	return fmt.Sprintf("calendar:feed:owner:%s", ownerID)
+}
+
+func calendarBlocksKey(inventoryID string) string {
+	return fmt.Sprintf("availability:blocks:%s", inventoryID)
+}
+
+// CreateCalendarFeedToken issues the token of the logged in owner's booking feed,
+// issuing a new one revokes the old feed url
+func (app *Config) CreateCalendarFeedToken(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+	token := hex.EncodeToString(secret)
+
+	ctx := r.Context()
+	oldToken, err := app.cache.Get(ctx, calendarOwnerKey(userId)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	pipe := app.cache.TxPipeline()
+	if oldToken != "" {
+		pipe.Del(ctx, calendarTokenKey(oldToken))
+	}
+	pipe.Set(ctx, calendarTokenKey(token), userId, 0)
+	pipe.Set(ctx, calendarOwnerKey(userId), token, 0)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "calendar feed token issued",
+		Data: map[string]interface{}{
+			"token":    token,
+			"feed_url": fmt.Sprintf("/api/v1/calendar/feed/%s.ics", token),
+		},
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetCalendarFeed serves an owner's accepted and active bookings as an iCalendar feed.
+// Calendar apps can't send a bearer token, the token in the url is the credential.
+func (app *Config) GetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		app.errorJSON(w, errors.New("calendar feed not found"), nil, http.StatusNotFound)
+		return
+	}
+
+	ownerID, err := app.cache.Get(r.Context(), calendarTokenKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		app.errorJSON(w, errors.New("calendar feed not found"), nil, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	bookings, err := app.ownerBookings(ownerID)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="bookings.ics"`)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, renderICalendar("Bookings", bookingFeedEvents(bookings), time.Now()))
+}
+
+// bookingFeedEvents turns bookings into feed events, bookings with unreadable dates are left out
+func bookingFeedEvents(bookings []BookingSnapshot) []ICalFeedEvent {
+	events := make([]ICalFeedEvent, 0, len(bookings))
+	for _, booking := range bookings {
+		window, errs := booking.Times().Window()
+		if len(errs) > 0 {
+			log.Printf("[CALENDAR] skipping booking %s: %v", booking.ID, firstError(errs))
+			continue
+		}
+
+		events = append(events, ICalFeedEvent{
+			UID:         fmt.Sprintf("booking-%s@broker-service", booking.ID),
+			Summary:     fmt.Sprintf("Booking %s (%s)", booking.ID, booking.Status),
+			Description: fmt.Sprintf("Inventory: %s\nRenter: %s\nQuantity: %g", booking.InventoryID, booking.RenterID, booking.Quantity),
+			Start:       window.Start,
+			End:         window.End,
+		})
+	}
+
+	return events
+}
+
+// ownerBookings loads the bookings of an owner that take stock
+func (app *Config) ownerBookings(ownerID string) ([]BookingSnapshot, error) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "owner-bookings"), map[string]interface{}{
+		"owner_id": ownerID,
+		"statuses": blockingBookingStatuses,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var bookings []BookingSnapshot
+	err = decodeServiceData(jsonFromService.Data, &bookings)
+
+	return bookings, err
+}
+
+type CalendarImportPayload struct {
+	InventoryID string `json:"inventory_id"`
+	URL         string `json:"url"`
+	Timezone    string `json:"timezone"` // for times without one, defaults to Africa/Lagos
+}
+
+// ImportCalendar blocks out an inventory for the events of an external calendar, given as a url
+// in a json body or uploaded as the "calendar" file of a multipart form.
+// Every import replaces the blocks of the previous one.
+func (app *Config) ImportCalendar(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	var requestPayload CalendarImportPayload
+	var upload []byte
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxCalendarSize+1024)
+		err = r.ParseMultipartForm(maxCalendarSize)
+		if err != nil {
+			app.errorJSON(w, err, nil)
+			return
+		}
+
+		requestPayload.InventoryID = r.FormValue("inventory_id")
+		requestPayload.Timezone = r.FormValue("timezone")
+
+		file, _, err := r.FormFile("calendar")
+		if err != nil {
+			app.errorJSON(w, errors.New("calendar file not supplied"), nil)
+			return
+		}
+		defer file.Close()
+
+		upload, err = io.ReadAll(io.LimitReader(file, maxCalendarSize))
+		if err != nil {
+			app.errorJSON(w, err, nil)
+			return
+		}
+	} else {
+		//extract the request body
+		err = app.readJSON(w, r, &requestPayload)
+		if err != nil {
+			app.errorJSON(w, err, nil)
+			return
+		}
+	}
+
+	// Validate the request payload
+	if err := app.ValidateCalendarImportInput(requestPayload, len(upload) > 0); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to import calendar"), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	item, err := app.getInventory(requestPayload.InventoryID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if item.GetUserId() != userId {
+		app.errorJSON(w, errors.New("you can only import a calendar into your own inventory"), nil, http.StatusForbidden)
+		return
+	}
+
+	if len(upload) == 0 {
+		upload, err = fetchCalendar(r.Context(), requestPayload.URL)
+		if err != nil {
+			app.errorJSON(w, err, nil, http.StatusBadGateway)
+			return
+		}
+	}
+
+	loc, err := bookingLocation(requestPayload.Timezone)
+	if err != nil {
+		app.errorJSON(w, errors.New("timezone supplied is invalid"), nil)
+		return
+	}
+
+	now := time.Now()
+	events, err := parseICalendar(bytes.NewReader(upload), loc, now)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	blocks := calendarBlocks(events, max(item.GetQuantity(), 1), now)
+
+	err = app.saveCalendarBlocks(r.Context(), requestPayload.InventoryID, blocks)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "calendar imported",
+		Data: map[string]interface{}{
+			"inventory_id": requestPayload.InventoryID,
+			"events":       len(events),
+			"blocked":      blocks,
+		},
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// fetchCalendar downloads an external calendar, webcal urls are fetched over https
+func fetchCalendar(ctx context.Context, rawURL string) ([]byte, error) {
+	if strings.HasPrefix(rawURL, "webcal://") {
+		rawURL = "https://" + strings.TrimPrefix(rawURL, "webcal://")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, calendarFetchTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "text/calendar")
+
+	response, err := calendarClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching the calendar failed with status %d", response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxCalendarSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > maxCalendarSize {
+		return nil, fmt.Errorf("calendar is larger than %d bytes", maxCalendarSize)
+	}
+
+	return body, nil
+}
+
+// calendarBlocks turns events that have not ended into periods taking the whole stock
+func calendarBlocks(events []ICalEvent, stock float64, now time.Time) []BookedPeriod {
+	blocks := []BookedPeriod{}
+	for _, e := range events {
+		if !e.End.After(now) {
+			continue
+		}
+
+		blocks = append(blocks, BookedPeriod{
+			Source:   importedBlockSource,
+			Start:    e.Start,
+			End:      e.End,
+			Quantity: stock,
+		})
+	}
+
+	return blocks
+}
+
+func (app *Config) saveCalendarBlocks(ctx context.Context, inventoryID string, blocks []BookedPeriod) error {
+	if len(blocks) == 0 {
+		return app.cache.Del(ctx, calendarBlocksKey(inventoryID)).Err()
+	}
+
+	rawBlocks, err := json.Marshal(blocks)
+	if err != nil {
+		return err
+	}
+
+	return app.cache.Set(ctx, calendarBlocksKey(inventoryID), rawBlocks, 0).Err()
+}
+
+// calendarBlockedPeriods returns the imported blocks of an inventory that overlap from-to
+func (app *Config) calendarBlockedPeriods(ctx context.Context, inventoryID string, from, to time.Time) ([]BookedPeriod, error) {
+	rawBlocks, err := app.cache.Get(ctx, calendarBlocksKey(inventoryID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []BookedPeriod
+	err = json.Unmarshal(rawBlocks, &blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	overlapping := []BookedPeriod{}
+	for _, block := range blocks {
+		if block.overlaps(from, to) {
+			overlapping = append(overlapping, block)
+		}
+	}
+
+	return overlapping, nil
+}
+
+// isCalendarURL accepts http, https and webcal urls
+func isCalendarURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "http", "https", "webcal":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/obynonwane/broker-service/event"
+	"github.com/obynonwane/rental-service-proto/inventory"
+)
+
+const (
+	// maxCartItems bounds the lines of a cart
+	maxCartItems = 50
+	// cartLockTTL bounds how long a checkout can block other changes to the cart
+	cartLockTTL = time.Minute
+)
+
+// CartItem is a sale listing in a cart, priced at the listed price when it was last checked
+type CartItem struct {
+	InventoryID string  `json:"inventory_id"`
+	SellerID    string  `json:"seller_id"`
+	Name        string  `json:"name"`
+	Image       string  `json:"image,omitempty"`
+	UnitPrice   float64 `json:"unit_price"`
+	Quantity    float64 `json:"quantity"`
+	Subtotal    float64 `json:"subtotal"`
+	AddedAt     int64   `json:"added_at"`
+	UpdatedAt   int64   `json:"updated_at"`
+}
+
+// Cart is what the buyer sees, the items grouped the way checkout will split them
+type Cart struct {
+	Items       []CartItem      `json:"items"`
+	Orders      []CheckoutOrder `json:"orders"`
+	TotalAmount float64         `json:"total_amount"`
+}
+
+// CheckoutOrder is the part of a checkout sold by one seller, it becomes one purchase order
+type CheckoutOrder struct {
+	OrderID     string     `json:"order_id,omitempty"`
+	SellerID    string     `json:"seller_id"`
+	Items       []CartItem `json:"items"`
+	TotalAmount float64    `json:"total_amount"`
+}
+
+// Checkout is a cart turned into orders, paid for with a single payment
+type Checkout struct {
+	Reference        string          `json:"reference"`
+	BuyerID          string          `json:"buyer_id"`
+	Orders           []CheckoutOrder `json:"orders"`
+	TotalAmount      float64         `json:"total_amount"`
+	AuthorizationURL string          `json:"authorization_url,omitempty"`
+	CreatedAt        int64           `json:"created_at"`
+}
+
+// priceCartItem checks buyerID can buy quantity of item and prices it at the listed price
+func priceCartItem(item *inventory.Inventory, buyerID string, quantity float64) (CartItem, error) {
+	quote, err := quoteSale(item, buyerID, quantity, 0, nil, time.Now())
+	if err != nil {
+		return CartItem{}, err
+	}
+
+	return CartItem{
+		InventoryID: quote.InventoryID,
+		SellerID:    quote.SellerID,
+		Name:        item.Name,
+		Image:       item.PrimaryImage,
+		UnitPrice:   quote.UnitPrice,
+		Quantity:    quote.Quantity,
+		Subtotal:    quote.TotalAmount,
+	}, nil
+}
+
+// splitCartBySeller groups items into one order per seller, sorted by seller
+func splitCartBySeller(items []CartItem) ([]CheckoutOrder, float64) {
+	bySeller := map[string]*CheckoutOrder{}
+	for _, item := range items {
+		order, ok := bySeller[item.SellerID]
+		if !ok {
+			order = &CheckoutOrder{SellerID: item.SellerID, Items: []CartItem{}}
+			bySeller[item.SellerID] = order
+		}
+
+		order.Items = append(order.Items, item)
+		order.TotalAmount = roundMoney(order.TotalAmount + item.Subtotal)
+	}
+
+	orders := make([]CheckoutOrder, 0, len(bySeller))
+	var total float64
+	for _, order := range bySeller {
+		orders = append(orders, *order)
+		total = roundMoney(total + order.TotalAmount)
+	}
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].SellerID < orders[j].SellerID })
+	return orders, total
+}
+
+func newCart(items []CartItem) Cart {
+	orders, total := splitCartBySeller(items)
+	return Cart{Items: items, Orders: orders, TotalAmount: total}
+}
+
+// repriceCart checks every item against its listing again, the errors are keyed by inventory id
+func (app *Config) repriceCart(items []CartItem, buyerID string) ([]CartItem, map[string]string) {
+	errs := map[string]string{}
+	repriced := make([]CartItem, 0, len(items))
+
+	for _, cartItem := range items {
+		listing, err := app.getInventory(cartItem.InventoryID)
+		if err != nil {
+			errs[cartItem.InventoryID] = err.Error()
+			continue
+		}
+
+		item, err := priceCartItem(listing, buyerID, cartItem.Quantity)
+		if err != nil {
+			errs[cartItem.InventoryID] = err.Error()
+			continue
+		}
+
+		item.AddedAt = cartItem.AddedAt
+		item.UpdatedAt = cartItem.UpdatedAt
+		repriced = append(repriced, item)
+	}
+
+	return repriced, errs
+}
+
+// lockCart keeps a checkout from racing another checkout or cart change, call the returned func to unlock
+func (app *Config) lockCart(ctx context.Context, userID string) (func(), error) {
+	unlock, err := app.acquireLock(ctx, fmt.Sprintf("cart:lock:%s", userID), cartLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return nil, errors.New("your cart is being checked out, please try again")
+	}
+
+	return unlock, err
+}
+
+// initializeCheckoutPayment asks the payment service for one payment link covering every order of checkout
+func (app *Config) initializeCheckoutPayment(checkout Checkout, email string) (string, error) {
+	orders := make([]map[string]interface{}, 0, len(checkout.Orders))
+	for _, order := range checkout.Orders {
+		orders = append(orders, map[string]interface{}{
+			"order_id":  order.OrderID,
+			"seller_id": order.SellerID,
+			"amount":    order.TotalAmount,
+		})
+	}
+
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("PAYMENT_SERVICE_URL"), "initialize-checkout"), map[string]interface{}{
+		"reference": checkout.Reference,
+		"buyer_id":  checkout.BuyerID,
+		"email":     email,
+		"amount":    checkout.TotalAmount,
+		"orders":    orders,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var payment struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	err = decodeServiceData(jsonFromService.Data, &payment)
+
+	return payment.AuthorizationURL, err
+}
+
+type CartItemPayload struct {
+	InventoryID string  `json:"inventory_id"`
+	Quantity    float64 `json:"quantity"`
+}
+
+type RemoveCartItemPayload struct {
+	InventoryID string `json:"inventory_id"`
+}
+
+type PayCheckoutPayload struct {
+	Reference string `json:"reference"`
+}
+
+// GetCart returns the cart of the user split into the orders checkout would create
+func (app *Config) GetCart(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	items, err := app.carts.Items(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "cart retrieved",
+		Data:       newCart(items),
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// AddCartItem adds a sale listing to the cart, adding to the quantity of a listing already in it
+func (app *Config) AddCartItem(w http.ResponseWriter, r *http.Request) {
+	app.setCartItem(w, r, true)
+}
+
+// UpdateCartItem sets the quantity of a listing in the cart
+func (app *Config) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	app.setCartItem(w, r, false)
+}
+
+func (app *Config) setCartItem(w http.ResponseWriter, r *http.Request, add bool) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload CartItemPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateCartItemInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to update cart"), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	ctx := r.Context()
+	unlock, err := app.lockCart(ctx, userId)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	items, err := app.carts.Items(ctx, userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	quantity := requestPayload.Quantity
+	addedAt := now
+	inCart := false
+	for _, item := range items {
+		if item.InventoryID != requestPayload.InventoryID {
+			continue
+		}
+
+		inCart = true
+		addedAt = item.AddedAt
+		if add {
+			quantity += item.Quantity
+		}
+	}
+
+	if !inCart && !add {
+		app.errorJSON(w, errors.New("this inventory is not in your cart"), nil, http.StatusNotFound)
+		return
+	}
+
+	if !inCart && len(items) >= maxCartItems {
+		app.errorJSON(w, fmt.Errorf("a cart can hold at most %d items", maxCartItems), nil, http.StatusConflict)
+		return
+	}
+
+	listing, err := app.getInventory(requestPayload.InventoryID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	item, err := priceCartItem(listing, userId, quantity)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	item.AddedAt = addedAt
+	item.UpdatedAt = now
+
+	err = app.carts.SetItem(ctx, userId, item)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	items, err = app.carts.Items(ctx, userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "cart updated",
+		Data:       newCart(items),
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RemoveCartItem takes a listing out of the cart
+func (app *Config) RemoveCartItem(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload RemoveCartItemPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if requestPayload.InventoryID == "" {
+		app.errorJSON(w, errors.New("error trying to update cart"), map[string]string{"inventory_id": "inventory_id is required"}, http.StatusBadRequest)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	ctx := r.Context()
+	unlock, err := app.lockCart(ctx, userId)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	err = app.carts.RemoveItem(ctx, userId, requestPayload.InventoryID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	items, err := app.carts.Items(ctx, userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "cart updated",
+		Data:       newCart(items),
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CheckoutCart checks the cart against the listings again, places one order per seller
+// and initializes a single payment covering all of them
+func (app *Config) CheckoutCart(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	ctx := r.Context()
+	unlock, err := app.lockCart(ctx, userId)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	items, err := app.carts.Items(ctx, userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if len(items) == 0 {
+		app.errorJSON(w, errors.New("your cart is empty"), nil)
+		return
+	}
+
+	// prices and stock may have changed since the items were added
+	items, errs := app.repriceCart(items, userId)
+	if len(errs) > 0 {
+		app.errorJSON(w, errors.New("some items in your cart can not be bought"), errs, http.StatusConflict)
+		return
+	}
+
+	orders, total := splitCartBySeller(items)
+	checkout := Checkout{
+		Reference:   fmt.Sprintf("chk_%s", uuid.NewString()),
+		BuyerID:     userId,
+		Orders:      orders,
+		TotalAmount: total,
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "create-cart-orders"), map[string]interface{}{
+		"checkout_reference": checkout.Reference,
+		"buyer_id":           userId,
+		"orders":             orders,
+	})
+	if err != nil {
+		app.errorJSON(w, err, nil, jsonFromService.StatusCode)
+		return
+	}
+
+	var created []struct {
+		ID       string `json:"id"`
+		SellerID string `json:"seller_id"`
+	}
+	err = decodeServiceData(jsonFromService.Data, &created)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	for _, order := range created {
+		for i := range checkout.Orders {
+			if checkout.Orders[i].SellerID == order.SellerID {
+				checkout.Orders[i].OrderID = order.ID
+			}
+		}
+	}
+
+	// the orders exist now, the cart is done with whatever happens to the payment
+	err = app.carts.Clear(ctx, userId)
+	if err != nil {
+		log.Printf("[CART] clearing the cart of %s failed: %v", userId, err)
+	}
+
+	for _, order := range checkout.Orders {
+		e := event.OrderCreatedEvent{
+			OrderID:     order.OrderID,
+			BuyerID:     userId,
+			SellerID:    order.SellerID,
+			TotalAmount: order.TotalAmount,
+		}
+		for _, item := range order.Items {
+			e.Quantity += item.Quantity
+		}
+		if len(order.Items) == 1 {
+			e.InventoryID = order.Items[0].InventoryID
+		}
+
+		app.recordEventOrPublish(ctx, e, correlationID(r))
+	}
+
+	email := userEmail(user)
+
+	message := "checkout completed"
+	checkout.AuthorizationURL, err = app.initializeCheckoutPayment(checkout, email)
+	if err != nil {
+		// keep the checkout so the buyer can ask for a payment link again
+		log.Printf("[CART] initializing payment for %s failed: %v", checkout.Reference, err)
+		message = "orders placed, the payment could not be initialized, please try paying again"
+	}
+
+	err = app.carts.SaveCheckout(ctx, checkout)
+	if err != nil {
+		log.Printf("[CART] saving checkout %s failed: %v", checkout.Reference, err)
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    message,
+		Data:       checkout,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// PayCheckout gives the buyer a payment link for a checkout again
+func (app *Config) PayCheckout(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload PayCheckoutPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if requestPayload.Reference == "" {
+		app.errorJSON(w, errors.New("error trying to pay checkout"), map[string]string{"reference": "reference is required"}, http.StatusBadRequest)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	ctx := r.Context()
+	checkout, err := app.carts.GetCheckout(ctx, requestPayload.Reference)
+	if errors.Is(err, ErrCheckoutNotFound) {
+		app.errorJSON(w, err, nil, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if checkout.BuyerID != userId {
+		app.errorJSON(w, errors.New("this checkout belongs to another user"), nil, http.StatusForbidden)
+		return
+	}
+
+	email := userEmail(user)
+
+	checkout.AuthorizationURL, err = app.initializeCheckoutPayment(checkout, email)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	err = app.carts.SaveCheckout(ctx, checkout)
+	if err != nil {
+		log.Printf("[CART] saving checkout %s failed: %v", checkout.Reference, err)
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "checkout payment initialized",
+		Data:       checkout,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrCheckoutNotFound is returned for an unknown or expired checkout reference
+var ErrCheckoutNotFound = errors.New("checkout not found")
+
+const (
+	// cartTTL drops a cart nobody touched for a while, every change pushes it back
+	cartTTL = 30 * 24 * time.Hour
+	// checkoutTTL is how long a buyer can come back to pay a checkout
+	checkoutTTL = 7 * 24 * time.Hour
+)
+
+// CartStore keeps the cart of each user and the checkouts made from it
+type CartStore interface {
+	// Items returns the items in the cart of userID, oldest first
+	Items(ctx context.Context, userID string) ([]CartItem, error)
+	// SetItem adds item to the cart or replaces the line of the same inventory
+	SetItem(ctx context.Context, userID string, item CartItem) error
+	RemoveItem(ctx context.Context, userID, inventoryID string) error
+	Clear(ctx context.Context, userID string) error
+	SaveCheckout(ctx context.Context, c Checkout) error
+	GetCheckout(ctx context.Context, reference string) (Checkout, error)
+}
+
+// redisCartStore keeps a cart in a hash of inventory id to item
+type redisCartStore struct {
+	cache *redis.Client
+}
+
+func cartKey(userID string) string {
+	return fmt.Sprintf("cart:%s", userID)
+}
+
+func checkoutKey(reference string) string {
+	return fmt.Sprintf("checkout:%s", reference)
+}
+
+func (s redisCartStore) Items(ctx context.Context, userID string) ([]CartItem, error) {
+	raw, err := s.cache.HGetAll(ctx, cartKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]CartItem, 0, len(raw))
+	for _, rawItem := range raw {
+		var item CartItem
+		if err := json.Unmarshal([]byte(rawItem), &item); err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	sortCartItems(items)
+	return items, nil
+}
+
+func (s redisCartStore) SetItem(ctx context.Context, userID string, item CartItem) error {
+	rawItem, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.cache.TxPipeline()
+	pipe.HSet(ctx, cartKey(userID), item.InventoryID, rawItem)
+	pipe.Expire(ctx, cartKey(userID), cartTTL)
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+func (s redisCartStore) RemoveItem(ctx context.Context, userID, inventoryID string) error {
+	return s.cache.HDel(ctx, cartKey(userID), inventoryID).Err()
+}
+
+func (s redisCartStore) Clear(ctx context.Context, userID string) error {
+	return s.cache.Del(ctx, cartKey(userID)).Err()
+}
+
+func (s redisCartStore) SaveCheckout(ctx context.Context, c Checkout) error {
+	rawCheckout, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return s.cache.Set(ctx, checkoutKey(c.Reference), rawCheckout, checkoutTTL).Err()
+}
+
+func (s redisCartStore) GetCheckout(ctx context.Context, reference string) (Checkout, error) {
+	rawCheckout, err := s.cache.Get(ctx, checkoutKey(reference)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Checkout{}, ErrCheckoutNotFound
+	}
+	if err != nil {
+		return Checkout{}, err
+	}
+
+	var c Checkout
+	err = json.Unmarshal(rawCheckout, &c)
+
+	return c, err
+}
+
+func sortCartItems(items []CartItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].AddedAt == items[j].AddedAt {
+			return items[i].InventoryID < items[j].InventoryID
+		}
+		return items[i].AddedAt < items[j].AddedAt
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryCartStore is a stand-in store used in tests
+type memoryCartStore struct {
+	mu        sync.Mutex
+	carts     map[string]map[string]CartItem
+	checkouts map[string]Checkout
+}
+
+func newMemoryCartStore() *memoryCartStore {
+	return &memoryCartStore{carts: make(map[string]map[string]CartItem), checkouts: make(map[string]Checkout)}
+}
+
+func (s *memoryCartStore) Items(ctx context.Context, userID string) ([]CartItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := []CartItem{}
+	for _, item := range s.carts[userID] {
+		items = append(items, item)
+	}
+
+	sortCartItems(items)
+	return items, nil
+}
+
+func (s *memoryCartStore) SetItem(ctx context.Context, userID string, item CartItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.carts[userID] == nil {
+		s.carts[userID] = make(map[string]CartItem)
+	}
+	s.carts[userID][item.InventoryID] = item
+
+	return nil
+}
+
+func (s *memoryCartStore) RemoveItem(ctx context.Context, userID, inventoryID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.carts[userID], inventoryID)
+	return nil
+}
+
+func (s *memoryCartStore) Clear(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.carts, userID)
+	return nil
+}
+
+func (s *memoryCartStore) SaveCheckout(ctx context.Context, c Checkout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkouts[c.Reference] = c
+	return nil
+}
+
+func (s *memoryCartStore) GetCheckout(ctx context.Context, reference string) (Checkout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.checkouts[reference]
+	if !ok {
+		return Checkout{}, ErrCheckoutNotFound
+	}
+
+	return c, nil
+}
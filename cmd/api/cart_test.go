@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/obynonwane/rental-service-proto/inventory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceCartItem(t *testing.T) {
+	listing := &inventory.Inventory{
+		Id:             "inventory-1",
+		UserId:         "seller-1",
+		Name:           "Office chair",
+		ProductPurpose: string(ProductPurposeSale),
+		Quantity:       4,
+		OfferPrice:     12500.5,
+	}
+
+	t.Log("Checking an item is priced at the listed price")
+	item, err := priceCartItem(listing, "buyer-1", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "seller-1", item.SellerID)
+	assert.Equal(t, 25001.0, item.Subtotal)
+
+	t.Log("Checking the quantity is bounded by the stock")
+	_, err = priceCartItem(listing, "buyer-1", 5)
+	assert.Error(t, err)
+
+	t.Log("Checking a seller can not buy their own listing")
+	_, err = priceCartItem(listing, "seller-1", 1)
+	assert.Error(t, err)
+
+	t.Log("Checking rental listings can not be bought")
+	listing.ProductPurpose = string(ProductPurposeRental)
+	_, err = priceCartItem(listing, "buyer-1", 1)
+	assert.Error(t, err)
+}
+
+func TestSplitCartBySeller(t *testing.T) {
+	items := []CartItem{
+		{InventoryID: "a", SellerID: "seller-2", Subtotal: 1000},
+		{InventoryID: "b", SellerID: "seller-1", Subtotal: 250.25},
+		{InventoryID: "c", SellerID: "seller-2", Subtotal: 500},
+	}
+
+	t.Log("Checking the cart becomes one order per seller")
+	orders, total := splitCartBySeller(items)
+	assert.Len(t, orders, 2)
+	assert.Equal(t, "seller-1", orders[0].SellerID)
+	assert.Equal(t, 250.25, orders[0].TotalAmount)
+	assert.Equal(t, "seller-2", orders[1].SellerID)
+	assert.Len(t, orders[1].Items, 2)
+	assert.Equal(t, 1500.0, orders[1].TotalAmount)
+	assert.Equal(t, 1750.25, total)
+}
+
+func TestCartStore(t *testing.T) {
+	for name, store := range map[string]CartStore{
+		"memory": newMemoryCartStore(),
+		"redis":  redisCartStore{cache: newTestRedis(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_ = store.SetItem(ctx, "buyer-1", CartItem{InventoryID: "b", Quantity: 1, AddedAt: 2})
+			_ = store.SetItem(ctx, "buyer-1", CartItem{InventoryID: "a", Quantity: 1, AddedAt: 1})
+			_ = store.SetItem(ctx, "buyer-1", CartItem{InventoryID: "a", Quantity: 3, AddedAt: 1})
+
+			t.Log("Checking a listing takes one line, oldest first")
+			items, err := store.Items(ctx, "buyer-1")
+			assert.NoError(t, err)
+			assert.Len(t, items, 2)
+			assert.Equal(t, "a", items[0].InventoryID)
+			assert.Equal(t, 3.0, items[0].Quantity)
+
+			t.Log("Checking items can be removed and the cart cleared")
+			_ = store.RemoveItem(ctx, "buyer-1", "b")
+			items, _ = store.Items(ctx, "buyer-1")
+			assert.Len(t, items, 1)
+			_ = store.Clear(ctx, "buyer-1")
+			items, _ = store.Items(ctx, "buyer-1")
+			assert.Empty(t, items)
+		})
+	}
+}
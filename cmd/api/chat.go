@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,13 +17,6 @@ import (
 	"github.com/obynonwane/broker-service/event"
 )
 
-type RabbitMQPayload struct {
-	Name string `json:"name"`
-	// Data map[string]interface{} `json:"data"`
-	// Data Message `json:"data"`
-	Data json.RawMessage `json:"data"` // Raw JSON until decoded
-}
-
 // Message struct defines the message payload
 type Message struct {
 	Content      string `json:"content"`
@@ -38,6 +32,9 @@ type Message struct {
 var clients = make(map[string]*websocket.Conn)
 var clientsMu sync.Mutex // for safe concurrent access
 
+// a websocket connection supports one concurrent writer, keep a write lock per connection
+var connWriteLocks sync.Map
+
 var broadcast = make(chan Message, 128)
 
 var upgrader = websocket.Upgrader{
@@ -93,8 +90,11 @@ func (app *Config) ChatHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Cleanup
 	clientsMu.Lock()
-	delete(clients, userID)
+	if clients[userID] == conn {
+		delete(clients, userID)
+	}
 	clientsMu.Unlock()
+	connWriteLocks.Delete(conn)
 	conn.Close()
 	log.Printf("[CLEANUP] %s disconnected", userID)
 }
@@ -104,7 +104,7 @@ func keepAlive(conn *websocket.Conn, userID string) {
 	ticker := time.NewTicker(15 * time.Second)
 	defer ticker.Stop()
 	for range ticker.C {
-		if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
 			log.Printf("[PING FAILED] %s: %v", userID, err)
 			break
 		}
@@ -127,6 +127,9 @@ func (app *Config) HandleMessages() {
 
 		if ok {
 			go safeSend(receiverConn, msg)
+		} else if app.notifier != nil {
+			// receiver is offline, notify them if they don't read it in time
+			app.notifier.Queue(msg)
 		}
 
 		// Optional: echo back to sender
@@ -137,7 +140,13 @@ func (app *Config) HandleMessages() {
 }
 
 // safeSend handles errors while writing to connections
-func safeSend(conn *websocket.Conn, msg Message) {
+func safeSend(conn *websocket.Conn, msg any) {
+	lock, _ := connWriteLocks.LoadOrStore(conn, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+
+	mu.Lock()
+	defer mu.Unlock()
+
 	if err := conn.WriteJSON(msg); err != nil {
 		log.Printf("[SEND ERROR] %v", err)
 		conn.Close()
@@ -149,18 +158,8 @@ func (app *Config) saveToDatabase(msg Message) {
 	// Example: log to console
 	log.Printf("[DB SAVE] From %s to %s at %d: %s %s", msg.Sender, msg.Receiver, msg.SentAt, msg.Content, msg.MessageID)
 
-	rawData, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
-		return
-	}
-
-	data := RabbitMQPayload{
-		Name: "persist_chat",
-		Data: json.RawMessage(rawData),
-	}
-
-	go app.pushEventViaRabbit(data)
+	// publish with confirms, retries and spooling, the sender is told the outcome
+	go app.persistChat(msg)
 }
 
 func (app *Config) GetChatHistory(w http.ResponseWriter, r *http.Request) {
@@ -180,6 +179,31 @@ func (app *Config) GetChatHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	callerID, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// the caller is one side of the conversation unless told otherwise
+	if userA == "" {
+		userA = callerID
+	}
+
+	if userB == "" {
+		app.errorJSON(w, errors.New("userB not supplied"), nil)
+		return
+	}
+
+	// only participants (or an admin, which is audited) can read a conversation
+	if callerID != userA && callerID != userB {
+		if !app.isAdminUser(user) {
+			app.errorJSON(w, errors.New("you are not a participant in this conversation"), nil, http.StatusForbidden)
+			return
+		}
+		app.auditChatAccess(callerID, "chat-history", userA, userB)
+	}
+
 	// Define the payload structure
 	type ChatHistoryRequest struct {
 		UserA string `json:"userA"`
@@ -261,6 +285,12 @@ func (app *Config) GetChatList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID, err = app.resolveChatSubject(user, userID, "chat-list")
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusForbidden)
+		return
+	}
+
 	// Define the payload structure
 
 	type ChatListRequest struct {
@@ -341,6 +371,12 @@ func (app *Config) GetUnreadChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID, err = app.resolveChatSubject(user, userID, "unread-chat")
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusForbidden)
+		return
+	}
+
 	// Define the payload structure
 
 	type UnreadChatRequest struct {
@@ -483,6 +519,15 @@ func (app *Config) MarkChatAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the messages are read, no need to notify about them anymore
+	if app.notifier != nil {
+		app.notifier.Clear(replierID, userID)
+	}
+
+	if app.realtime != nil && app.realtime.Online(replierID) {
+		go app.pushUnreadChatCount(replierID)
+	}
+
 	// Relay the response
 	payload := jsonResponse{
 		Error:      jsonFromService.Error,
@@ -575,33 +620,45 @@ func (app *Config) DeleteChat(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-//==============================================================================================================================================//
-
-// pushEventViaRabbit logs an event using the logger-service. It makes the call by pushing the data to RabbitMQ.
-func (app *Config) pushEventViaRabbit(l RabbitMQPayload) {
-	err := app.pushToQueue(l.Name, l.Data)
+// resolveChatSubject returns the user whose chats should be read.
+// Non-admins can only read their own chats, admins reading someone else's are audited.
+func (app *Config) resolveChatSubject(user jsonResponse, requestedID, action string) (string, error) {
+	callerID, err := app.returnLoggedInUserID(user)
 	if err != nil {
-		// app.errorJSON(w, err, nil)
-		return
+		return "", err
 	}
-}
 
-// pushToQueue pushes a message into RabbitMQ
-func (app *Config) pushToQueue(name string, msg json.RawMessage) error {
-	emitter, err := event.NewEventEmitter(app.Rabbit)
-	if err != nil {
-		return err
+	if requestedID == "" || requestedID == callerID {
+		// unread digests go to this address when the user has not saved a preference
+		if app.notifier != nil {
+			app.notifier.RememberEmail(context.Background(), callerID, userEmail(user))
+		}
+		return callerID, nil
 	}
 
-	payload := RabbitMQPayload{
-		Name: name,
-		Data: msg,
+	if !app.isAdminUser(user) {
+		return "", errors.New("you can only read your own chats")
 	}
 
-	j, _ := json.MarshalIndent(&payload, "", "\t")
-	err = emitter.Push(string(j), "log.INFO")
-	if err != nil {
-		return err
+	app.auditChatAccess(callerID, action, requestedID)
+	return requestedID, nil
+}
+
+// auditChatAccess logs an admin read of other users' chats and ships it to the logger-service
+func (app *Config) auditChatAccess(adminID, action string, subjects ...string) {
+	entry := event.ChatAccessAuditedEvent{
+		AdminID:    adminID,
+		Action:     action,
+		Subjects:   subjects,
+		AccessedAt: time.Now().UnixMilli(),
 	}
-	return nil
+
+	log.Printf("[AUDIT] admin %s read %s of %v", adminID, action, subjects)
+
+	go func() {
+		err := app.publishEvent(context.Background(), entry, "")
+		if err != nil {
+			log.Printf("Failed to publish audit entry: %v", err)
+		}
+	}()
 }
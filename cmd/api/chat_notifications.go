@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultChatNotifyDelay is how long an unread message waits before the receiver is notified
+const defaultChatNotifyDelay = 5 * time.Minute
+
+// defaultNotificationTimezone is used for quiet hours when the user has not picked one
+const defaultNotificationTimezone = "Africa/Lagos"
+
+// PushProvider delivers push notifications to a user's devices.
+// Implementations can wrap FCM, APNs, OneSignal etc.
+type PushProvider interface {
+	Send(ctx context.Context, userID, title, body string) error
+}
+
+// logPushProvider only logs the notification, it is used until a real provider is configured
+type logPushProvider struct{}
+
+func (logPushProvider) Send(ctx context.Context, userID, title, body string) error {
+	log.Printf("[PUSH] to %s: %s - %s", userID, title, body)
+	return nil
+}
+
+// ChatNotificationPreference holds how and when a user wants to hear about unread chats
+type ChatNotificationPreference struct {
+	UserID          string `json:"user_id"`
+	Email           string `json:"email"`
+	EmailEnabled    bool   `json:"email_enabled"`
+	PushEnabled     bool   `json:"push_enabled"`
+	QuietHoursStart string `json:"quiet_hours_start"` // e.g., "22:00"
+	QuietHoursEnd   string `json:"quiet_hours_end"`   // e.g., "07:00"
+	Timezone        string `json:"timezone"`          // e.g., "Africa/Lagos"
+}
+
+// ChatNotifier notifies offline receivers about messages they have not read. Pending messages and when
+// each receiver is due live in redis, so a digest survives a restart and any replica can send it.
+type ChatNotifier struct {
+	cache *redis.Client
+	push  PushProvider
+	delay time.Duration
+	mail  func(MailPayload) error
+}
+
+// chatNotifyDueKey orders receivers with pending messages by when their digest is due
+const chatNotifyDueKey = "chat:notify:due"
+
+// clearChatNotifyScript drops a receiver from the due set once nothing is pending for them,
+// in one step so a message queued meanwhile keeps its digest
+var clearChatNotifyScript = redis.NewScript(`
+if redis.call("LLEN", KEYS[1]) == 0 then
+	redis.call("ZREM", KEYS[2], ARGV[1])
+end
+return 1
+`)
+
+// NewChatNotifier creates a notifier, CHAT_NOTIFY_DELAY (e.g. "10m") overrides the default delay
+func NewChatNotifier(cache *redis.Client, push PushProvider, mail func(MailPayload) error) *ChatNotifier {
+	delay := defaultChatNotifyDelay
+	if v := os.Getenv("CHAT_NOTIFY_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("invalid CHAT_NOTIFY_DELAY '%s': %v, defaulting to %s", v, err, defaultChatNotifyDelay)
+		} else {
+			delay = d
+		}
+	}
+
+	return &ChatNotifier{
+		cache: cache,
+		push:  push,
+		delay: delay,
+		mail:  mail,
+	}
+}
+
+func chatNotifyPendingKey(receiverID string) string {
+	return fmt.Sprintf("chat:notify:pending:%s", receiverID)
+}
+
+func chatNotificationEmailKey(userID string) string {
+	return fmt.Sprintf("chat:notification-email:%s", userID)
+}
+
+// Queue records an undelivered message and schedules a notification for its receiver,
+// a receiver already waiting for a digest keeps its time
+func (n *ChatNotifier) Queue(msg Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rawMessage, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[NOTIFY] queueing message %s failed: %v", msg.MessageID, err)
+		return
+	}
+
+	pipe := n.cache.TxPipeline()
+	pipe.RPush(ctx, chatNotifyPendingKey(msg.Receiver), rawMessage)
+	pipe.ZAddNX(ctx, chatNotifyDueKey, redis.Z{Score: float64(time.Now().Add(n.delay).UnixMilli()), Member: msg.Receiver})
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("[NOTIFY] queueing message %s failed: %v", msg.MessageID, err)
+	}
+}
+
+// Clear drops pending messages from senderID once the receiver has read them.
+// An empty senderID clears everything pending for the receiver.
+func (n *ChatNotifier) Clear(receiverID, senderID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pendingKey := chatNotifyPendingKey(receiverID)
+	if senderID == "" {
+		pipe := n.cache.TxPipeline()
+		pipe.Del(ctx, pendingKey)
+		pipe.ZRem(ctx, chatNotifyDueKey, receiverID)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("[NOTIFY] clearing messages for %s failed: %v", receiverID, err)
+		}
+		return
+	}
+
+	rawMessages, err := n.cache.LRange(ctx, pendingKey, 0, -1).Result()
+	if err != nil {
+		log.Printf("[NOTIFY] clearing messages for %s failed: %v", receiverID, err)
+		return
+	}
+
+	for _, rawMessage := range rawMessages {
+		var msg Message
+		if err := json.Unmarshal([]byte(rawMessage), &msg); err != nil || msg.Sender == senderID {
+			n.cache.LRem(ctx, pendingKey, 1, rawMessage)
+		}
+	}
+
+	err = clearChatNotifyScript.Run(ctx, n.cache, []string{pendingKey, chatNotifyDueKey}, receiverID).Err()
+	if err != nil {
+		log.Printf("[NOTIFY] clearing messages for %s failed: %v", receiverID, err)
+	}
+}
+
+// RememberEmail keeps the address on userID's token, digests go there when the preference has none
+func (n *ChatNotifier) RememberEmail(ctx context.Context, userID, email string) {
+	if email == "" {
+		return
+	}
+
+	if err := n.cache.Set(ctx, chatNotificationEmailKey(userID), email, 0).Err(); err != nil {
+		log.Printf("[NOTIFY] remembering the email of %s failed: %v", userID, err)
+	}
+}
+
+// DispatchDue sends the digests that are due, it runs as a scheduled job
+func (n *ChatNotifier) DispatchDue(ctx context.Context) error {
+	due, err := n.cache.ZRangeByScore(ctx, chatNotifyDueKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, receiverID := range due {
+		if err := n.dispatch(ctx, receiverID); err != nil {
+			log.Printf("[NOTIFY] sending the digest for %s failed: %v", receiverID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d chat digests could not be sent", failed, len(due))
+	}
+
+	return nil
+}
+
+// dispatch sends the digest for receiverID, or postpones it until quiet hours are over
+func (n *ChatNotifier) dispatch(ctx context.Context, receiverID string) error {
+	pref := n.Preference(ctx, receiverID)
+
+	if quiet, resumeAt := inQuietHours(time.Now(), pref); quiet {
+		return n.cache.ZAddXX(ctx, chatNotifyDueKey, redis.Z{Score: float64(resumeAt.UnixMilli()), Member: receiverID}).Err()
+	}
+
+	// take the pending messages in one step so a digest is only sent once
+	pendingKey := chatNotifyPendingKey(receiverID)
+	pipe := n.cache.TxPipeline()
+	pending := pipe.LRange(ctx, pendingKey, 0, -1)
+	pipe.Del(ctx, pendingKey)
+	pipe.ZRem(ctx, chatNotifyDueKey, receiverID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	var messages []Message
+	for _, rawMessage := range pending.Val() {
+		var msg Message
+		if err := json.Unmarshal([]byte(rawMessage), &msg); err == nil {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	subject, body := chatDigest(messages)
+
+	email := pref.Email
+	if email == "" {
+		email, _ = n.cache.Get(ctx, chatNotificationEmailKey(receiverID)).Result()
+	}
+
+	if pref.EmailEnabled && email != "" && n.mail != nil {
+		err := n.mail(MailPayload{
+			To:      email,
+			Subject: subject,
+			Message: body,
+			Data: map[string]interface{}{
+				"unread_count": len(messages),
+			},
+		})
+		if err != nil {
+			log.Printf("[NOTIFY] email to %s failed: %v", receiverID, err)
+		}
+	}
+
+	if pref.PushEnabled && n.push != nil {
+		if err := n.push.Send(ctx, receiverID, subject, body); err != nil {
+			log.Printf("[NOTIFY] push to %s failed: %v", receiverID, err)
+		}
+	}
+
+	return nil
+}
+
+// Preference returns the stored preference for userID or the defaults
+func (n *ChatNotifier) Preference(ctx context.Context, userID string) ChatNotificationPreference {
+	pref := ChatNotificationPreference{
+		UserID:       userID,
+		EmailEnabled: true,
+		PushEnabled:  true,
+		Timezone:     defaultNotificationTimezone,
+	}
+
+	raw, err := n.cache.Get(ctx, chatNotificationPrefKey(userID)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("[NOTIFY] failed to load preference for %s: %v", userID, err)
+		}
+		return pref
+	}
+
+	if err := json.Unmarshal(raw, &pref); err != nil {
+		log.Printf("[NOTIFY] invalid preference for %s: %v", userID, err)
+	}
+
+	return pref
+}
+
+// SavePreference stores the preference for pref.UserID
+func (n *ChatNotifier) SavePreference(ctx context.Context, pref ChatNotificationPreference) error {
+	raw, err := json.Marshal(pref)
+	if err != nil {
+		return err
+	}
+
+	return n.cache.Set(ctx, chatNotificationPrefKey(pref.UserID), raw, 0).Err()
+}
+
+func chatNotificationPrefKey(userID string) string {
+	return fmt.Sprintf("chat:notification-preference:%s", userID)
+}
+
+// chatDigest summarises unread messages per sender
+func chatDigest(messages []Message) (string, string) {
+	counts := map[string]int{}
+	var senders []string
+	for _, msg := range messages {
+		if counts[msg.Sender] == 0 {
+			senders = append(senders, msg.Sender)
+		}
+		counts[msg.Sender]++
+	}
+
+	subject := fmt.Sprintf("You have %d unread message(s)", len(messages))
+
+	var lines []string
+	for _, sender := range senders {
+		lines = append(lines, fmt.Sprintf("%d new message(s) from %s", counts[sender], sender))
+	}
+
+	return subject, strings.Join(lines, "\n")
+}
+
+// inQuietHours reports whether now falls within the user's quiet hours and when they end.
+// Quiet hours can wrap past midnight (e.g. 22:00 - 07:00).
+func inQuietHours(now time.Time, pref ChatNotificationPreference) (bool, time.Time) {
+	if pref.QuietHoursStart == "" || pref.QuietHoursEnd == "" {
+		return false, now
+	}
+
+	start, err := time.Parse("15:04", pref.QuietHoursStart)
+	if err != nil {
+		return false, now
+	}
+
+	end, err := time.Parse("15:04", pref.QuietHoursEnd)
+	if err != nil {
+		return false, now
+	}
+
+	loc, err := time.LoadLocation(pref.Timezone)
+	if err != nil {
+		loc, _ = time.LoadLocation(defaultNotificationTimezone)
+	}
+
+	local := now.In(loc)
+	startAt := time.Date(local.Year(), local.Month(), local.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+	endAt := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+
+	if !startAt.Before(endAt) {
+		// window wraps past midnight
+		if local.Before(endAt) {
+			return true, endAt
+		}
+		if !local.Before(startAt) {
+			return true, endAt.AddDate(0, 0, 1)
+		}
+		return false, now
+	}
+
+	if !local.Before(startAt) && local.Before(endAt) {
+		return true, endAt
+	}
+
+	return false, now
+}
+
+// UpdateChatNotificationPreferencePayload is what the user can change
+type UpdateChatNotificationPreferencePayload struct {
+	EmailEnabled    bool   `json:"email_enabled"`
+	PushEnabled     bool   `json:"push_enabled"`
+	QuietHoursStart string `json:"quiet_hours_start"`
+	QuietHoursEnd   string `json:"quiet_hours_end"`
+	Timezone        string `json:"timezone"`
+}
+
+func (app *Config) GetChatNotificationPreference(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	if app.notifier == nil {
+		app.errorJSON(w, errors.New("chat notifications are unavailable"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "notification preference retrieved",
+		Data:       app.notifier.Preference(r.Context(), userId),
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) UpdateChatNotificationPreference(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	if app.notifier == nil {
+		app.errorJSON(w, errors.New("chat notifications are unavailable"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	//extract the request body
+	var requestPayload UpdateChatNotificationPreferencePayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateChatNotificationPreferenceInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to update notification preference"), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// the email is taken from the token so digests always go to the account owner
+	pref := ChatNotificationPreference{
+		UserID:          userId,
+		Email:           userEmail(user),
+		EmailEnabled:    requestPayload.EmailEnabled,
+		PushEnabled:     requestPayload.PushEnabled,
+		QuietHoursStart: requestPayload.QuietHoursStart,
+		QuietHoursEnd:   requestPayload.QuietHoursEnd,
+		Timezone:        requestPayload.Timezone,
+	}
+	if pref.Timezone == "" {
+		pref.Timezone = defaultNotificationTimezone
+	}
+
+	err = app.notifier.SavePreference(r.Context(), pref)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "notification preference updated",
+		Data:       pref,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
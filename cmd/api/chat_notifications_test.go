@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInQuietHours(t *testing.T) {
+	lagos, err := time.LoadLocation("Africa/Lagos")
+	if err != nil {
+		t.Skip("timezone database not available")
+	}
+
+	pref := ChatNotificationPreference{
+		QuietHoursStart: "22:00",
+		QuietHoursEnd:   "07:00",
+		Timezone:        "Africa/Lagos",
+	}
+
+	t.Log("Checking that late night falls within quiet hours that wrap past midnight")
+	quiet, resumeAt := inQuietHours(time.Date(2025, 6, 15, 23, 30, 0, 0, lagos), pref)
+	assert.True(t, quiet)
+	assert.Equal(t, time.Date(2025, 6, 16, 7, 0, 0, 0, lagos), resumeAt)
+
+	t.Log("Checking that early morning falls within the same quiet hours")
+	quiet, resumeAt = inQuietHours(time.Date(2025, 6, 16, 6, 0, 0, 0, lagos), pref)
+	assert.True(t, quiet)
+	assert.Equal(t, time.Date(2025, 6, 16, 7, 0, 0, 0, lagos), resumeAt)
+
+	t.Log("Checking that midday is outside quiet hours")
+	quiet, _ = inQuietHours(time.Date(2025, 6, 16, 12, 0, 0, 0, lagos), pref)
+	assert.False(t, quiet)
+
+	t.Log("Checking that no quiet hours never blocks notifications")
+	quiet, _ = inQuietHours(time.Date(2025, 6, 16, 23, 0, 0, 0, lagos), ChatNotificationPreference{})
+	assert.False(t, quiet)
+}
+
+func TestChatDigest(t *testing.T) {
+	messages := []Message{
+		{Sender: "user-a", Receiver: "user-c"},
+		{Sender: "user-b", Receiver: "user-c"},
+		{Sender: "user-a", Receiver: "user-c"},
+	}
+
+	subject, body := chatDigest(messages)
+
+	assert.Equal(t, "You have 3 unread message(s)", subject)
+	assert.Equal(t, "2 new message(s) from user-a\n1 new message(s) from user-b", body)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/obynonwane/broker-service/event"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// chatSpoolKey is the redis list holding chats that could not be published
+	chatSpoolKey = "chat:spool"
+	// maxChatSpool bounds the spool so an unreachable broker can't exhaust redis
+	maxChatSpool = 10000
+	// chatPublishAttempts is how many times a chat is published before it is spooled
+	chatPublishAttempts = 4
+	// chatSpoolDrainInterval is how often spooled chats are retried
+	chatSpoolDrainInterval = 5 * time.Second
+	// chatSpoolDrainTimeout bounds one drain, it is also how long a replica holds the drain lock
+	chatSpoolDrainTimeout = 30 * time.Second
+	chatSpoolLockKey      = "chat:spool:lock"
+	// legacyChatRoutingKey is where the chat store consumes chats today, in the {name, data} shape
+	legacyChatRoutingKey = "log.INFO"
+)
+
+// spoolChatScript pushes a chat unless the spool is full, in one step so concurrent senders can't overfill it
+var spoolChatScript = redis.NewScript(`
+if redis.call("LLEN", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("LPUSH", KEYS[1], ARGV[1])
+return 1
+`)
+
+// legacyChatPayload is the body the chat store reads, chats are published in it
+// next to chat.persisted until the store consumes the envelope
+type legacyChatPayload struct {
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data"`
+}
+
+// chat persistence statuses reported to the sender
+const (
+	ChatPersisted = "persisted"
+	ChatSpooled   = "spooled"
+	ChatFailed    = "failed"
+)
+
+// ChatPersistStatus tells the sender what happened to a message they sent
+type ChatPersistStatus struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// persistChat publishes msg for storage, retrying with backoff and spooling to redis when rabbitmq is unreachable
+func (app *Config) persistChat(msg Message) {
+	var err error
+	backOff := 200 * time.Millisecond
+	for attempt := 1; attempt <= chatPublishAttempts; attempt++ {
+		err = app.publishChat(msg)
+		if err == nil {
+			notifyPersistStatus(msg, ChatPersisted, nil)
+			return
+		}
+
+		log.Printf("[DB SAVE] attempt %d for %s failed: %v", attempt, msg.MessageID, err)
+		if attempt < chatPublishAttempts {
+			time.Sleep(backOff)
+			backOff *= 2
+		}
+	}
+
+	err = app.spoolChat(msg)
+	if err != nil {
+		log.Printf("[DB SAVE] could not spool %s: %v", msg.MessageID, err)
+		notifyPersistStatus(msg, ChatFailed, err)
+		return
+	}
+
+	notifyPersistStatus(msg, ChatSpooled, nil)
+}
+
+// publishChat publishes a chat for the chat store and waits for rabbitmq to confirm it reached a queue,
+// the chat.persisted event goes out alongside for consumers of the envelope
+func (app *Config) publishChat(msg Message) error {
+	if app.Publisher == nil {
+		return errors.New("rabbitmq publisher is not available")
+	}
+
+	rawData, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.MarshalIndent(legacyChatPayload{Name: "persist_chat", Data: rawData}, "", "\t")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// mandatory, a chat nobody is bound to receive must not be reported as persisted
+	err = app.Publisher.PublishMandatory(ctx, legacyChatRoutingKey, amqp.Publishing{
+		ContentType:  "text/plain",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+	if err != nil {
+		return err
+	}
+
+	chat := event.ChatPersistedEvent{
+		MessageID:   msg.MessageID,
+		Sender:      msg.Sender,
+		Receiver:    msg.Receiver,
+		ReplyTo:     msg.ReplyTo,
+		Content:     msg.Content,
+		ContentType: msg.Content_Type,
+		SentAt:      msg.SentAt,
+	}
+
+	// nothing may be bound for chat.persisted yet, so it does not decide whether the chat was stored
+	if err := app.publishEvent(context.Background(), chat, msg.MessageID); err != nil {
+		log.Printf("[DB SAVE] publishing chat.persisted for %s failed: %v", msg.MessageID, err)
+	}
+
+	return nil
+}
+
+// spoolChat keeps a chat in redis until rabbitmq is reachable again
+func (app *Config) spoolChat(msg Message) error {
+	rawData, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pushed, err := spoolChatScript.Run(ctx, app.cache, []string{chatSpoolKey}, rawData, maxChatSpool).Int()
+	if err != nil {
+		return err
+	}
+
+	if pushed == 0 {
+		return errors.New("chat spool is full")
+	}
+
+	return nil
+}
+
+// DrainChatSpool periodically republishes spooled chats, oldest first
+func (app *Config) DrainChatSpool() {
+	ticker := time.NewTicker(chatSpoolDrainInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.drainChatSpool()
+	}
+}
+
+func (app *Config) drainChatSpool() {
+	ctx, cancel := context.WithTimeout(context.Background(), chatSpoolDrainTimeout)
+	defer cancel()
+
+	// one replica drains at a time, the peek, publish and pop below are not atomic
+	unlock, err := app.acquireLock(ctx, chatSpoolLockKey, chatSpoolDrainTimeout)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	for {
+		// peek at the oldest entry, only remove it once rabbitmq confirmed it
+		raw, err := app.cache.LIndex(ctx, chatSpoolKey, -1).Bytes()
+		if err != nil {
+			return
+		}
+
+		var msg Message
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			log.Printf("[DB SAVE] dropping unreadable spooled chat: %v", err)
+			app.cache.RPop(ctx, chatSpoolKey)
+			continue
+		}
+
+		err = app.publishChat(msg)
+		if err != nil {
+			log.Printf("[DB SAVE] spool drain paused: %v", err)
+			return
+		}
+
+		err = app.cache.RPop(ctx, chatSpoolKey).Err()
+		if err != nil {
+			log.Printf("[DB SAVE] failed to pop spooled chat: %v", err)
+			return
+		}
+
+		notifyPersistStatus(msg, ChatPersisted, nil)
+	}
+}
+
+// notifyPersistStatus tells the sender, if connected, what happened to their message
+func notifyPersistStatus(msg Message, status string, err error) {
+	clientsMu.Lock()
+	senderConn, online := clients[msg.Sender]
+	clientsMu.Unlock()
+
+	if !online {
+		return
+	}
+
+	update := ChatPersistStatus{
+		Type:      "persist_status",
+		MessageID: msg.MessageID,
+		Status:    status,
+	}
+	if err != nil {
+		update.Error = err.Error()
+	}
+
+	safeSend(senderConn, update)
+}
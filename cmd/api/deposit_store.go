@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrDepositNotFound is returned for a booking without a deposit
+var ErrDepositNotFound = errors.New("this booking has no deposit")
+
+// depositDeadlinesKey orders held deposits by the end of their claim window
+const depositDeadlinesKey = "deposits:claim-deadlines"
+
+// DepositStore keeps the deposit of each booking
+type DepositStore interface {
+	Get(ctx context.Context, bookingID string) (Deposit, error)
+	// Save stores d, replacing the deposit of its booking
+	Save(ctx context.Context, d Deposit) error
+	// DueForRelease returns the bookings whose held deposit's claim window closed before before
+	DueForRelease(ctx context.Context, before time.Time) ([]string, error)
+}
+
+type redisDepositStore struct {
+	cache *redis.Client
+}
+
+func depositKey(bookingID string) string {
+	return fmt.Sprintf("deposits:%s", bookingID)
+}
+
+func (s redisDepositStore) Get(ctx context.Context, bookingID string) (Deposit, error) {
+	rawDeposit, err := s.cache.Get(ctx, depositKey(bookingID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Deposit{}, ErrDepositNotFound
+	}
+	if err != nil {
+		return Deposit{}, err
+	}
+
+	var d Deposit
+	err = json.Unmarshal(rawDeposit, &d)
+
+	return d, err
+}
+
+func (s redisDepositStore) Save(ctx context.Context, d Deposit) error {
+	rawDeposit, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.cache.TxPipeline()
+	pipe.Set(ctx, depositKey(d.BookingID), rawDeposit, 0)
+	if d.awaitingRelease() {
+		pipe.ZAdd(ctx, depositDeadlinesKey, redis.Z{Score: float64(d.ClaimDeadline), Member: d.BookingID})
+	} else {
+		pipe.ZRem(ctx, depositDeadlinesKey, d.BookingID)
+	}
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+func (s redisDepositStore) DueForRelease(ctx context.Context, before time.Time) ([]string, error) {
+	return s.cache.ZRangeByScore(ctx, depositDeadlinesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(before.UnixMilli(), 10),
+	}).Result()
+}
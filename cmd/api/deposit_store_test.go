@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryDepositStore is a stand-in store used in tests
+type memoryDepositStore struct {
+	mu       sync.Mutex
+	deposits map[string]Deposit
+}
+
+func newMemoryDepositStore() *memoryDepositStore {
+	return &memoryDepositStore{deposits: make(map[string]Deposit)}
+}
+
+func (s *memoryDepositStore) Get(ctx context.Context, bookingID string) (Deposit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deposits[bookingID]
+	if !ok {
+		return Deposit{}, ErrDepositNotFound
+	}
+
+	return d, nil
+}
+
+func (s *memoryDepositStore) Save(ctx context.Context, d Deposit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deposits[d.BookingID] = d
+	return nil
+}
+
+func (s *memoryDepositStore) DueForRelease(ctx context.Context, before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []string{}
+	for bookingID, d := range s.deposits {
+		if d.awaitingRelease() && d.ClaimDeadline <= before.UnixMilli() {
+			due = append(due, bookingID)
+		}
+	}
+
+	return due, nil
+}
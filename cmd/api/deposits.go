@@ -0,0 +1,778 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/obynonwane/broker-service/event"
+)
+
+type DepositStatus string
+
+const (
+	DepositAwaitingPayment DepositStatus = "awaiting_payment"
+	DepositHeld            DepositStatus = "held"
+	DepositSettling        DepositStatus = "settling" // split decided, waiting on the payment service to move the money
+	DepositReleased        DepositStatus = "released" // refunded in full
+	DepositDeducted        DepositStatus = "deducted" // part or all kept by the owner, the rest refunded
+	DepositCancelled       DepositStatus = "cancelled"
+)
+
+const (
+	// defaultDepositClaimWindow is how long the owner has after completion to claim damages, see DEPOSIT_CLAIM_HOURS
+	defaultDepositClaimWindow = 72 * time.Hour
+	// maxDepositEvidence bounds the evidence urls of a deduction
+	maxDepositEvidence = 10
+	// depositLockTTL bounds how long one deposit change can block others on the same booking
+	depositLockTTL = 30 * time.Second
+)
+
+// Deposit is the security deposit of a booking
+type Deposit struct {
+	BookingID        string                `json:"booking_id"`
+	RenterID         string                `json:"renter_id"`
+	OwnerID          string                `json:"owner_id"`
+	Amount           float64               `json:"amount"`
+	Status           DepositStatus         `json:"status"`
+	Reference        string                `json:"reference"`
+	AuthorizationURL string                `json:"authorization_url,omitempty"`
+	DeductedAmount   float64               `json:"deducted_amount"`
+	RefundedAmount   float64               `json:"refunded_amount"`
+	Deduction        *DepositDeduction     `json:"deduction,omitempty"`
+	ClaimDeadline    int64                 `json:"claim_deadline,omitempty"` // unix ms, set once the booking completes
+	History          []DepositStatusChange `json:"history"`
+	CreatedAt        int64                 `json:"created_at"`
+	UpdatedAt        int64                 `json:"updated_at"`
+}
+
+// DepositDeduction is what the owner kept and why
+type DepositDeduction struct {
+	Amount     float64  `json:"amount"`
+	Reason     string   `json:"reason"`
+	Evidence   []string `json:"evidence"`
+	DeductedBy string   `json:"deducted_by"`
+	DeductedAt int64    `json:"deducted_at"`
+}
+
+type DepositStatusChange struct {
+	Status    DepositStatus `json:"status"`
+	ChangedBy string        `json:"changed_by"`
+	Reason    string        `json:"reason,omitempty"`
+	At        int64         `json:"at"`
+}
+
+// awaitingRelease is true while the release job should look at the deposit, a settling one stays so a
+// failed payment call is retried with the same amounts
+func (d Deposit) awaitingRelease() bool {
+	return (d.Status == DepositHeld || d.Status == DepositSettling) && d.ClaimDeadline > 0
+}
+
+// setStatus moves the deposit to status and records it in its history
+func (d *Deposit) setStatus(status DepositStatus, changedBy, reason string, now time.Time) {
+	d.Status = status
+	d.UpdatedAt = now.UnixMilli()
+	d.History = append(d.History, DepositStatusChange{Status: status, ChangedBy: changedBy, Reason: reason, At: now.UnixMilli()})
+}
+
+// settle splits a held deposit into what the owner keeps and what is refunded, the deposit is settling
+// until the payment service has moved the money
+func (d *Deposit) settle(deduction *DepositDeduction, changedBy string, now time.Time) error {
+	if d.Status != DepositHeld {
+		return fmt.Errorf("a %s deposit can not be settled", d.Status)
+	}
+
+	if deduction == nil {
+		d.RefundedAmount = d.Amount
+		d.setStatus(DepositSettling, changedBy, "", now)
+		return nil
+	}
+
+	if deduction.Amount <= 0 || deduction.Amount > d.Amount+priceTolerance {
+		return fmt.Errorf("the deduction must be between 0 and %.2f", d.Amount)
+	}
+
+	d.Deduction = deduction
+	d.DeductedAmount = roundMoney(min(deduction.Amount, d.Amount))
+	d.RefundedAmount = roundMoney(d.Amount - d.DeductedAmount)
+	d.setStatus(DepositSettling, changedBy, deduction.Reason, now)
+
+	return nil
+}
+
+// settled records that the payment service moved the money of a settling deposit
+func (d *Deposit) settled(changedBy string, now time.Time) {
+	if d.Deduction == nil {
+		d.setStatus(DepositReleased, changedBy, "", now)
+		return
+	}
+
+	d.setStatus(DepositDeducted, changedBy, d.Deduction.Reason, now)
+}
+
+// settlementKey lets the payment service move the money of a deposit once however often it is asked
+func (d Deposit) settlementKey() string {
+	return fmt.Sprintf("%s:settle", d.Reference)
+}
+
+// depositClaimWindow reads DEPOSIT_CLAIM_HOURS
+func depositClaimWindow() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("DEPOSIT_CLAIM_HOURS"))
+	if err != nil || hours <= 0 {
+		return defaultDepositClaimWindow
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+// lockDeposit keeps two changes to the deposit of a booking from racing, call the returned func to unlock
+func (app *Config) lockDeposit(ctx context.Context, bookingID string) (func(), error) {
+	unlock, err := app.acquireLock(ctx, fmt.Sprintf("deposits:lock:%s", bookingID), depositLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return nil, errors.New("this deposit is being updated, please try again")
+	}
+
+	return unlock, err
+}
+
+// onBookingTransition keeps the deposit in step with its booking
+func (app *Config) onBookingTransition(ctx context.Context, booking BookingSnapshot, to BookingStatus, changedBy, corrID string) {
+	if app.deposits == nil {
+		return
+	}
+
+	var err error
+	switch to {
+	case BookingAccepted:
+		_, err = app.startDepositCollection(ctx, booking, "", changedBy, corrID)
+	case BookingCompleted:
+		err = app.openDepositClaimWindow(ctx, booking.ID)
+	case BookingCancelled:
+		err = app.closeDepositOnCancel(ctx, booking.ID, changedBy, corrID)
+	}
+
+	if err != nil && !errors.Is(err, ErrDepositNotFound) {
+		log.Printf("[DEPOSITS] updating the deposit of booking %s after it was %s failed: %v", booking.ID, to, err)
+	}
+}
+
+// startDepositCollection asks the payment service for a payment link for the deposit of booking,
+// a booking without a deposit gets none
+func (app *Config) startDepositCollection(ctx context.Context, booking BookingSnapshot, email, changedBy, corrID string) (Deposit, error) {
+	if booking.SecurityDeposit <= 0 {
+		return Deposit{}, ErrDepositNotFound
+	}
+
+	unlock, err := app.lockDeposit(ctx, booking.ID)
+	if err != nil {
+		return Deposit{}, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	deposit, err := app.deposits.Get(ctx, booking.ID)
+	switch {
+	case errors.Is(err, ErrDepositNotFound):
+		deposit = Deposit{
+			BookingID: booking.ID,
+			RenterID:  booking.RenterID,
+			OwnerID:   booking.OwnerID,
+			Amount:    roundMoney(booking.SecurityDeposit),
+			Reference: fmt.Sprintf("dep_%s", uuid.NewString()),
+			CreatedAt: now.UnixMilli(),
+		}
+		deposit.setStatus(DepositAwaitingPayment, changedBy, "", now)
+	case err != nil:
+		return Deposit{}, err
+	case deposit.Status != DepositAwaitingPayment:
+		return deposit, fmt.Errorf("the deposit is already %s", deposit.Status)
+	}
+
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("PAYMENT_SERVICE_URL"), "initialize-deposit"), map[string]interface{}{
+		"booking_id": deposit.BookingID,
+		"reference":  deposit.Reference,
+		"renter_id":  deposit.RenterID,
+		"owner_id":   deposit.OwnerID,
+		"amount":     deposit.Amount,
+		"email":      email,
+	})
+	if err != nil {
+		// keep the record so the renter can ask for a payment link again
+		if saveErr := app.deposits.Save(ctx, deposit); saveErr != nil {
+			log.Printf("[DEPOSITS] saving the deposit of booking %s failed: %v", deposit.BookingID, saveErr)
+		}
+		return deposit, err
+	}
+
+	var payment struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	if err := decodeServiceData(jsonFromService.Data, &payment); err == nil && payment.AuthorizationURL != "" {
+		deposit.AuthorizationURL = payment.AuthorizationURL
+	}
+
+	err = app.deposits.Save(ctx, deposit)
+	if err != nil {
+		return deposit, err
+	}
+
+	app.recordDeposit(ctx, deposit, changedBy, "", corrID)
+	return deposit, nil
+}
+
+// openDepositClaimWindow gives the owner a while after completion to claim damages before the deposit is released
+func (app *Config) openDepositClaimWindow(ctx context.Context, bookingID string) error {
+	unlock, err := app.lockDeposit(ctx, bookingID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	deposit, err := app.deposits.Get(ctx, bookingID)
+	if err != nil {
+		return err
+	}
+
+	if deposit.Status != DepositHeld {
+		return nil
+	}
+
+	deposit.ClaimDeadline = time.Now().Add(depositClaimWindow()).UnixMilli()
+	deposit.UpdatedAt = time.Now().UnixMilli()
+
+	return app.deposits.Save(ctx, deposit)
+}
+
+// closeDepositOnCancel refunds a held deposit and drops an unpaid one
+func (app *Config) closeDepositOnCancel(ctx context.Context, bookingID, changedBy, corrID string) error {
+	unlock, err := app.lockDeposit(ctx, bookingID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	deposit, err := app.deposits.Get(ctx, bookingID)
+	if err != nil {
+		return err
+	}
+
+	switch deposit.Status {
+	case DepositHeld, DepositSettling:
+		_, err = app.settleLockedDeposit(ctx, deposit, nil, changedBy, corrID)
+		return err
+	case DepositAwaitingPayment:
+		deposit.setStatus(DepositCancelled, changedBy, "booking cancelled", time.Now())
+		err = app.deposits.Save(ctx, deposit)
+		if err != nil {
+			return err
+		}
+
+		app.recordDeposit(ctx, deposit, changedBy, "booking cancelled", corrID)
+	}
+
+	return nil
+}
+
+// settleDeposit releases a held deposit, or keeps deduction for the owner and refunds the rest
+func (app *Config) settleDeposit(ctx context.Context, bookingID string, deduction *DepositDeduction, changedBy, corrID string) (Deposit, error) {
+	unlock, err := app.lockDeposit(ctx, bookingID)
+	if err != nil {
+		return Deposit{}, err
+	}
+	defer unlock()
+
+	deposit, err := app.deposits.Get(ctx, bookingID)
+	if err != nil {
+		return Deposit{}, err
+	}
+
+	return app.settleLockedDeposit(ctx, deposit, deduction, changedBy, corrID)
+}
+
+// settleLockedDeposit settles deposit for a caller holding its lock. The split is saved before the payment
+// service is called, so a deposit left settling by a failed call is finished with the amounts first
+// decided, and deduction is ignored
+func (app *Config) settleLockedDeposit(ctx context.Context, deposit Deposit, deduction *DepositDeduction, changedBy, corrID string) (Deposit, error) {
+	if deposit.Status != DepositSettling {
+		err := deposit.settle(deduction, changedBy, time.Now())
+		if err != nil {
+			return deposit, err
+		}
+
+		err = app.deposits.Save(ctx, deposit)
+		if err != nil {
+			return deposit, err
+		}
+	}
+
+	reason := ""
+	if deposit.Deduction != nil {
+		reason = deposit.Deduction.Reason
+	}
+
+	_, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("PAYMENT_SERVICE_URL"), "settle-deposit"), map[string]interface{}{
+		"booking_id":      deposit.BookingID,
+		"reference":       deposit.Reference,
+		"idempotency_key": deposit.settlementKey(),
+		"renter_id":       deposit.RenterID,
+		"owner_id":        deposit.OwnerID,
+		"amount":          deposit.Amount,
+		"deducted_amount": deposit.DeductedAmount,
+		"refund_amount":   deposit.RefundedAmount,
+		"reason":          reason,
+	})
+	if err != nil {
+		return Deposit{}, err
+	}
+
+	deposit.settled(changedBy, time.Now())
+	err = app.deposits.Save(ctx, deposit)
+	if err != nil {
+		return deposit, err
+	}
+
+	app.recordDeposit(ctx, deposit, changedBy, reason, corrID)
+	return deposit, nil
+}
+
+// releaseDueDeposits releases the deposits whose claim window closed without a deduction
+func (app *Config) releaseDueDeposits(ctx context.Context) error {
+	if app.deposits == nil {
+		return nil
+	}
+
+	due, err := app.deposits.DueForRelease(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, bookingID := range due {
+		// an admin settles a disputed deposit when resolving the dispute
+		if app.underDispute(ctx, DisputeBooking, bookingID) {
+			continue
+		}
+
+		_, err := app.settleDeposit(ctx, bookingID, nil, bookingSystem, "")
+		if err != nil {
+			log.Printf("[JOBS] releasing the deposit of booking %s failed: %v", bookingID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d deposits could not be released", failed, len(due))
+	}
+
+	return nil
+}
+
+func (app *Config) recordDeposit(ctx context.Context, deposit Deposit, changedBy, reason, corrID string) {
+	app.recordEventOrPublish(ctx, event.DepositUpdatedEvent{
+		BookingID:      deposit.BookingID,
+		RenterID:       deposit.RenterID,
+		OwnerID:        deposit.OwnerID,
+		Status:         string(deposit.Status),
+		Amount:         deposit.Amount,
+		DeductedAmount: deposit.DeductedAmount,
+		RefundedAmount: deposit.RefundedAmount,
+		Reason:         reason,
+		ChangedBy:      changedBy,
+	}, corrID)
+}
+
+type DepositPayload struct {
+	BookingID string `json:"booking_id"`
+}
+
+type DepositDeductionPayload struct {
+	BookingID string   `json:"booking_id"`
+	Amount    float64  `json:"amount"`
+	Reason    string   `json:"reason"`
+	Evidence  []string `json:"evidence"` // urls of uploaded photos or documents
+}
+
+// loadDepositBooking loads the booking a deposit request is about
+func (app *Config) loadDepositBooking(w http.ResponseWriter, user jsonResponse, bookingID string) (string, BookingSnapshot, bool) {
+	if app.deposits == nil {
+		app.errorJSON(w, errors.New("deposits are not available"), nil, http.StatusServiceUnavailable)
+		return "", BookingSnapshot{}, false
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return "", BookingSnapshot{}, false
+	}
+
+	booking, err := app.getBookingSnapshot(bookingID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return "", BookingSnapshot{}, false
+	}
+
+	return userId, booking, true
+}
+
+// CollectDeposit gives the renter a payment link for the deposit of an accepted booking
+func (app *Config) CollectDeposit(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload DepositPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateDepositInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to collect deposit"), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, booking, ok := app.loadDepositBooking(w, user, requestPayload.BookingID)
+	if !ok {
+		return
+	}
+
+	if booking.RenterID != userId {
+		app.errorJSON(w, errors.New("only the renter can pay a deposit"), nil, http.StatusForbidden)
+		return
+	}
+
+	if !slices.Contains([]BookingStatus{BookingAccepted, BookingActive}, booking.Status) {
+		app.errorJSON(w, fmt.Errorf("a deposit can not be paid on a %s booking", booking.Status), nil, http.StatusConflict)
+		return
+	}
+
+	email := userEmail(user)
+
+	deposit, err := app.startDepositCollection(r.Context(), booking, email, userId, correlationID(r))
+	if errors.Is(err, ErrDepositNotFound) {
+		app.errorJSON(w, err, nil, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "deposit payment initialized",
+		Data:       deposit,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// VerifyDeposit confirms the deposit payment with the payment service and marks the deposit held
+func (app *Config) VerifyDeposit(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload DepositPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateDepositInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to verify deposit"), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, booking, ok := app.loadDepositBooking(w, user, requestPayload.BookingID)
+	if !ok {
+		return
+	}
+
+	if booking.RenterID != userId && booking.OwnerID != userId {
+		app.errorJSON(w, errors.New("you are not a party to this booking"), nil, http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	unlock, err := app.lockDeposit(ctx, booking.ID)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	deposit, err := app.deposits.Get(ctx, booking.ID)
+	if errors.Is(err, ErrDepositNotFound) {
+		app.errorJSON(w, err, nil, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if deposit.Status != DepositAwaitingPayment {
+		app.errorJSON(w, fmt.Errorf("the deposit is already %s", deposit.Status), nil, http.StatusConflict)
+		return
+	}
+
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("PAYMENT_SERVICE_URL"), "verify-deposit"), map[string]interface{}{
+		"reference": deposit.Reference,
+	})
+	if err != nil {
+		app.errorJSON(w, err, nil, jsonFromService.StatusCode)
+		return
+	}
+
+	var payment struct {
+		Status string `json:"status"`
+	}
+	err = decodeServiceData(jsonFromService.Data, &payment)
+	if err != nil || payment.Status != "success" {
+		app.errorJSON(w, errors.New("the deposit has not been paid yet"), nil, http.StatusPaymentRequired)
+		return
+	}
+
+	deposit.AuthorizationURL = ""
+	deposit.setStatus(DepositHeld, userId, "", time.Now())
+
+	// a booking completed before the deposit was verified gets its claim window now
+	if booking.Status == BookingCompleted {
+		deposit.ClaimDeadline = time.Now().Add(depositClaimWindow()).UnixMilli()
+	}
+
+	err = app.deposits.Save(ctx, deposit)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	app.recordDeposit(ctx, deposit, userId, "", correlationID(r))
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "deposit held",
+		Data:       deposit,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ReleaseDeposit refunds the whole deposit of a completed booking to the renter
+func (app *Config) ReleaseDeposit(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload DepositPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateDepositInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to release deposit"), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, booking, ok := app.loadDepositBooking(w, user, requestPayload.BookingID)
+	if !ok {
+		return
+	}
+
+	app.settleDepositRequest(w, r, booking, userId, nil)
+}
+
+// DeductDeposit keeps part or all of the deposit of a completed booking for damages, with evidence
+func (app *Config) DeductDeposit(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload DepositDeductionPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateDepositDeductionInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to deduct deposit"), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, booking, ok := app.loadDepositBooking(w, user, requestPayload.BookingID)
+	if !ok {
+		return
+	}
+
+	app.settleDepositRequest(w, r, booking, userId, &DepositDeduction{
+		Amount:     roundMoney(requestPayload.Amount),
+		Reason:     requestPayload.Reason,
+		Evidence:   requestPayload.Evidence,
+		DeductedBy: userId,
+		DeductedAt: time.Now().UnixMilli(),
+	})
+}
+
+func (app *Config) settleDepositRequest(w http.ResponseWriter, r *http.Request, booking BookingSnapshot, userId string, deduction *DepositDeduction) {
+	if booking.OwnerID != userId {
+		app.errorJSON(w, errors.New("only the owner can settle a deposit"), nil, http.StatusForbidden)
+		return
+	}
+
+	if booking.Status != BookingCompleted {
+		app.errorJSON(w, fmt.Errorf("a deposit can not be settled on a %s booking", booking.Status), nil, http.StatusConflict)
+		return
+	}
+
+	if app.underDispute(r.Context(), DisputeBooking, booking.ID) {
+		app.errorJSON(w, errors.New("this booking is under dispute, an admin will settle its deposit"), nil, http.StatusConflict)
+		return
+	}
+
+	deposit, err := app.settleDeposit(r.Context(), booking.ID, deduction, userId, correlationID(r))
+	if errors.Is(err, ErrDepositNotFound) {
+		app.errorJSON(w, err, nil, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("deposit %s", deposit.Status),
+		Data:       deposit,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetBookingDetail returns a booking with its change history and deposit to its renter or owner
+func (app *Config) GetBookingDetail(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query param
+	bookingID := r.URL.Query().Get("booking_id")
+	if bookingID == "" {
+		app.errorJSON(w, errors.New("booking_id not supplied"), nil)
+		return
+	}
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	booking, err := app.getBookingSnapshot(bookingID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if booking.RenterID != userId && booking.OwnerID != userId {
+		app.errorJSON(w, errors.New("you are not a party to this booking"), nil, http.StatusForbidden)
+		return
+	}
+
+	detail := map[string]interface{}{
+		"booking": booking,
+		"changes": []BookingChange{},
+		"deposit": nil,
+	}
+
+	if app.bookingChanges != nil {
+		history, err := app.bookingChanges.History(r.Context(), booking.ID)
+		if err != nil {
+			log.Printf("[BOOKING] loading changes of %s failed: %v", booking.ID, err)
+		} else {
+			detail["changes"] = history
+		}
+	}
+
+	if app.deposits != nil {
+		deposit, err := app.deposits.Get(r.Context(), booking.ID)
+		if err == nil {
+			detail["deposit"] = deposit
+		} else if !errors.Is(err, ErrDepositNotFound) {
+			log.Printf("[BOOKING] loading the deposit of %s failed: %v", booking.ID, err)
+		}
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "booking retrieved",
+		Data:       detail,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
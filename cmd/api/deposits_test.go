@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDepositSettle(t *testing.T) {
+	now := time.Now()
+	held := Deposit{BookingID: "booking-1", Amount: 5000, Status: DepositHeld}
+
+	t.Log("Checking a release refunds the whole deposit once the money has moved")
+	deposit := held
+	assert.NoError(t, deposit.settle(nil, "owner-1", now))
+	assert.Equal(t, DepositSettling, deposit.Status)
+	assert.Equal(t, 5000.0, deposit.RefundedAmount)
+	deposit.settled("owner-1", now)
+	assert.Equal(t, DepositReleased, deposit.Status)
+	assert.Len(t, deposit.History, 2)
+
+	t.Log("Checking a deduction refunds the rest")
+	deposit = held
+	assert.NoError(t, deposit.settle(&DepositDeduction{Amount: 1200.5, Reason: "broken lens"}, "owner-1", now))
+	deposit.settled("owner-1", now)
+	assert.Equal(t, DepositDeducted, deposit.Status)
+	assert.Equal(t, 1200.5, deposit.DeductedAmount)
+	assert.Equal(t, 3799.5, deposit.RefundedAmount)
+
+	t.Log("Checking a deduction can not exceed the deposit")
+	deposit = held
+	assert.Error(t, deposit.settle(&DepositDeduction{Amount: 6000}, "owner-1", now))
+	assert.Equal(t, DepositHeld, deposit.Status)
+
+	t.Log("Checking only a held deposit can be settled")
+	deposit = held
+	deposit.Status = DepositAwaitingPayment
+	assert.Error(t, deposit.settle(nil, "owner-1", now))
+	deposit.Status = DepositSettling
+	assert.Error(t, deposit.settle(nil, "owner-1", now))
+}
+
+func TestDepositsDueForRelease(t *testing.T) {
+	for name, store := range map[string]DepositStore{
+		"memory": newMemoryDepositStore(),
+		"redis":  redisDepositStore{cache: newTestRedis(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+
+			_ = store.Save(ctx, Deposit{BookingID: "due", Status: DepositHeld, ClaimDeadline: now.Add(-time.Hour).UnixMilli()})
+			_ = store.Save(ctx, Deposit{BookingID: "open", Status: DepositHeld, ClaimDeadline: now.Add(time.Hour).UnixMilli()})
+			_ = store.Save(ctx, Deposit{BookingID: "active", Status: DepositHeld})
+			_ = store.Save(ctx, Deposit{BookingID: "settled", Status: DepositReleased, ClaimDeadline: now.Add(-time.Hour).UnixMilli()})
+			_ = store.Save(ctx, Deposit{BookingID: "settling", Status: DepositSettling, ClaimDeadline: now.Add(-time.Hour).UnixMilli()})
+
+			t.Log("Checking only held or unfinished deposits past their claim window are released")
+			due, err := store.DueForRelease(ctx, now)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, []string{"due", "settling"}, due)
+
+			_, err = store.Get(ctx, "missing")
+			assert.ErrorIs(t, err, ErrDepositNotFound)
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrDisputeNotFound is returned for an unknown dispute id, or a subject without an active dispute
+	ErrDisputeNotFound = errors.New("dispute not found")
+	// ErrDisputeExists is returned when the subject already has a dispute that is not resolved
+	ErrDisputeExists = errors.New("there is already an open dispute on this")
+)
+
+// DisputeStore keeps disputes, a booking or order has at most one active dispute at a time
+type DisputeStore interface {
+	// Create stores a new dispute, it fails with ErrDisputeExists while its subject has an active one
+	Create(ctx context.Context, d Dispute) error
+	// Save replaces an existing dispute, resolving it frees its subject for a new one
+	Save(ctx context.Context, d Dispute) error
+	Get(ctx context.Context, disputeID string) (Dispute, error)
+	// Active returns the dispute of a subject that is not resolved yet
+	Active(ctx context.Context, subjectType, subjectID string) (Dispute, error)
+	// ForUser returns the disputes userID is a party to, newest first
+	ForUser(ctx context.Context, userID string) ([]Dispute, error)
+	// ByStatus returns the disputes in status, least recently updated first
+	ByStatus(ctx context.Context, status DisputeStatus) ([]Dispute, error)
+	// RecordRefund notes amount was refunded on a subject by disputeID, recording the same dispute again replaces it
+	RecordRefund(ctx context.Context, subjectType, subjectID, disputeID string, amount float64) error
+	// Refunded returns what disputes refunded on a subject so far
+	Refunded(ctx context.Context, subjectType, subjectID string) (float64, error)
+}
+
+// redisDisputeStore keeps each dispute in a key, indexed by party and by status
+type redisDisputeStore struct {
+	cache *redis.Client
+}
+
+func disputeKey(disputeID string) string {
+	return fmt.Sprintf("disputes:%s", disputeID)
+}
+
+func disputeUserKey(userID string) string {
+	return fmt.Sprintf("disputes:user:%s", userID)
+}
+
+func disputeStatusKey(status DisputeStatus) string {
+	return fmt.Sprintf("disputes:status:%s", status)
+}
+
+func activeDisputeKey(subjectType, subjectID string) string {
+	return fmt.Sprintf("disputes:active:%s:%s", subjectType, subjectID)
+}
+
+func disputeRefundsKey(subjectType, subjectID string) string {
+	return fmt.Sprintf("disputes:refunds:%s:%s", subjectType, subjectID)
+}
+
+func (s redisDisputeStore) Create(ctx context.Context, d Dispute) error {
+	claimed, err := s.cache.SetNX(ctx, activeDisputeKey(d.SubjectType, d.SubjectID), d.ID, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrDisputeExists
+	}
+
+	err = s.Save(ctx, d)
+	if err != nil {
+		s.cache.Del(ctx, activeDisputeKey(d.SubjectType, d.SubjectID))
+	}
+
+	return err
+}
+
+func (s redisDisputeStore) Save(ctx context.Context, d Dispute) error {
+	rawDispute, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.cache.TxPipeline()
+	pipe.Set(ctx, disputeKey(d.ID), rawDispute, 0)
+	pipe.ZAdd(ctx, disputeUserKey(d.ClaimantID), redis.Z{Score: float64(d.CreatedAt), Member: d.ID})
+	pipe.ZAdd(ctx, disputeUserKey(d.RespondentID), redis.Z{Score: float64(d.CreatedAt), Member: d.ID})
+	for _, status := range disputeStatuses {
+		if status != d.Status {
+			pipe.ZRem(ctx, disputeStatusKey(status), d.ID)
+		}
+	}
+	pipe.ZAdd(ctx, disputeStatusKey(d.Status), redis.Z{Score: float64(d.UpdatedAt), Member: d.ID})
+	if d.Status == DisputeResolved {
+		pipe.Del(ctx, activeDisputeKey(d.SubjectType, d.SubjectID))
+	}
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+func (s redisDisputeStore) Get(ctx context.Context, disputeID string) (Dispute, error) {
+	rawDispute, err := s.cache.Get(ctx, disputeKey(disputeID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Dispute{}, ErrDisputeNotFound
+	}
+	if err != nil {
+		return Dispute{}, err
+	}
+
+	var d Dispute
+	err = json.Unmarshal(rawDispute, &d)
+
+	return d, err
+}
+
+func (s redisDisputeStore) Active(ctx context.Context, subjectType, subjectID string) (Dispute, error) {
+	disputeID, err := s.cache.Get(ctx, activeDisputeKey(subjectType, subjectID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return Dispute{}, ErrDisputeNotFound
+	}
+	if err != nil {
+		return Dispute{}, err
+	}
+
+	return s.Get(ctx, disputeID)
+}
+
+func (s redisDisputeStore) ForUser(ctx context.Context, userID string) ([]Dispute, error) {
+	ids, err := s.cache.ZRevRange(ctx, disputeUserKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.load(ctx, ids)
+}
+
+func (s redisDisputeStore) ByStatus(ctx context.Context, status DisputeStatus) ([]Dispute, error) {
+	ids, err := s.cache.ZRange(ctx, disputeStatusKey(status), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.load(ctx, ids)
+}
+
+func (s redisDisputeStore) RecordRefund(ctx context.Context, subjectType, subjectID, disputeID string, amount float64) error {
+	return s.cache.HSet(ctx, disputeRefundsKey(subjectType, subjectID), disputeID, amount).Err()
+}
+
+func (s redisDisputeStore) Refunded(ctx context.Context, subjectType, subjectID string) (float64, error) {
+	raw, err := s.cache.HGetAll(ctx, disputeRefundsKey(subjectType, subjectID)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var refunded float64
+	for disputeID, rawAmount := range raw {
+		amount, err := strconv.ParseFloat(rawAmount, 64)
+		if err != nil {
+			return 0, fmt.Errorf("reading the refund of dispute %s: %w", disputeID, err)
+		}
+		refunded += amount
+	}
+
+	return roundMoney(refunded), nil
+}
+
+// load reads the disputes of ids keeping their order, ids that no longer exist are skipped
+func (s redisDisputeStore) load(ctx context.Context, ids []string) ([]Dispute, error) {
+	disputes := make([]Dispute, 0, len(ids))
+	if len(ids) == 0 {
+		return disputes, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = disputeKey(id)
+	}
+
+	raw, err := s.cache.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rawDispute := range raw {
+		value, ok := rawDispute.(string)
+		if !ok {
+			continue
+		}
+
+		var d Dispute
+		if err := json.Unmarshal([]byte(value), &d); err != nil {
+			continue
+		}
+		disputes = append(disputes, d)
+	}
+
+	return disputes, nil
+}
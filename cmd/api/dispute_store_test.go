@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// memoryDisputeStore is a stand-in store used in tests
+type memoryDisputeStore struct {
+	mu       sync.Mutex
+	disputes map[string]Dispute
+	refunds  map[string]map[string]float64
+}
+
+func newMemoryDisputeStore() *memoryDisputeStore {
+	return &memoryDisputeStore{disputes: make(map[string]Dispute), refunds: make(map[string]map[string]float64)}
+}
+
+func (s *memoryDisputeStore) Create(ctx context.Context, d Dispute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.active(d.SubjectType, d.SubjectID); ok {
+		return ErrDisputeExists
+	}
+
+	s.disputes[d.ID] = d
+	return nil
+}
+
+func (s *memoryDisputeStore) Save(ctx context.Context, d Dispute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.disputes[d.ID] = d
+	return nil
+}
+
+func (s *memoryDisputeStore) Get(ctx context.Context, disputeID string) (Dispute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.disputes[disputeID]
+	if !ok {
+		return Dispute{}, ErrDisputeNotFound
+	}
+
+	return d, nil
+}
+
+func (s *memoryDisputeStore) Active(ctx context.Context, subjectType, subjectID string) (Dispute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.active(subjectType, subjectID)
+	if !ok {
+		return Dispute{}, ErrDisputeNotFound
+	}
+
+	return d, nil
+}
+
+func (s *memoryDisputeStore) active(subjectType, subjectID string) (Dispute, bool) {
+	for _, d := range s.disputes {
+		if d.SubjectType == subjectType && d.SubjectID == subjectID && d.Status != DisputeResolved {
+			return d, true
+		}
+	}
+
+	return Dispute{}, false
+}
+
+func (s *memoryDisputeStore) ForUser(ctx context.Context, userID string) ([]Dispute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	disputes := []Dispute{}
+	for _, d := range s.disputes {
+		if d.ClaimantID == userID || d.RespondentID == userID {
+			disputes = append(disputes, d)
+		}
+	}
+
+	sort.Slice(disputes, func(i, j int) bool { return disputes[i].CreatedAt > disputes[j].CreatedAt })
+	return disputes, nil
+}
+
+func (s *memoryDisputeStore) ByStatus(ctx context.Context, status DisputeStatus) ([]Dispute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	disputes := []Dispute{}
+	for _, d := range s.disputes {
+		if d.Status == status {
+			disputes = append(disputes, d)
+		}
+	}
+
+	sort.Slice(disputes, func(i, j int) bool { return disputes[i].UpdatedAt < disputes[j].UpdatedAt })
+	return disputes, nil
+}
+
+func (s *memoryDisputeStore) RecordRefund(ctx context.Context, subjectType, subjectID, disputeID string, amount float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := disputeRefundsKey(subjectType, subjectID)
+	if s.refunds[key] == nil {
+		s.refunds[key] = make(map[string]float64)
+	}
+	s.refunds[key][disputeID] = amount
+	return nil
+}
+
+func (s *memoryDisputeStore) Refunded(ctx context.Context, subjectType, subjectID string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var refunded float64
+	for _, amount := range s.refunds[disputeRefundsKey(subjectType, subjectID)] {
+		refunded += amount
+	}
+	return roundMoney(refunded), nil
+}
@@ -0,0 +1,1062 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/obynonwane/broker-service/event"
+)
+
+type DisputeStatus string
+
+const (
+	DisputeOpen             DisputeStatus = "open"              // waiting for the respondent's first answer
+	DisputeAwaitingResponse DisputeStatus = "awaiting_response" // waiting for the party named in awaiting_from
+	DisputeEscalated        DisputeStatus = "escalated"         // waiting for an admin
+	DisputeResolved         DisputeStatus = "resolved"
+)
+
+var disputeStatuses = []DisputeStatus{DisputeOpen, DisputeAwaitingResponse, DisputeEscalated, DisputeResolved}
+
+// disputeTransitions lists the statuses a dispute can move to from each status
+var disputeTransitions = map[DisputeStatus][]DisputeStatus{
+	DisputeOpen:             {DisputeAwaitingResponse, DisputeEscalated, DisputeResolved},
+	DisputeAwaitingResponse: {DisputeAwaitingResponse, DisputeEscalated, DisputeResolved},
+	DisputeEscalated:        {DisputeResolved},
+}
+
+// what a dispute can be about
+const (
+	DisputeBooking = "booking"
+	DisputeOrder   = "order"
+)
+
+// the part a user plays in a dispute
+const (
+	disputeClaimant   = "claimant"
+	disputeRespondent = "respondent"
+	disputeAdmin      = "admin"
+)
+
+// how a dispute ended, the deposit outcomes only apply to bookings
+const (
+	DisputeNoAction         = "no_action"
+	DisputeWithdrawn        = "withdrawn" // by the claimant
+	DisputeDepositRelease   = "deposit_release"
+	DisputeDepositDeduction = "deposit_deduction"
+	DisputeRefund           = "refund"
+)
+
+// disputeOutcomes are the outcomes an admin can resolve a dispute with
+var disputeOutcomes = []string{DisputeNoAction, DisputeDepositRelease, DisputeDepositDeduction, DisputeRefund}
+
+// what happened to a dispute, carried by dispute.updated
+const (
+	disputeOpenedAction    = "opened"
+	disputeMessageAction   = "message"
+	disputeEscalatedAction = "escalated"
+	disputeWithdrawnAction = "withdrawn"
+	disputeResolvedAction  = "resolved"
+)
+
+// disputableBookingStatuses are the booking states something can have gone wrong in
+var disputableBookingStatuses = []BookingStatus{BookingAccepted, BookingActive, BookingCompleted, BookingCancelled}
+
+// disputableOrderStatuses are the order states something can have gone wrong in
+var disputableOrderStatuses = []OrderStatus{OrderAccepted, OrderShipped, OrderReadyForPickup, OrderCompleted, OrderCancelled}
+
+const (
+	// maxDisputeEvidence bounds the evidence urls of a dispute or message
+	maxDisputeEvidence = 10
+	// maxDisputeTextLen bounds a dispute description or message
+	maxDisputeTextLen = 2000
+	// defaultDisputeResponseWindow is how long a party has to answer before the dispute is escalated, see DISPUTE_RESPONSE_HOURS
+	defaultDisputeResponseWindow = 72 * time.Hour
+	// disputeLockTTL bounds how long one change can block others on the same dispute
+	disputeLockTTL = 30 * time.Second
+)
+
+// Dispute is a problem a party raised about a booking or purchase order
+type Dispute struct {
+	ID           string                `json:"id"`
+	SubjectType  string                `json:"subject_type"`
+	SubjectID    string                `json:"subject_id"`
+	ClaimantID   string                `json:"claimant_id"`
+	RespondentID string                `json:"respondent_id"`
+	Description  string                `json:"description"`
+	Evidence     []string              `json:"evidence"`
+	Status       DisputeStatus         `json:"status"`
+	AwaitingFrom string                `json:"awaiting_from,omitempty"` // user id, set while awaiting a response
+	Messages     []DisputeMessage      `json:"messages"`
+	Resolution   *DisputeResolution    `json:"resolution,omitempty"`
+	History      []DisputeStatusChange `json:"history"`
+	CreatedAt    int64                 `json:"created_at"`
+	UpdatedAt    int64                 `json:"updated_at"`
+}
+
+type DisputeMessage struct {
+	ID         string   `json:"id"`
+	AuthorID   string   `json:"author_id"`
+	AuthorRole string   `json:"author_role"`
+	Body       string   `json:"body"`
+	Evidence   []string `json:"evidence,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+type DisputeResolution struct {
+	Outcome    string   `json:"outcome"`
+	Amount     float64  `json:"amount,omitempty"`
+	Reason     string   `json:"reason"`
+	ResolvedBy string   `json:"resolved_by"`
+	ResolvedAt int64    `json:"resolved_at"`
+	Deposit    *Deposit `json:"deposit,omitempty"` // the settled deposit of a deposit outcome
+}
+
+type DisputeStatusChange struct {
+	Status    DisputeStatus `json:"status"`
+	ChangedBy string        `json:"changed_by"`
+	Reason    string        `json:"reason,omitempty"`
+	At        int64         `json:"at"`
+}
+
+// role returns the part userID plays in the dispute, empty when they are not a party
+func (d Dispute) role(userID string) string {
+	switch userID {
+	case d.ClaimantID:
+		return disputeClaimant
+	case d.RespondentID:
+		return disputeRespondent
+	default:
+		return ""
+	}
+}
+
+// partyID returns the user id of role
+func (d Dispute) partyID(role string) string {
+	switch role {
+	case disputeClaimant:
+		return d.ClaimantID
+	case disputeRespondent:
+		return d.RespondentID
+	default:
+		return ""
+	}
+}
+
+// evidence returns the evidence of the dispute and of every message
+func (d Dispute) evidence() []string {
+	evidence := slices.Clone(d.Evidence)
+	for _, message := range d.Messages {
+		evidence = append(evidence, message.Evidence...)
+	}
+
+	return evidence
+}
+
+// transition moves the dispute to status and records it in its history
+func (d *Dispute) transition(status DisputeStatus, changedBy, reason string, now time.Time) error {
+	if !slices.Contains(disputeTransitions[d.Status], status) {
+		return fmt.Errorf("a %s dispute can not move to %s", d.Status, status)
+	}
+
+	if status != d.Status {
+		d.History = append(d.History, DisputeStatusChange{Status: status, ChangedBy: changedBy, Reason: reason, At: now.UnixMilli()})
+	}
+
+	d.Status = status
+	d.UpdatedAt = now.UnixMilli()
+	if status != DisputeAwaitingResponse {
+		d.AwaitingFrom = ""
+	}
+
+	return nil
+}
+
+// reply adds message to the thread. A party's message waits on the other party, an admin's waits on
+// awaitRole when one is given. An escalated dispute stays with the admins whoever writes.
+func (d *Dispute) reply(message DisputeMessage, awaitRole string, now time.Time) error {
+	if d.Status == DisputeResolved {
+		return errors.New("a resolved dispute can not take messages")
+	}
+
+	awaiting := ""
+	switch message.AuthorRole {
+	case disputeClaimant:
+		awaiting = d.RespondentID
+	case disputeRespondent:
+		awaiting = d.ClaimantID
+	case disputeAdmin:
+		awaiting = d.partyID(awaitRole)
+	}
+
+	if d.Status != DisputeEscalated && awaiting != "" {
+		err := d.transition(DisputeAwaitingResponse, message.AuthorID, "", now)
+		if err != nil {
+			return err
+		}
+		d.AwaitingFrom = awaiting
+	}
+
+	d.Messages = append(d.Messages, message)
+	d.UpdatedAt = now.UnixMilli()
+
+	return nil
+}
+
+// disputeResponseWindow reads DISPUTE_RESPONSE_HOURS
+func disputeResponseWindow() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("DISPUTE_RESPONSE_HOURS"))
+	if err != nil || hours <= 0 {
+		return defaultDisputeResponseWindow
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+// DisputeSubject is the booking or order a dispute is about
+type DisputeSubject struct {
+	Type            string  `json:"type"`
+	ID              string  `json:"id"`
+	CustomerID      string  `json:"customer_id"` // the renter or buyer
+	ProviderID      string  `json:"provider_id"` // the owner or seller
+	Status          string  `json:"status"`
+	PaidAmount      float64 `json:"paid_amount"`     // what can be refunded, a booking's deposit is settled on its own
+	RefundedAmount  float64 `json:"refunded_amount"` // what earlier disputes already refunded
+	SecurityDeposit float64 `json:"security_deposit"`
+}
+
+// refundable is what is left to refund on the subject
+func (s DisputeSubject) refundable() float64 {
+	return max(roundMoney(s.PaidAmount-s.RefundedAmount), 0)
+}
+
+// loadDisputeSubject loads the booking or order a dispute is about
+func (app *Config) loadDisputeSubject(subjectType, subjectID string) (DisputeSubject, error) {
+	switch subjectType {
+	case DisputeBooking:
+		booking, err := app.getBookingSnapshot(subjectID)
+		if err != nil {
+			return DisputeSubject{}, err
+		}
+
+		return DisputeSubject{
+			Type:            DisputeBooking,
+			ID:              booking.ID,
+			CustomerID:      booking.RenterID,
+			ProviderID:      booking.OwnerID,
+			Status:          string(booking.Status),
+			PaidAmount:      roundMoney(booking.TotalAmount - booking.SecurityDeposit),
+			SecurityDeposit: booking.SecurityDeposit,
+		}, nil
+	case DisputeOrder:
+		order, err := app.getOrderSnapshot(subjectID)
+		if err != nil {
+			return DisputeSubject{}, err
+		}
+
+		return DisputeSubject{
+			Type:       DisputeOrder,
+			ID:         order.ID,
+			CustomerID: order.BuyerID,
+			ProviderID: order.SellerID,
+			Status:     string(order.Status),
+			PaidAmount: order.TotalAmount,
+		}, nil
+	default:
+		return DisputeSubject{}, fmt.Errorf("disputes can not be opened on a %s", subjectType)
+	}
+}
+
+// validateDisputeOutcome checks an admin's outcome against what the dispute is about
+func validateDisputeOutcome(subject DisputeSubject, outcome string, amount float64) error {
+	switch outcome {
+	case DisputeNoAction:
+		return nil
+	case DisputeDepositRelease, DisputeDepositDeduction:
+		if subject.Type != DisputeBooking || subject.SecurityDeposit <= 0 {
+			return errors.New("only a booking with a security deposit can be resolved with a deposit outcome")
+		}
+		if outcome == DisputeDepositDeduction && (amount <= 0 || amount > subject.SecurityDeposit+priceTolerance) {
+			return fmt.Errorf("the deduction must be between 0 and %.2f", subject.SecurityDeposit)
+		}
+	case DisputeRefund:
+		if amount <= 0 || amount > subject.refundable()+priceTolerance {
+			return fmt.Errorf("the refund must be between 0 and %.2f", subject.refundable())
+		}
+	default:
+		return fmt.Errorf("%s is not a dispute outcome", outcome)
+	}
+
+	return nil
+}
+
+// applyDisputeOutcome settles the deposit or refunds the customer as an admin decided,
+// it returns the settled deposit of a deposit outcome
+func (app *Config) applyDisputeOutcome(ctx context.Context, dispute Dispute, subject DisputeSubject, resolution DisputeResolution, corrID string) (*Deposit, error) {
+	switch resolution.Outcome {
+	case DisputeDepositRelease, DisputeDepositDeduction:
+		if app.deposits == nil {
+			return nil, errors.New("deposits are not available")
+		}
+
+		var deduction *DepositDeduction
+		if resolution.Outcome == DisputeDepositDeduction {
+			deduction = &DepositDeduction{
+				Amount:     resolution.Amount,
+				Reason:     resolution.Reason,
+				Evidence:   dispute.evidence(),
+				DeductedBy: resolution.ResolvedBy,
+				DeductedAt: resolution.ResolvedAt,
+			}
+		}
+
+		deposit, err := app.settleDeposit(ctx, subject.ID, deduction, resolution.ResolvedBy, corrID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &deposit, nil
+	case DisputeRefund:
+		_, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("PAYMENT_SERVICE_URL"), "refund-payment"), map[string]interface{}{
+			"reference":    fmt.Sprintf("dsp_%s", dispute.ID),
+			"subject_type": subject.Type,
+			"subject_id":   subject.ID,
+			"user_id":      subject.CustomerID,
+			"amount":       resolution.Amount,
+			"reason":       resolution.Reason,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// the reference makes the refund idempotent, if recording it fails the dispute stays open and
+		// resolving it again only records it
+		return nil, app.disputes.RecordRefund(ctx, subject.Type, subject.ID, dispute.ID, resolution.Amount)
+	}
+
+	return nil, nil
+}
+
+// lockDispute keeps two changes to a dispute from racing, call the returned func to unlock
+func (app *Config) lockDispute(ctx context.Context, disputeID string) (func(), error) {
+	unlock, err := app.acquireLock(ctx, fmt.Sprintf("disputes:lock:%s", disputeID), disputeLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return nil, errors.New("this dispute is being updated, please try again")
+	}
+
+	return unlock, err
+}
+
+// underDispute reports whether a booking or order has a dispute that is not resolved,
+// when that can not be told it errs on the side of a dispute
+func (app *Config) underDispute(ctx context.Context, subjectType, subjectID string) bool {
+	if app.disputes == nil {
+		return false
+	}
+
+	_, err := app.disputes.Active(ctx, subjectType, subjectID)
+	if errors.Is(err, ErrDisputeNotFound) {
+		return false
+	}
+	if err != nil {
+		log.Printf("[DISPUTES] checking %s %s for a dispute failed: %v", subjectType, subjectID, err)
+	}
+
+	return true
+}
+
+// escalateStaleDisputes hands disputes nobody answered in time to the admins
+func (app *Config) escalateStaleDisputes(ctx context.Context) error {
+	if app.disputes == nil {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-disputeResponseWindow()).UnixMilli()
+
+	var stale []Dispute
+	for _, status := range []DisputeStatus{DisputeOpen, DisputeAwaitingResponse} {
+		disputes, err := app.disputes.ByStatus(ctx, status)
+		if err != nil {
+			return err
+		}
+
+		for _, dispute := range disputes {
+			if dispute.UpdatedAt <= cutoff {
+				stale = append(stale, dispute)
+			}
+		}
+	}
+
+	var failed int
+	for _, dispute := range stale {
+		err := app.escalateDispute(ctx, dispute.ID, bookingSystem, "no response in time", "")
+		if err != nil {
+			log.Printf("[JOBS] escalating dispute %s failed: %v", dispute.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d disputes could not be escalated", failed, len(stale))
+	}
+
+	return nil
+}
+
+func (app *Config) escalateDispute(ctx context.Context, disputeID, changedBy, reason, corrID string) error {
+	unlock, err := app.lockDispute(ctx, disputeID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dispute, err := app.disputes.Get(ctx, disputeID)
+	if err != nil {
+		return err
+	}
+
+	err = dispute.transition(DisputeEscalated, changedBy, reason, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = app.disputes.Save(ctx, dispute)
+	if err != nil {
+		return err
+	}
+
+	app.recordDispute(ctx, dispute, disputeEscalatedAction, changedBy, corrID)
+	return nil
+}
+
+func (app *Config) recordDispute(ctx context.Context, dispute Dispute, action, changedBy, corrID string) {
+	e := event.DisputeUpdatedEvent{
+		DisputeID:    dispute.ID,
+		SubjectType:  dispute.SubjectType,
+		SubjectID:    dispute.SubjectID,
+		ClaimantID:   dispute.ClaimantID,
+		RespondentID: dispute.RespondentID,
+		Action:       action,
+		Status:       string(dispute.Status),
+		ChangedBy:    changedBy,
+	}
+	if dispute.Resolution != nil {
+		e.Outcome = dispute.Resolution.Outcome
+		e.Amount = dispute.Resolution.Amount
+	}
+
+	app.recordEventOrPublish(ctx, e, corrID)
+}
+
+type OpenDisputePayload struct {
+	SubjectType string   `json:"subject_type"` // "booking" or "order"
+	SubjectID   string   `json:"subject_id"`
+	Description string   `json:"description"`
+	Evidence    []string `json:"evidence"` // urls of uploaded photos or documents
+}
+
+type DisputeMessagePayload struct {
+	DisputeID string   `json:"dispute_id"`
+	Body      string   `json:"body"`
+	Evidence  []string `json:"evidence"`
+	AwaitFrom string   `json:"await_from"` // admins only, "claimant" or "respondent"
+}
+
+type DisputeActionPayload struct {
+	DisputeID string `json:"dispute_id"`
+	Reason    string `json:"reason"`
+}
+
+type ResolveDisputePayload struct {
+	DisputeID string  `json:"dispute_id"`
+	Outcome   string  `json:"outcome"`
+	Amount    float64 `json:"amount"`
+	Reason    string  `json:"reason"`
+}
+
+// loadDispute loads a dispute for a party to it or an admin
+func (app *Config) loadDispute(ctx context.Context, w http.ResponseWriter, user jsonResponse, disputeID string) (string, Dispute, bool) {
+	if app.disputes == nil {
+		app.errorJSON(w, errors.New("disputes are not available"), nil, http.StatusServiceUnavailable)
+		return "", Dispute{}, false
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return "", Dispute{}, false
+	}
+
+	dispute, err := app.disputes.Get(ctx, disputeID)
+	if errors.Is(err, ErrDisputeNotFound) {
+		app.errorJSON(w, err, nil, http.StatusNotFound)
+		return "", Dispute{}, false
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return "", Dispute{}, false
+	}
+
+	if dispute.role(userId) == "" && !app.isAdminUser(user) {
+		app.errorJSON(w, errors.New("you are not a party to this dispute"), nil, http.StatusForbidden)
+		return "", Dispute{}, false
+	}
+
+	return userId, dispute, true
+}
+
+// OpenDispute lets a renter, owner, buyer or seller raise a problem with a booking or order
+func (app *Config) OpenDispute(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload OpenDisputePayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateOpenDisputeInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to open dispute"), err, http.StatusBadRequest)
+		return
+	}
+
+	if app.disputes == nil {
+		app.errorJSON(w, errors.New("disputes are not available"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	subject, err := app.loadDisputeSubject(requestPayload.SubjectType, requestPayload.SubjectID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	respondentID := subject.ProviderID
+	if userId == subject.ProviderID {
+		respondentID = subject.CustomerID
+	} else if userId != subject.CustomerID {
+		app.errorJSON(w, fmt.Errorf("you are not a party to this %s", subject.Type), nil, http.StatusForbidden)
+		return
+	}
+
+	if subject.Type == DisputeBooking && !slices.Contains(disputableBookingStatuses, BookingStatus(subject.Status)) {
+		app.errorJSON(w, fmt.Errorf("a dispute can not be opened on a %s booking", subject.Status), nil, http.StatusConflict)
+		return
+	}
+
+	if subject.Type == DisputeOrder && !slices.Contains(disputableOrderStatuses, OrderStatus(subject.Status)) {
+		app.errorJSON(w, fmt.Errorf("a dispute can not be opened on a %s order", subject.Status), nil, http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	dispute := Dispute{
+		ID:           uuid.NewString(),
+		SubjectType:  subject.Type,
+		SubjectID:    subject.ID,
+		ClaimantID:   userId,
+		RespondentID: respondentID,
+		Description:  requestPayload.Description,
+		Evidence:     requestPayload.Evidence,
+		Status:       DisputeOpen,
+		AwaitingFrom: respondentID,
+		Messages:     []DisputeMessage{},
+		History:      []DisputeStatusChange{{Status: DisputeOpen, ChangedBy: userId, At: now.UnixMilli()}},
+		CreatedAt:    now.UnixMilli(),
+		UpdatedAt:    now.UnixMilli(),
+	}
+
+	err = app.disputes.Create(r.Context(), dispute)
+	if errors.Is(err, ErrDisputeExists) {
+		app.errorJSON(w, fmt.Errorf("there is already an open dispute on this %s", subject.Type), nil, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	app.recordDispute(r.Context(), dispute, disputeOpenedAction, userId, correlationID(r))
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dispute opened",
+		Data:       dispute,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// PostDisputeMessage adds a message to the thread of a dispute, from a party or an admin
+func (app *Config) PostDisputeMessage(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload DisputeMessagePayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateDisputeMessageInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to post dispute message"), err, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	unlock, err := app.lockDispute(ctx, requestPayload.DisputeID)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	userId, dispute, ok := app.loadDispute(ctx, w, user, requestPayload.DisputeID)
+	if !ok {
+		return
+	}
+
+	role := dispute.role(userId)
+	if role == "" {
+		role = disputeAdmin
+	}
+
+	if requestPayload.AwaitFrom != "" && role != disputeAdmin {
+		app.errorJSON(w, errors.New("only admins can choose who answers next"), nil, http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	err = dispute.reply(DisputeMessage{
+		ID:         uuid.NewString(),
+		AuthorID:   userId,
+		AuthorRole: role,
+		Body:       requestPayload.Body,
+		Evidence:   requestPayload.Evidence,
+		CreatedAt:  now.UnixMilli(),
+	}, requestPayload.AwaitFrom, now)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	err = app.disputes.Save(ctx, dispute)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	app.recordDispute(ctx, dispute, disputeMessageAction, userId, correlationID(r))
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dispute message posted",
+		Data:       dispute,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// EscalateDispute lets either party hand a dispute to the admins
+func (app *Config) EscalateDispute(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload DisputeActionPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateDisputeActionInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to escalate dispute"), err, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	userId, dispute, ok := app.loadDispute(ctx, w, user, requestPayload.DisputeID)
+	if !ok {
+		return
+	}
+
+	if dispute.role(userId) == "" {
+		app.errorJSON(w, errors.New("only a party to the dispute can escalate it"), nil, http.StatusForbidden)
+		return
+	}
+
+	err = app.escalateDispute(ctx, dispute.ID, userId, requestPayload.Reason, correlationID(r))
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	dispute, err = app.disputes.Get(ctx, dispute.ID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dispute escalated",
+		Data:       dispute,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// WithdrawDispute lets the claimant drop a dispute that is not resolved yet
+func (app *Config) WithdrawDispute(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload DisputeActionPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateDisputeActionInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to withdraw dispute"), err, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	unlock, err := app.lockDispute(ctx, requestPayload.DisputeID)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	userId, dispute, ok := app.loadDispute(ctx, w, user, requestPayload.DisputeID)
+	if !ok {
+		return
+	}
+
+	if dispute.role(userId) != disputeClaimant {
+		app.errorJSON(w, errors.New("only the claimant can withdraw a dispute"), nil, http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	err = dispute.transition(DisputeResolved, userId, requestPayload.Reason, now)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	dispute.Resolution = &DisputeResolution{
+		Outcome:    DisputeWithdrawn,
+		Reason:     requestPayload.Reason,
+		ResolvedBy: userId,
+		ResolvedAt: now.UnixMilli(),
+	}
+
+	err = app.disputes.Save(ctx, dispute)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	app.recordDispute(ctx, dispute, disputeWithdrawnAction, userId, correlationID(r))
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dispute withdrawn",
+		Data:       dispute,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// MyDisputes lists the disputes the user opened or has to answer
+func (app *Config) MyDisputes(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	if app.disputes == nil {
+		app.errorJSON(w, errors.New("disputes are not available"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	disputes, err := app.disputes.ForUser(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "disputes retrieved",
+		Data:       disputes,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetDispute returns a dispute with its thread to a party or an admin
+func (app *Config) GetDispute(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query param
+	disputeID := r.URL.Query().Get("dispute_id")
+	if disputeID == "" {
+		app.errorJSON(w, errors.New("dispute_id not supplied"), nil)
+		return
+	}
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	_, dispute, ok := app.loadDispute(r.Context(), w, user, disputeID)
+	if !ok {
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dispute retrieved",
+		Data:       dispute,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetDisputeQueue lists the disputes in a status for admins, escalated ones by default
+func (app *Config) GetDisputeQueue(w http.ResponseWriter, r *http.Request) {
+	if !app.verifyAdmin(w, r) {
+		return
+	}
+
+	if app.disputes == nil {
+		app.errorJSON(w, errors.New("disputes are not available"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	status := DisputeStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = DisputeEscalated
+	}
+
+	if !slices.Contains(disputeStatuses, status) {
+		app.errorJSON(w, fmt.Errorf("%s is not a dispute status", status), nil)
+		return
+	}
+
+	disputes, err := app.disputes.ByStatus(r.Context(), status)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "disputes retrieved",
+		Data:       disputes,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ResolveDispute lets an admin close a dispute, settling the deposit or refunding the customer if decided
+func (app *Config) ResolveDispute(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	if !app.isAdminUser(user) {
+		app.errorJSON(w, errors.New("only admins can resolve disputes"), nil, http.StatusForbidden)
+		return
+	}
+
+	//extract the request body
+	var requestPayload ResolveDisputePayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateResolveDisputeInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to resolve dispute"), err, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	unlock, err := app.lockDispute(ctx, requestPayload.DisputeID)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	userId, dispute, ok := app.loadDispute(ctx, w, user, requestPayload.DisputeID)
+	if !ok {
+		return
+	}
+
+	if dispute.Status == DisputeResolved {
+		app.errorJSON(w, errors.New("the dispute is already resolved"), nil, http.StatusConflict)
+		return
+	}
+
+	subject, err := app.loadDisputeSubject(dispute.SubjectType, dispute.SubjectID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// a subject can be disputed again once resolved, refunds are capped by what earlier ones left
+	subject.RefundedAmount, err = app.disputes.Refunded(ctx, subject.Type, subject.ID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	amount := roundMoney(requestPayload.Amount)
+	if err := validateDisputeOutcome(subject, requestPayload.Outcome, amount); err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	resolution := DisputeResolution{
+		Outcome:    requestPayload.Outcome,
+		Amount:     amount,
+		Reason:     requestPayload.Reason,
+		ResolvedBy: userId,
+		ResolvedAt: now.UnixMilli(),
+	}
+
+	// move the money first so a failed payment call leaves the dispute open
+	resolution.Deposit, err = app.applyDisputeOutcome(ctx, dispute, subject, resolution, correlationID(r))
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	err = dispute.transition(DisputeResolved, userId, requestPayload.Reason, now)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+	dispute.Resolution = &resolution
+
+	err = app.disputes.Save(ctx, dispute)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	app.recordDispute(ctx, dispute, disputeResolvedAction, userId, correlationID(r))
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dispute resolved",
+		Data:       dispute,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
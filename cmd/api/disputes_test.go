@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisputeThread(t *testing.T) {
+	now := time.Now()
+	dispute := Dispute{ID: "dispute-1", ClaimantID: "renter-1", RespondentID: "owner-1", Status: DisputeOpen, AwaitingFrom: "owner-1"}
+
+	t.Log("Checking the respondent's answer waits on the claimant")
+	assert.NoError(t, dispute.reply(DisputeMessage{AuthorID: "owner-1", AuthorRole: disputeRespondent, Body: "it was scratched before"}, "", now))
+	assert.Equal(t, DisputeAwaitingResponse, dispute.Status)
+	assert.Equal(t, "renter-1", dispute.AwaitingFrom)
+	assert.Len(t, dispute.History, 1)
+
+	t.Log("Checking an admin can ask a party to answer")
+	assert.NoError(t, dispute.reply(DisputeMessage{AuthorID: "admin-1", AuthorRole: disputeAdmin, Body: "please share photos"}, disputeRespondent, now))
+	assert.Equal(t, "owner-1", dispute.AwaitingFrom)
+
+	t.Log("Checking an escalated dispute stays with the admins")
+	assert.NoError(t, dispute.transition(DisputeEscalated, "renter-1", "", now))
+	assert.Empty(t, dispute.AwaitingFrom)
+	assert.NoError(t, dispute.reply(DisputeMessage{AuthorID: "renter-1", AuthorRole: disputeClaimant, Body: "photos attached"}, "", now))
+	assert.Equal(t, DisputeEscalated, dispute.Status)
+	assert.Len(t, dispute.Messages, 3)
+
+	t.Log("Checking an escalated dispute can only be resolved")
+	assert.Error(t, dispute.transition(DisputeAwaitingResponse, "owner-1", "", now))
+	assert.NoError(t, dispute.transition(DisputeResolved, "admin-1", "", now))
+
+	t.Log("Checking a resolved dispute is closed")
+	assert.Error(t, dispute.transition(DisputeEscalated, "renter-1", "", now))
+	assert.Error(t, dispute.reply(DisputeMessage{AuthorID: "renter-1", AuthorRole: disputeClaimant, Body: "hello"}, "", now))
+}
+
+func TestValidateDisputeOutcome(t *testing.T) {
+	booking := DisputeSubject{Type: DisputeBooking, ID: "booking-1", PaidAmount: 20000, SecurityDeposit: 5000}
+	order := DisputeSubject{Type: DisputeOrder, ID: "order-1", PaidAmount: 8000}
+
+	t.Log("Checking deposit outcomes only apply to bookings with a deposit")
+	assert.NoError(t, validateDisputeOutcome(booking, DisputeDepositRelease, 0))
+	assert.NoError(t, validateDisputeOutcome(booking, DisputeDepositDeduction, 5000))
+	assert.Error(t, validateDisputeOutcome(booking, DisputeDepositDeduction, 5000.5))
+	assert.Error(t, validateDisputeOutcome(booking, DisputeDepositDeduction, 0))
+	assert.Error(t, validateDisputeOutcome(order, DisputeDepositRelease, 0))
+
+	t.Log("Checking a refund is bounded by what was paid")
+	assert.NoError(t, validateDisputeOutcome(order, DisputeRefund, 8000))
+	assert.Error(t, validateDisputeOutcome(order, DisputeRefund, 9000))
+	assert.Error(t, validateDisputeOutcome(order, DisputeRefund, 0))
+
+	t.Log("Checking refunds of earlier disputes count against what can still be refunded")
+	order.RefundedAmount = 5000
+	assert.NoError(t, validateDisputeOutcome(order, DisputeRefund, 3000))
+	assert.Error(t, validateDisputeOutcome(order, DisputeRefund, 3001))
+	order.RefundedAmount = 8000
+	assert.Error(t, validateDisputeOutcome(order, DisputeRefund, 1))
+
+	t.Log("Checking withdrawal is not an admin outcome")
+	assert.NoError(t, validateDisputeOutcome(order, DisputeNoAction, 0))
+	assert.Error(t, validateDisputeOutcome(order, DisputeWithdrawn, 0))
+}
+
+func TestDisputeStoreActive(t *testing.T) {
+	for name, store := range map[string]DisputeStore{
+		"memory": newMemoryDisputeStore(),
+		"redis":  redisDisputeStore{cache: newTestRedis(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			dispute := Dispute{ID: "dispute-1", SubjectType: DisputeBooking, SubjectID: "booking-1", ClaimantID: "renter-1", RespondentID: "owner-1", Status: DisputeOpen, CreatedAt: 1}
+			assert.NoError(t, store.Create(ctx, dispute))
+
+			t.Log("Checking a subject takes one active dispute at a time")
+			assert.ErrorIs(t, store.Create(ctx, Dispute{ID: "dispute-2", SubjectType: DisputeBooking, SubjectID: "booking-1", Status: DisputeOpen}), ErrDisputeExists)
+			assert.NoError(t, store.Create(ctx, Dispute{ID: "dispute-3", SubjectType: DisputeOrder, SubjectID: "booking-1", ClaimantID: "owner-1", Status: DisputeOpen, CreatedAt: 2}))
+
+			active, err := store.Active(ctx, DisputeBooking, "booking-1")
+			assert.NoError(t, err)
+			assert.Equal(t, "dispute-1", active.ID)
+
+			t.Log("Checking resolving frees the subject")
+			dispute.Status = DisputeResolved
+			assert.NoError(t, store.Save(ctx, dispute))
+			_, err = store.Active(ctx, DisputeBooking, "booking-1")
+			assert.ErrorIs(t, err, ErrDisputeNotFound)
+			assert.NoError(t, store.Create(ctx, Dispute{ID: "dispute-2", SubjectType: DisputeBooking, SubjectID: "booking-1", Status: DisputeOpen}))
+
+			t.Log("Checking a user sees the disputes they are a party to, newest first")
+			disputes, err := store.ForUser(ctx, "owner-1")
+			assert.NoError(t, err)
+			assert.Len(t, disputes, 2)
+			assert.Equal(t, "dispute-3", disputes[0].ID)
+
+			t.Log("Checking refunds add up per subject and a dispute is only counted once")
+			assert.NoError(t, store.RecordRefund(ctx, DisputeBooking, "booking-1", "dispute-1", 2500))
+			assert.NoError(t, store.RecordRefund(ctx, DisputeBooking, "booking-1", "dispute-1", 2500))
+			assert.NoError(t, store.RecordRefund(ctx, DisputeBooking, "booking-1", "dispute-2", 1000.5))
+			assert.NoError(t, store.RecordRefund(ctx, DisputeOrder, "booking-1", "dispute-3", 700))
+			refunded, err := store.Refunded(ctx, DisputeBooking, "booking-1")
+			assert.NoError(t, err)
+			assert.Equal(t, 3500.5, refunded)
+		})
+	}
+}
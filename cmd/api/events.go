@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/obynonwane/broker-service/event"
+	"github.com/redis/go-redis/v9"
+)
+
+// eventProducer identifies the broker as the producer of the events it publishes
+const eventProducer = "broker-service"
+
+// publishEvent wraps e in an envelope and publishes it, waiting for rabbitmq to confirm it
+func (app *Config) publishEvent(ctx context.Context, e event.DomainEvent, correlationID string) error {
+	envelope, err := event.NewEnvelope(eventProducer, e, correlationID)
+	if err != nil {
+		return err
+	}
+
+	return app.publishEnvelope(ctx, envelope)
+}
+
+// correlationID returns the id a client or upstream proxy sent to tie events to the request
+func correlationID(r *http.Request) string {
+	if id := r.Header.Get("X-Correlation-ID"); id != "" {
+		return id
+	}
+
+	return r.Header.Get("X-Request-ID")
+}
+
+// redisIDStore remembers handled event ids in redis, see event.Deduplicate
+type redisIDStore struct {
+	cache *redis.Client
+	ttl   time.Duration
+}
+
+func (s redisIDStore) Remember(ctx context.Context, id string) (bool, error) {
+	return s.cache.SetNX(ctx, "events:seen:"+id, 1, s.ttl).Result()
+}
+
+func (s redisIDStore) Forget(ctx context.Context, id string) error {
+	return s.cache.Del(ctx, "events:seen:"+id).Err()
+}
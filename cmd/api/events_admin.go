@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/obynonwane/broker-service/event"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// maxDeadLetterBatch caps how many dead letters are read or replayed per request
+const maxDeadLetterBatch = 100
+
+type ReplayDeadLettersPayload struct {
+	Queue     string `json:"queue"`
+	MessageID string `json:"message_id"`
+	Limit     int    `json:"limit"`
+}
+
+type PurgeDeadLettersPayload struct {
+	Queue string `json:"queue"`
+}
+
+// verifyAdmin verifies the token and makes sure it belongs to an admin, it writes the error response itself
+func (app *Config) verifyAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return false
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return false
+	}
+
+	if !app.isAdminUser(user) {
+		app.errorJSON(w, errors.New("only admins can manage events"), nil, http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (app *Config) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query param
+	queryParams := r.URL.Query()
+	queue := queryParams.Get("queue")
+	if queue == "" {
+		app.errorJSON(w, errors.New("queue not supplied"), nil)
+		return
+	}
+
+	limit := 20
+	if limitStr := queryParams.Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 {
+			app.errorJSON(w, errors.New("invalid limit number"), nil)
+			return
+		}
+		limit = min(l, maxDeadLetterBatch)
+	}
+
+	if !app.verifyAdmin(w, r) {
+		return
+	}
+
+	conn, err := app.rabbitConnection()
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	letters, err := event.InspectDeadLetters(conn, queue, limit)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dead letters retrieved",
+		Data:       letters,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+
+	if !app.verifyAdmin(w, r) {
+		return
+	}
+
+	//extract the request body
+	var requestPayload ReplayDeadLettersPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if requestPayload.Queue == "" {
+		app.errorJSON(w, errors.New("queue not supplied"), nil)
+		return
+	}
+
+	if requestPayload.Limit <= 0 || requestPayload.Limit > maxDeadLetterBatch {
+		requestPayload.Limit = maxDeadLetterBatch
+	}
+
+	conn, err := app.rabbitConnection()
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	replayed, err := event.ReplayDeadLetters(r.Context(), conn, requestPayload.Queue, requestPayload.MessageID, requestPayload.Limit)
+	if err != nil {
+		app.errorJSON(w, err, map[string]int{"replayed": replayed})
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dead letters replayed",
+		Data:       map[string]int{"replayed": replayed},
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+
+	if !app.verifyAdmin(w, r) {
+		return
+	}
+
+	//extract the request body
+	var requestPayload PurgeDeadLettersPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if requestPayload.Queue == "" {
+		app.errorJSON(w, errors.New("queue not supplied"), nil)
+		return
+	}
+
+	conn, err := app.rabbitConnection()
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	purged, err := event.PurgeDeadLetters(conn, requestPayload.Queue)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "dead letters purged",
+		Data:       map[string]int{"purged": purged},
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// rabbitConnection returns the supervised rabbitmq connection
+func (app *Config) rabbitConnection() (*amqp.Connection, error) {
+	if app.Rabbit == nil {
+		return nil, event.ErrNotConnected
+	}
+
+	return app.Rabbit.Connection()
+}
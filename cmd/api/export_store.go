@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrExportNotFound is returned for an unknown or expired export
+var ErrExportNotFound = errors.New("export not found")
+
+// ExportStore keeps background exports and the files they produce, both expire after exportTTL
+type ExportStore interface {
+	Save(ctx context.Context, job ExportJob) error
+	Get(ctx context.Context, exportID string) (ExportJob, error)
+	// Pending returns the exports that are queued or running, oldest first
+	Pending(ctx context.Context) ([]ExportJob, error)
+	SaveFile(ctx context.Context, exportID string, file []byte) error
+	File(ctx context.Context, exportID string) ([]byte, error)
+}
+
+// redisExportStore keeps an export as json, its file as bytes and the unfinished ones in a sorted set
+type redisExportStore struct {
+	cache *redis.Client
+}
+
+const exportsPendingKey = "exports:pending"
+
+func exportKey(exportID string) string {
+	return fmt.Sprintf("exports:%s", exportID)
+}
+
+func exportFileKey(exportID string) string {
+	return fmt.Sprintf("exports:file:%s", exportID)
+}
+
+func (s redisExportStore) Save(ctx context.Context, job ExportJob) error {
+	rawJob, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.cache.TxPipeline()
+	pipe.Set(ctx, exportKey(job.ID), rawJob, exportTTL)
+	if job.finished() {
+		pipe.ZRem(ctx, exportsPendingKey, job.ID)
+	} else {
+		pipe.ZAdd(ctx, exportsPendingKey, redis.Z{Score: float64(job.CreatedAt), Member: job.ID})
+	}
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+func (s redisExportStore) Get(ctx context.Context, exportID string) (ExportJob, error) {
+	rawJob, err := s.cache.Get(ctx, exportKey(exportID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return ExportJob{}, ErrExportNotFound
+	}
+	if err != nil {
+		return ExportJob{}, err
+	}
+
+	var job ExportJob
+	err = json.Unmarshal(rawJob, &job)
+
+	return job, err
+}
+
+func (s redisExportStore) Pending(ctx context.Context) ([]ExportJob, error) {
+	ids, err := s.cache.ZRange(ctx, exportsPendingKey, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = exportKey(id)
+	}
+
+	rawJobs, err := s.cache.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]ExportJob, 0, len(rawJobs))
+	for i, rawJob := range rawJobs {
+		value, ok := rawJob.(string)
+		if !ok {
+			// the export expired before it ran
+			s.cache.ZRem(ctx, exportsPendingKey, ids[i])
+			continue
+		}
+
+		var job ExportJob
+		if err := json.Unmarshal([]byte(value), &job); err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+func (s redisExportStore) SaveFile(ctx context.Context, exportID string, file []byte) error {
+	return s.cache.Set(ctx, exportFileKey(exportID), file, exportTTL).Err()
+}
+
+func (s redisExportStore) File(ctx context.Context, exportID string) ([]byte, error) {
+	file, err := s.cache.Get(ctx, exportFileKey(exportID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrExportNotFound
+	}
+
+	return file, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// memoryExportStore is a stand-in store used in tests, nothing in it expires
+type memoryExportStore struct {
+	mu    sync.Mutex
+	jobs  map[string]ExportJob
+	files map[string][]byte
+}
+
+func newMemoryExportStore() *memoryExportStore {
+	return &memoryExportStore{jobs: make(map[string]ExportJob), files: make(map[string][]byte)}
+}
+
+func (s *memoryExportStore) Save(ctx context.Context, job ExportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *memoryExportStore) Get(ctx context.Context, exportID string) (ExportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[exportID]
+	if !ok {
+		return ExportJob{}, ErrExportNotFound
+	}
+
+	return job, nil
+}
+
+func (s *memoryExportStore) Pending(ctx context.Context) ([]ExportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []ExportJob{}
+	for _, job := range s.jobs {
+		if !job.finished() {
+			jobs = append(jobs, job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt < jobs[j].CreatedAt
+	})
+	return jobs, nil
+}
+
+func (s *memoryExportStore) SaveFile(ctx context.Context, exportID string, file []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[exportID] = file
+	return nil
+}
+
+func (s *memoryExportStore) File(ctx context.Context, exportID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.files[exportID]
+	if !ok {
+		return nil, ErrExportNotFound
+	}
+
+	return file, nil
+}
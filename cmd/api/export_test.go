@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testExportColumns = []exportColumn{
+	{Header: "ID", Field: "id"},
+	{Header: "Note", Field: "note"},
+	{Header: "Amount", Field: "amount", Numeric: true},
+}
+
+func TestCSVExportWriter(t *testing.T) {
+	var file bytes.Buffer
+	out := newExportWriter(exportFormatCSV, &file, testExportColumns)
+
+	assert.NoError(t, out.WriteRow(exportHeader(testExportColumns)))
+	assert.NoError(t, out.WriteRow([]string{"row-1", "=HYPERLINK(\"x\")", "-250.5"}))
+	assert.NoError(t, out.WriteRow([]string{"row-2", "plain, with comma", "100"}))
+	assert.NoError(t, out.Close())
+
+	records, err := csv.NewReader(&file).ReadAll()
+	assert.NoError(t, err)
+
+	t.Log("Checking text that looks like a formula is neutralized and numbers are left alone")
+	assert.Equal(t, []string{"ID", "Note", "Amount"}, records[0])
+	assert.Equal(t, []string{"row-1", "'=HYPERLINK(\"x\")", "-250.5"}, records[1])
+	assert.Equal(t, []string{"row-2", "plain, with comma", "100"}, records[2])
+}
+
+func TestXLSXExportWriter(t *testing.T) {
+	var file bytes.Buffer
+	out := newExportWriter(exportFormatXLSX, &file, testExportColumns)
+
+	assert.NoError(t, out.WriteRow(exportHeader(testExportColumns)))
+	assert.NoError(t, out.WriteRow([]string{"row-1", "<b>&", "12.5"}))
+	assert.NoError(t, out.Close())
+
+	t.Log("Checking the workbook is a zip with every part a spreadsheet needs")
+	archive, err := zip.NewReader(bytes.NewReader(file.Bytes()), int64(file.Len()))
+	assert.NoError(t, err)
+
+	parts := map[string]string{}
+	for _, part := range archive.File {
+		reader, err := part.Open()
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		parts[part.Name] = string(content)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		assert.Contains(t, parts, name)
+	}
+
+	t.Log("Checking the sheet is well formed xml with text escaped and numbers as numbers")
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	assert.NoError(t, xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &sheet))
+	assert.Len(t, sheet.Rows, 2)
+	assert.Equal(t, "Amount", sheet.Rows[0].Cells[2].Inline)
+	assert.Equal(t, "<b>&", sheet.Rows[1].Cells[1].Inline)
+	assert.Equal(t, "C2", sheet.Rows[1].Cells[2].Ref)
+	assert.Equal(t, "12.5", sheet.Rows[1].Cells[2].Value)
+	assert.Empty(t, sheet.Rows[1].Cells[2].Type)
+}
+
+func TestXLSXColumnName(t *testing.T) {
+	t.Log("Checking columns are lettered like a spreadsheet")
+	assert.Equal(t, "A", xlsxColumnName(0))
+	assert.Equal(t, "Z", xlsxColumnName(25))
+	assert.Equal(t, "AA", xlsxColumnName(26))
+	assert.Equal(t, "AZ", xlsxColumnName(51))
+	assert.Equal(t, "BA", xlsxColumnName(52))
+}
+
+func TestExportRows(t *testing.T) {
+	t.Log("Checking rows are found whether the page is a list or an object holding one")
+	list := []interface{}{map[string]interface{}{"id": "a"}}
+	assert.Len(t, exportPageRows(list), 1)
+	assert.Len(t, exportPageRows(map[string]interface{}{"total": 1.0, "bookings": list}), 1)
+	assert.Empty(t, exportPageRows(nil))
+
+	t.Log("Checking values are written the way a spreadsheet reads them")
+	row := map[string]interface{}{"id": "a", "amount": 1500.0, "note": map[string]interface{}{"x": true}}
+	assert.Equal(t, []string{"a", `{"x":true}`, "1500"}, exportCells(row, testExportColumns))
+
+	t.Log("Checking the date range keeps both ends and drops rows it can not date")
+	from, to := ExportPayload{From: "2026-01-01", To: "2026-01-31"}.window()
+	assert.True(t, exportRowWithin(map[string]interface{}{"created_at": "2026-01-01T00:00:00Z"}, from, to))
+	assert.True(t, exportRowWithin(map[string]interface{}{"created_at": "2026-01-31 23:59:59"}, from, to))
+	assert.False(t, exportRowWithin(map[string]interface{}{"created_at": "2026-02-01T00:00:00Z"}, from, to))
+	assert.False(t, exportRowWithin(map[string]interface{}{}, from, to))
+	assert.True(t, exportRowWithin(map[string]interface{}{}, time.Time{}, time.Time{}))
+}
+
+func TestValidateExportInput(t *testing.T) {
+	app := Config{}
+
+	t.Log("Checking kinds, formats and dates are validated")
+	assert.Empty(t, app.ValidateExportInput(ExportPayload{Kind: ExportBookings, Format: exportFormatXLSX, From: "2026-01-01", To: "2026-03-31"}))
+	errs := app.ValidateExportInput(ExportPayload{Kind: "payouts", Format: "pdf", From: "01/01/2026"})
+	assert.Contains(t, errs, "kind")
+	assert.Contains(t, errs, "format")
+	assert.Contains(t, errs, "from")
+	assert.Contains(t, app.ValidateExportInput(ExportPayload{Kind: ExportOrders, Format: exportFormatCSV, From: "2026-02-01", To: "2026-01-01"}), "to")
+
+	t.Log("Checking only short ranges are exported while the caller waits")
+	assert.True(t, ExportPayload{From: "2026-01-01", To: "2026-03-31"}.direct())
+	assert.False(t, ExportPayload{From: "2025-01-01", To: "2026-01-01"}.direct())
+	assert.False(t, ExportPayload{From: "2026-01-01"}.direct())
+}
+
+func TestExportStorePending(t *testing.T) {
+	for name, store := range map[string]ExportStore{
+		"memory": newMemoryExportStore(),
+		"redis":  redisExportStore{cache: newTestRedis(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			assert.NoError(t, store.Save(ctx, ExportJob{ID: "exp-2", Status: ExportRunning, CreatedAt: 2}))
+			assert.NoError(t, store.Save(ctx, ExportJob{ID: "exp-1", Status: ExportQueued, CreatedAt: 1}))
+			assert.NoError(t, store.Save(ctx, ExportJob{ID: "exp-3", Status: ExportCompleted, CreatedAt: 3}))
+
+			t.Log("Checking unfinished exports are picked up oldest first")
+			pending, err := store.Pending(ctx)
+			assert.NoError(t, err)
+			assert.Len(t, pending, 2)
+			assert.Equal(t, "exp-1", pending[0].ID)
+
+			_, err = store.File(ctx, "exp-1")
+			assert.ErrorIs(t, err, ErrExportNotFound)
+		})
+	}
+}
+
+func TestRunExport(t *testing.T) {
+	var pages []int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var page ExportPagePayload
+		json.NewDecoder(r.Body).Decode(&page)
+		pages = append(pages, page.Page)
+
+		// a full first page and a short second one
+		rows := []map[string]interface{}{}
+		count := exportPageSize
+		if page.Page == 2 {
+			count = 3
+		}
+		for i := 0; i < count; i++ {
+			rows = append(rows, map[string]interface{}{"id": fmt.Sprintf("booking-%d-%d", page.Page, i), "quantity": 1})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(jsonResponse{Data: rows})
+	}))
+	defer service.Close()
+	t.Setenv("INVENTORY_SERVICE_URL", service.URL+"/")
+
+	ctx := context.Background()
+	store := newMemoryExportStore()
+	app := Config{exports: store}
+	assert.NoError(t, store.Save(ctx, ExportJob{ID: "exp-1", UserID: "user-1", Kind: ExportBookings, Format: exportFormatCSV, Status: ExportQueued}))
+
+	t.Log("Checking a background export reads every page, the first one included")
+	assert.NoError(t, app.runExport(ctx, "exp-1"))
+	assert.Equal(t, []int32{1, 2}, pages)
+
+	job, err := store.Get(ctx, "exp-1")
+	assert.NoError(t, err)
+	assert.Equal(t, ExportCompleted, job.Status)
+	assert.Equal(t, exportPageSize+3, job.Rows)
+
+	file, err := store.File(ctx, "exp-1")
+	assert.NoError(t, err)
+	records, err := csv.NewReader(bytes.NewReader(file)).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, exportPageSize+4)
+	assert.Equal(t, "booking-1-0", records[1][0])
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	exportFormatCSV  = "csv"
+	exportFormatXLSX = "xlsx"
+)
+
+var exportFormats = []string{exportFormatCSV, exportFormatXLSX}
+
+// exportColumn is one column of an export, Field is the key of the value in an upstream row
+type exportColumn struct {
+	Header  string
+	Field   string
+	Numeric bool
+}
+
+// exportWriter writes the rows of an export as they are fetched, Close finishes the file
+type exportWriter interface {
+	WriteRow(cells []string) error
+	Close() error
+}
+
+func newExportWriter(format string, out io.Writer, columns []exportColumn) exportWriter {
+	if format == exportFormatXLSX {
+		return newXLSXWriter(out, columns)
+	}
+	return &csvExportWriter{csv: csv.NewWriter(out), columns: columns}
+}
+
+func exportContentType(format string) string {
+	if format == exportFormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+type csvExportWriter struct {
+	csv     *csv.Writer
+	columns []exportColumn
+	rows    int
+}
+
+func (c *csvExportWriter) WriteRow(cells []string) error {
+	safe := make([]string, len(cells))
+	for i, cell := range cells {
+		safe[i] = cell
+		// the header row and numbers go out as they are
+		if c.rows > 0 && i < len(c.columns) && !c.columns[i].Numeric {
+			safe[i] = neutralizeFormula(cell)
+		}
+	}
+	c.rows++
+
+	if err := c.csv.Write(safe); err != nil {
+		return err
+	}
+
+	// push every page to the client instead of holding the whole file
+	if c.rows%exportPageSize == 0 {
+		c.csv.Flush()
+	}
+	return c.csv.Error()
+}
+
+func (c *csvExportWriter) Close() error {
+	c.csv.Flush()
+	return c.csv.Error()
+}
+
+// neutralizeFormula keeps a spreadsheet from running a value that starts like a formula
+func neutralizeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// xlsxWriter writes a workbook of one sheet, the sheet is streamed into the zip so rows are never held in memory
+type xlsxWriter struct {
+	zip     *zip.Writer
+	sheet   *bufio.Writer
+	columns []exportColumn
+	rows    int
+	err     error
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+
+func newXLSXWriter(out io.Writer, columns []exportColumn) *xlsxWriter {
+	x := &xlsxWriter{zip: zip.NewWriter(out), columns: columns}
+
+	for _, part := range [][2]string{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		if x.err = x.writePart(part[0], part[1]); x.err != nil {
+			return x
+		}
+	}
+
+	// the sheet is the last part, so it stays open while rows come in
+	var sheet io.Writer
+	sheet, x.err = x.zip.Create("xl/worksheets/sheet1.xml")
+	if x.err != nil {
+		return x
+	}
+	x.sheet = bufio.NewWriter(sheet)
+	_, x.err = x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	return x
+}
+
+func (x *xlsxWriter) writePart(name, content string) error {
+	part, err := x.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, content)
+	return err
+}
+
+func (x *xlsxWriter) WriteRow(cells []string) error {
+	if x.err != nil {
+		return x.err
+	}
+
+	x.rows++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.rows)
+	for i, cell := range cells {
+		ref := fmt.Sprintf("%s%d", xlsxColumnName(i), x.rows)
+
+		// the header row is always text
+		if x.rows > 1 && i < len(x.columns) && x.columns[i].Numeric {
+			if _, err := strconv.ParseFloat(cell, 64); err == nil {
+				fmt.Fprintf(x.sheet, `<c r="%s"><v>%s</v></c>`, ref, cell)
+				continue
+			}
+		}
+
+		fmt.Fprintf(x.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+		xml.EscapeText(x.sheet, []byte(cell))
+		x.sheet.WriteString(`</t></is></c>`)
+	}
+	_, x.err = x.sheet.WriteString(`</row>`)
+
+	return x.err
+}
+
+func (x *xlsxWriter) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+
+	if _, err := x.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+
+	return x.zip.Close()
+}
+
+// xlsxColumnName turns a zero based column index into its letters, 0 is A and 26 is AA
+func xlsxColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
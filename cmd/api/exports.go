@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// what can be exported, each is a paged list the caller already sees in the app
+const (
+	ExportBookings      = "bookings"      // the booking requests received, as GetBookingRequest lists them
+	ExportOrders        = "orders"        // the purchase requests received, as GetPurchaseRequest lists them
+	ExportSubscriptions = "subscriptions" // the caller's subscription history, as MySubscriptionHistory lists it
+)
+
+const (
+	// exportPageSize is how many rows are asked of the upstream service at a time
+	exportPageSize = 100
+	// maxExportRows bounds a single export, a wider one has to be split by date
+	maxExportRows = 50000
+	// maxDirectExportDays is the widest date range exported straight into the response,
+	// anything wider, or without a range, runs in the background
+	maxDirectExportDays = 92
+	// exportTTL is how long a background export and its file are kept
+	exportTTL = 24 * time.Hour
+	// exportTimeout bounds one background export, after it another replica may pick it up again
+	exportTimeout    = 10 * time.Minute
+	exportDateLayout = "2006-01-02"
+)
+
+// ExportStatus is where a background export is
+type ExportStatus string
+
+const (
+	ExportQueued    ExportStatus = "queued"
+	ExportRunning   ExportStatus = "running"
+	ExportCompleted ExportStatus = "completed"
+	ExportFailed    ExportStatus = "failed"
+)
+
+// exportSource is the inventory service list an export reads and the columns it writes
+type exportSource struct {
+	Path    string
+	Columns []exportColumn
+}
+
+var exportSources = map[string]exportSource{
+	ExportBookings: {
+		Path: "booking-requests",
+		Columns: []exportColumn{
+			{Header: "Booking ID", Field: "id"},
+			{Header: "Inventory ID", Field: "inventory_id"},
+			{Header: "Renter ID", Field: "renter_id"},
+			{Header: "Status", Field: "status"},
+			{Header: "Start date", Field: "start_date"},
+			{Header: "Start time", Field: "start_time"},
+			{Header: "End date", Field: "end_date"},
+			{Header: "End time", Field: "end_time"},
+			{Header: "Rental type", Field: "rental_type"},
+			{Header: "Quantity", Field: "quantity", Numeric: true},
+			{Header: "Price per unit", Field: "offer_price_per_unit", Numeric: true},
+			{Header: "Security deposit", Field: "security_deposit", Numeric: true},
+			{Header: "Total amount", Field: "total_amount", Numeric: true},
+			{Header: "Created at", Field: "created_at"},
+		},
+	},
+	ExportOrders: {
+		Path: "purchase-requests",
+		Columns: []exportColumn{
+			{Header: "Order ID", Field: "id"},
+			{Header: "Inventory ID", Field: "inventory_id"},
+			{Header: "Buyer ID", Field: "buyer_id"},
+			{Header: "Status", Field: "status"},
+			{Header: "Quantity", Field: "quantity", Numeric: true},
+			{Header: "Price per unit", Field: "offer_price_per_unit", Numeric: true},
+			{Header: "Total amount", Field: "total_amount", Numeric: true},
+			{Header: "Created at", Field: "created_at"},
+		},
+	},
+	ExportSubscriptions: {
+		Path: "my-subscription-history",
+		Columns: []exportColumn{
+			{Header: "Payment ID", Field: "id"},
+			{Header: "Plan", Field: "plan_name"},
+			{Header: "Billing cycle", Field: "billing_cycle"},
+			{Header: "Amount", Field: "amount", Numeric: true},
+			{Header: "Status", Field: "status"},
+			{Header: "Reference", Field: "reference"},
+			{Header: "Created at", Field: "created_at"},
+		},
+	},
+}
+
+var exportKinds = []string{ExportBookings, ExportOrders, ExportSubscriptions}
+
+type ExportPayload struct {
+	Kind   string `json:"kind"`
+	Format string `json:"format"`
+	From   string `json:"from"` // yyyy-mm-dd, inclusive
+	To     string `json:"to"`   // yyyy-mm-dd, inclusive
+}
+
+// window returns the range of created_at an export keeps, zero times leave that side open
+func (p ExportPayload) window() (from, to time.Time) {
+	if p.From != "" {
+		from, _ = time.Parse(exportDateLayout, p.From)
+	}
+	if p.To != "" {
+		to, _ = time.Parse(exportDateLayout, p.To)
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to
+}
+
+// direct reports whether the export is small enough to stream straight into the response
+func (p ExportPayload) direct() bool {
+	from, to := p.window()
+	return !from.IsZero() && !to.IsZero() && to.Sub(from) <= maxDirectExportDays*24*time.Hour
+}
+
+func (p ExportPayload) filename() string {
+	name := p.Kind
+	if p.From != "" {
+		name += "-from-" + p.From
+	}
+	if p.To != "" {
+		name += "-to-" + p.To
+	}
+	return fmt.Sprintf("%s.%s", name, p.Format)
+}
+
+// ExportPagePayload asks the upstream service for one page of the caller's list
+type ExportPagePayload struct {
+	UserId string `json:"user_id"`
+	Page   int32  `json:"page"`
+	Limit  int32  `json:"limit"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// exportPager walks every page of an upstream list, keeping the rows created within the range
+type exportPager struct {
+	app     *Config
+	source  exportSource
+	request ExportPayload
+	userID  string
+	page    int32
+	done    bool
+}
+
+func (app *Config) newExportPager(request ExportPayload, userID string) *exportPager {
+	return &exportPager{app: app, source: exportSources[request.Kind], request: request, userID: userID}
+}
+
+// next returns the cells of the next rows, nil once every page was read
+func (p *exportPager) next() ([][]string, error) {
+	from, to := p.request.window()
+
+	for !p.done {
+		p.page++
+		jsonFromService, err := p.app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), p.source.Path), ExportPagePayload{
+			UserId: p.userID,
+			Page:   p.page,
+			Limit:  exportPageSize,
+			From:   p.request.From,
+			To:     p.request.To,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rows := exportPageRows(jsonFromService.Data)
+		if len(rows) < exportPageSize {
+			p.done = true
+		} else if int(p.page)*exportPageSize >= maxExportRows {
+			return nil, fmt.Errorf("the export has more than %d rows, please narrow the date range", maxExportRows)
+		}
+
+		var cells [][]string
+		for _, row := range rows {
+			if exportRowWithin(row, from, to) {
+				cells = append(cells, exportCells(row, p.source.Columns))
+			}
+		}
+		if len(cells) > 0 {
+			return cells, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// exportPageRows finds the rows of a page, the services return either the list itself or an object holding it
+func exportPageRows(data any) []map[string]interface{} {
+	var list []interface{}
+	switch value := data.(type) {
+	case []interface{}:
+		list = value
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if field, ok := value[key].([]interface{}); ok {
+				list = field
+				break
+			}
+		}
+	}
+
+	rows := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if row, ok := item.(map[string]interface{}); ok {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+// exportRowWithin reports whether row was created within from and to, a row without a date only
+// passes when there is no range
+func exportRowWithin(row map[string]interface{}, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+
+	createdAt, ok := row["created_at"].(string)
+	if !ok {
+		return false
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", exportDateLayout} {
+		at, err := time.Parse(layout, createdAt)
+		if err != nil {
+			continue
+		}
+		return (from.IsZero() || !at.Before(from)) && (to.IsZero() || at.Before(to))
+	}
+
+	return false
+}
+
+// exportCells picks the columns out of row
+func exportCells(row map[string]interface{}, columns []exportColumn) []string {
+	cells := make([]string, len(columns))
+	for i, column := range columns {
+		switch value := row[column.Field].(type) {
+		case nil:
+		case string:
+			cells[i] = value
+		case float64:
+			cells[i] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			cells[i] = strconv.FormatBool(value)
+		default:
+			raw, _ := json.Marshal(value)
+			cells[i] = string(raw)
+		}
+	}
+
+	return cells
+}
+
+func exportHeader(columns []exportColumn) []string {
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Header
+	}
+	return header
+}
+
+// writeExport writes the header, then first, the rows already read if any, and every page after it, and closes out
+func writeExport(pager *exportPager, first [][]string, out exportWriter) (int, error) {
+	if err := out.WriteRow(exportHeader(pager.source.Columns)); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	rows := first
+	for {
+		for _, row := range rows {
+			if err := out.WriteRow(row); err != nil {
+				return written, err
+			}
+			written++
+		}
+
+		var err error
+		rows, err = pager.next()
+		if err != nil {
+			return written, err
+		}
+		if rows == nil {
+			break
+		}
+	}
+
+	return written, out.Close()
+}
+
+// ExportJob is an export running in the background, its file is downloaded once it completes
+type ExportJob struct {
+	ID          string       `json:"id"`
+	UserID      string       `json:"user_id"`
+	Kind        string       `json:"kind"`
+	Format      string       `json:"format"`
+	From        string       `json:"from,omitempty"`
+	To          string       `json:"to,omitempty"`
+	Status      ExportStatus `json:"status"`
+	Rows        int          `json:"rows"`
+	Error       string       `json:"error,omitempty"`
+	DownloadURL string       `json:"download_url,omitempty"`
+	CreatedAt   int64        `json:"created_at"`
+	StartedAt   int64        `json:"started_at,omitempty"`
+	FinishedAt  int64        `json:"finished_at,omitempty"`
+}
+
+func (j ExportJob) finished() bool {
+	return j.Status == ExportCompleted || j.Status == ExportFailed
+}
+
+func (j ExportJob) request() ExportPayload {
+	return ExportPayload{Kind: j.Kind, Format: j.Format, From: j.From, To: j.To}
+}
+
+// runExport runs a background export once across replicas and notifies its owner when it is done
+func (app *Config) runExport(ctx context.Context, exportID string) error {
+	if app.scheduler != nil && !app.scheduler.Once(ctx, "exports:claim:"+exportID, exportTimeout) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	job, err := app.exports.Get(ctx, exportID)
+	if err != nil || job.finished() {
+		return err
+	}
+
+	job.Status = ExportRunning
+	job.StartedAt = time.Now().UnixMilli()
+	if err := app.exports.Save(ctx, job); err != nil {
+		return err
+	}
+
+	var file bytes.Buffer
+	pager := app.newExportPager(job.request(), job.UserID)
+	job.Rows, err = writeExport(pager, nil, newExportWriter(job.Format, &file, pager.source.Columns))
+	if err == nil {
+		err = app.exports.SaveFile(ctx, job.ID, file.Bytes())
+	}
+
+	job.FinishedAt = time.Now().UnixMilli()
+	if err != nil {
+		log.Printf("[EXPORTS] export %s failed: %v", job.ID, err)
+		job.Status = ExportFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = ExportCompleted
+		job.DownloadURL = fmt.Sprintf("/api/v1/exports/download?export_id=%s", job.ID)
+	}
+
+	if err := app.exports.Save(ctx, job); err != nil {
+		return err
+	}
+
+	app.notifyExport(ctx, job)
+	return nil
+}
+
+// runPendingExports picks up background exports nobody ran, as when the replica that queued one went away
+func (app *Config) runPendingExports(ctx context.Context) error {
+	jobs, err := app.exports.Pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, job := range jobs {
+		if err := app.runExport(ctx, job.ID); err != nil {
+			log.Printf("[EXPORTS] running export %s failed: %v", job.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pending exports could not be run", failed, len(jobs))
+	}
+
+	return nil
+}
+
+func (app *Config) notifyExport(ctx context.Context, job ExportJob) {
+	if app.notifications == nil {
+		return
+	}
+
+	title, body := "Your export is ready", fmt.Sprintf("Your %s export of %d rows is ready to download", job.Kind, job.Rows)
+	if job.Status == ExportFailed {
+		title, body = "Your export failed", fmt.Sprintf("Your %s export failed: %s", job.Kind, job.Error)
+	}
+
+	err := app.notifications.Notify(ctx, Notification{
+		ID:        "export:" + job.ID,
+		UserID:    job.UserID,
+		Type:      NotificationExport,
+		Title:     title,
+		Body:      body,
+		Data:      map[string]interface{}{"export_id": job.ID, "download_url": job.DownloadURL},
+		CreatedAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Printf("[EXPORTS] notifying %s about export %s failed: %v", job.UserID, job.ID, err)
+	}
+}
+
+// ExportData streams the caller's bookings, orders or subscription history as csv or xlsx,
+// the date range has to be short enough to export while the caller waits
+func (app *Config) ExportData(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query params
+	queryParams := r.URL.Query()
+	requestPayload := ExportPayload{
+		Kind:   queryParams.Get("kind"),
+		Format: queryParams.Get("format"),
+		From:   queryParams.Get("from"),
+		To:     queryParams.Get("to"),
+	}
+	if requestPayload.Format == "" {
+		requestPayload.Format = exportFormatCSV
+	}
+
+	validateErrors := app.ValidateExportInput(requestPayload)
+	if len(validateErrors) > 0 {
+		app.errorJSON(w, errors.New("error trying to export data"), validateErrors, http.StatusBadRequest)
+		return
+	}
+
+	if !requestPayload.direct() {
+		app.errorJSON(w, fmt.Errorf("exports without a date range or longer than %d days run in the background, start one with POST /api/v1/exports", maxDirectExportDays), nil)
+		return
+	}
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// the first page is read before anything is written, so a failing service still gets a json error
+	pager := app.newExportPager(requestPayload, userId)
+	first, err := pager.next()
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentType(requestPayload.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", requestPayload.filename()))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = writeExport(pager, first, newExportWriter(requestPayload.Format, w, pager.source.Columns))
+	if err != nil {
+		// the file is half written, drop the connection rather than hand out a truncated file
+		log.Printf("[EXPORTS] streaming a %s export for %s failed: %v", requestPayload.Kind, userId, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// StartExport queues a background export of the caller's bookings, orders or subscription history
+func (app *Config) StartExport(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	var requestPayload ExportPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+	if requestPayload.Format == "" {
+		requestPayload.Format = exportFormatCSV
+	}
+
+	validateErrors := app.ValidateExportInput(requestPayload)
+	if len(validateErrors) > 0 {
+		app.errorJSON(w, errors.New("error trying to start export"), validateErrors, http.StatusBadRequest)
+		return
+	}
+
+	if app.exports == nil {
+		app.errorJSON(w, errors.New("exports are not available"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	job := ExportJob{
+		ID:        fmt.Sprintf("exp_%s", uuid.NewString()),
+		UserID:    userId,
+		Kind:      requestPayload.Kind,
+		Format:    requestPayload.Format,
+		From:      requestPayload.From,
+		To:        requestPayload.To,
+		Status:    ExportQueued,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+
+	err = app.exports.Save(r.Context(), job)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// start right away, run-pending-exports picks it up if this replica goes away first
+	go func(exportID string) {
+		if err := app.runExport(context.Background(), exportID); err != nil {
+			log.Printf("[EXPORTS] running export %s failed: %v", exportID, err)
+		}
+	}(job.ID)
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusAccepted,
+		Message:    "export started, you will be notified when it is ready",
+		Data:       job,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// loadExport loads the caller's own background export
+func (app *Config) loadExport(w http.ResponseWriter, r *http.Request) (ExportJob, bool) {
+	exportID := r.URL.Query().Get("export_id")
+	if exportID == "" {
+		app.errorJSON(w, errors.New("export_id not supplied"), nil)
+		return ExportJob{}, false
+	}
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return ExportJob{}, false
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return ExportJob{}, false
+	}
+
+	if app.exports == nil {
+		app.errorJSON(w, errors.New("exports are not available"), nil, http.StatusServiceUnavailable)
+		return ExportJob{}, false
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return ExportJob{}, false
+	}
+
+	job, err := app.exports.Get(r.Context(), exportID)
+	// someone else's export is reported as missing so ids can not be probed
+	if errors.Is(err, ErrExportNotFound) || (err == nil && job.UserID != userId) {
+		app.errorJSON(w, ErrExportNotFound, nil, http.StatusNotFound)
+		return ExportJob{}, false
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return ExportJob{}, false
+	}
+
+	return job, true
+}
+
+// GetExport reports where a background export is, with its download link once it completed
+func (app *Config) GetExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.loadExport(w, r)
+	if !ok {
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("export is %s", job.Status),
+		Data:       job,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DownloadExport returns the file of a completed background export
+func (app *Config) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.loadExport(w, r)
+	if !ok {
+		return
+	}
+
+	if job.Status != ExportCompleted {
+		app.errorJSON(w, fmt.Errorf("the export is %s", job.Status), nil, http.StatusConflict)
+		return
+	}
+
+	file, err := app.exports.File(r.Context(), job.ID)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentType(job.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.request().filename()))
+	w.Header().Set("Content-Length", strconv.Itoa(len(file)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(file)
+}
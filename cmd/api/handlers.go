@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -18,6 +19,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-redis/redis"
+	"github.com/obynonwane/broker-service/event"
 	"github.com/obynonwane/broker-service/utility"
 	"github.com/obynonwane/rental-service-proto/inventory"
 	"google.golang.org/grpc"
@@ -34,6 +36,15 @@ type MailPayload struct {
 	Subject string                 `json:"subject"`
 	Message string                 `json:"message"`
 	Data    map[string]interface{} `json:"data"`
+
+	Attachments []MailAttachment `json:"attachments,omitempty"`
+}
+
+// MailAttachment is a file sent along with a mail, Content is base64 encoded
+type MailAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content"`
 }
 
 type SignupPayload struct {
@@ -95,6 +106,7 @@ type ReplyRatingPayload struct {
 	ReplierID     string `json:"replier_id,omitempty"`
 	Comment       string `json:"comment"`
 	ParentReplyID string `json:"parent_reply_id"`
+	SubjectID     string `json:"subject_id,omitempty"` // the rated inventory or user, used to find who to notify
 }
 
 type SearchPayload struct {
@@ -2930,6 +2942,10 @@ func (app *Config) ReplyInventoryRating(w http.ResponseWriter, r *http.Request)
 	// 7. select statement to wait
 	select {
 	case data := <-resultCh:
+		if data != nil {
+			app.recordRatingReply(c, "inventory", requestPayload.SubjectID, data, correlationID(r))
+		}
+
 		var payload jsonResponse
 		payload.Error = false
 		payload.Message = "Inventory rating replied sucessfully"
@@ -3018,6 +3034,10 @@ func (app *Config) ReplyUserRating(w http.ResponseWriter, r *http.Request) {
 	// 7. select statement to wait
 	select {
 	case data := <-resultCh:
+		if data != nil {
+			app.recordRatingReply(c, "user", cmp.Or(requestPayload.SubjectID, replierID), data, correlationID(r))
+		}
+
 		var payload jsonResponse
 		payload.Error = false
 		payload.Message = "User rating replied sucessfully"
@@ -3036,6 +3056,78 @@ func (app *Config) ReplyUserRating(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// maxRatingLookupPages bounds how far the ratings of a subject are paged through to find a rating
+const maxRatingLookupPages = 20
+
+// recordRatingReply raises rating.replied so the rater hears about a reply, kind is "inventory" or "user"
+// and subjectID what was rated. The rater is read from upstream rather than taken from the request.
+func (app *Config) recordRatingReply(c inventory.InventoryServiceClient, kind, subjectID string, reply *inventory.ReplyToRatingResponse, corrID string) {
+	if subjectID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	raterID, err := ratingRater(ctx, c, kind, subjectID, reply.RatingId)
+	if err != nil {
+		log.Printf("[RATINGS] finding the rater of %s failed: %v", reply.RatingId, err)
+		return
+	}
+
+	app.recordEventOrPublish(ctx, event.RatingRepliedEvent{
+		RatingID:  reply.RatingId,
+		ReplyID:   reply.Id,
+		ReplierID: reply.ReplierId,
+		RaterID:   raterID,
+		Kind:      kind,
+		Comment:   reply.Comment,
+	}, corrID)
+}
+
+// ratingRater pages through the ratings of subjectID until it finds ratingID
+func ratingRater(ctx context.Context, c inventory.InventoryServiceClient, kind, subjectID, ratingID string) (string, error) {
+	const limit = 50
+
+	for page := int32(1); page <= maxRatingLookupPages; page++ {
+		request := &inventory.GetResourceWithIDAndPagination{
+			Id:         &inventory.ResourceId{Id: subjectID},
+			Pagination: &inventory.PaginationParam{Page: page, Limit: limit},
+		}
+
+		var count int
+		if kind == "inventory" {
+			ratings, err := c.GetInventoryRatings(ctx, request)
+			if err != nil {
+				return "", err
+			}
+			count = len(ratings.InventoryRatings)
+			for _, rating := range ratings.InventoryRatings {
+				if rating.Id == ratingID {
+					return rating.RaterId, nil
+				}
+			}
+		} else {
+			ratings, err := c.GetUserRatings(ctx, request)
+			if err != nil {
+				return "", err
+			}
+			count = len(ratings.UserRatings)
+			for _, rating := range ratings.UserRatings {
+				if rating.Id == ratingID {
+					return rating.RaterId, nil
+				}
+			}
+		}
+
+		if count < limit {
+			break
+		}
+	}
+
+	return "", fmt.Errorf("rating %s was not found on %s", ratingID, subjectID)
+}
+
 func (app *Config) SearchInventory(w http.ResponseWriter, r *http.Request) {
 
 	//1. variable of type ReplyRatingPayload
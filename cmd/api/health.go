@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/obynonwane/broker-service/event"
+)
+
+// HealthStatus reports the state of the broker's dependencies
+type HealthStatus struct {
+	RabbitMQ           string `json:"rabbitmq"`
+	RabbitMQReconnects int64  `json:"rabbitmq_reconnects"`
+	Redis              string `json:"redis"`
+}
+
+// HealthCheck reports whether rabbitmq and redis are reachable, it answers 503 when rabbitmq is down
+func (app *Config) HealthCheck(w http.ResponseWriter, r *http.Request) {
+	status := HealthStatus{
+		RabbitMQ: event.Disconnected.String(),
+		Redis:    "unavailable",
+	}
+
+	healthy := false
+	if app.Rabbit != nil {
+		status.RabbitMQ = app.Rabbit.State().String()
+		status.RabbitMQReconnects = app.Rabbit.Reconnects()
+		healthy = app.Rabbit.State() == event.Connected
+	}
+
+	if app.cache != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		if err := app.cache.Ping(ctx).Err(); err == nil {
+			status.Redis = "ok"
+		}
+	}
+
+	code := http.StatusOK
+	message := "broker is healthy"
+	if !healthy {
+		code = http.StatusServiceUnavailable
+		message = "broker is degraded"
+	}
+
+	payload := jsonResponse{
+		Error:      !healthy,
+		StatusCode: code,
+		Message:    message,
+		Data:       status,
+	}
+	app.writeJSON(w, code, payload)
+}
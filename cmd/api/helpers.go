@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"slices"
+	"time"
 )
 
 type jsonResponse struct {
@@ -73,6 +75,9 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, data any, status
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
+	if errors.Is(err, ErrUpstreamDisabled) {
+		statusCode = http.StatusServiceUnavailable
+	}
 
 	var payload jsonResponse
 	payload.Error = true
@@ -190,3 +195,120 @@ func (app *Config) verifyInventoryCreatingEligibility(r *http.Request, userId st
 
 	return payload, nil
 }
+
+// adminRoles are the role names that grant access to other users' resources
+var adminRoles = []string{"admin", "super_admin"}
+
+// isAdminUser reports whether the verified token belongs to an admin, the role is the one on the user
+func (app *Config) isAdminUser(response jsonResponse) bool {
+	dataMap, ok := response.Data.(map[string]any)
+	if !ok {
+		return false
+	}
+
+	profile, ok := dataMap["user"].(map[string]any)
+	if !ok {
+		return false
+	}
+
+	role, _ := profile["role"].(string)
+	return slices.Contains(adminRoles, role)
+}
+
+// sendMail delivers a mail through the mail-service
+func (app *Config) sendMail(mail MailPayload) error {
+	if mail.From == "" {
+		mail.From = os.Getenv("MAIL_FROM")
+	}
+
+	//create some json we will send to the mail service
+	jsonData, _ := json.MarshalIndent(mail, "", "\t")
+
+	request, err := http.NewRequest("POST", os.Getenv("MAIL_URL"), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	// Set the Content-Type header
+	request.Header.Set("Content-Type", "application/json")
+	//create a http client
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return errors.New("error sending mail")
+	}
+
+	return nil
+}
+
+// callService posts payload as json to a downstream service url and decodes its reply.
+// Services answer 202 on success, anything else is returned as an error carrying the service message.
+// Endpoints that are not switched on yet, see upstreamEndpoints, are not called at all.
+func (app *Config) callService(url string, payload any) (jsonResponse, error) {
+	if !upstreamEnabled(upstreamEndpoint(url)) {
+		return jsonResponse{Error: true, Message: ErrUpstreamDisabled.Error(), StatusCode: http.StatusServiceUnavailable}, ErrUpstreamDisabled
+	}
+
+	//create some json we will send to the service
+	jsonData, _ := json.MarshalIndent(payload, "", "\t")
+
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: err.Error(), StatusCode: http.StatusBadRequest}, err
+	}
+
+	// Set the Content-Type header
+	request.Header.Set("Content-Type", "application/json")
+	//create a http client
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return jsonResponse{Error: true, Message: err.Error(), StatusCode: http.StatusBadGateway}, err
+	}
+	defer response.Body.Close()
+
+	//variable to marshal into
+	var jsonFromService jsonResponse
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil {
+		return jsonResponse{Error: true, Message: err.Error(), StatusCode: http.StatusBadGateway}, err
+	}
+
+	jsonFromService.StatusCode = response.StatusCode
+	if response.StatusCode != http.StatusAccepted {
+		return jsonFromService, errors.New(jsonFromService.Message)
+	}
+
+	return jsonFromService, nil
+}
+
+// decodeServiceData converts the loosely typed Data of a service reply into v
+func decodeServiceData(data any, v any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// userEmail returns the email on the user's profile
+func userEmail(user jsonResponse) string {
+	data, ok := user.Data.(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	profile, ok := data["user"].(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	email, _ := profile["email"].(string)
+	return email
+}
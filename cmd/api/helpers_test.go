@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAdminUser(t *testing.T) {
+	app := Config{}
+	token := func(raw string) jsonResponse {
+		var response jsonResponse
+		assert.NoError(t, json.Unmarshal([]byte(raw), &response))
+		return response
+	}
+
+	t.Log("Checking the role is read from the user of a verified token")
+	assert.True(t, app.isAdminUser(token(`{"error":false,"data":{"user":{"id":"user-1","email":"a@b.c","role":"admin"}}}`)))
+	assert.True(t, app.isAdminUser(token(`{"error":false,"data":{"user":{"id":"user-1","role":"super_admin"}}}`)))
+	assert.False(t, app.isAdminUser(token(`{"error":false,"data":{"user":{"id":"user-1","role":"renter"}}}`)))
+
+	t.Log("Checking a token without a role on the user is not an admin")
+	assert.False(t, app.isAdminUser(token(`{"error":false,"data":{"user":{"id":"user-1"},"roles":["admin"]}}`)))
+	assert.False(t, app.isAdminUser(token(`{"error":false,"data":"user-1"}`)))
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	icalDateTimeUTC = "20060102T150405Z"
+	icalDateTime    = "20060102T150405"
+	icalDate        = "20060102"
+	// icalLineLimit is the longest content line allowed before it has to be folded, in octets
+	icalLineLimit = 75
+	// maxICalEvents bounds how many events are read from an imported calendar, occurrences of recurring ones included
+	maxICalEvents = 5000
+	// icalRecurrenceYears is how far ahead of now recurring events are expanded
+	icalRecurrenceYears = 2
+	// maxICalRecurrenceSteps bounds the periods walked when expanding one recurrence rule
+	maxICalRecurrenceSteps = 100000
+)
+
+// ICalEvent is a single VEVENT, or one occurrence of a recurring one
+type ICalEvent struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+	AllDay  bool
+}
+
+// ICalFeedEvent is an event written to a feed
+type ICalFeedEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+}
+
+// renderICalendar writes events as an iCalendar (RFC 5545) document
+func renderICalendar(name string, events []ICalFeedEvent, now time.Time) string {
+	var b strings.Builder
+
+	writeICalLine(&b, "BEGIN:VCALENDAR")
+	writeICalLine(&b, "VERSION:2.0")
+	writeICalLine(&b, "PRODID:-//broker-service//bookings//EN")
+	writeICalLine(&b, "CALSCALE:GREGORIAN")
+	writeICalLine(&b, "METHOD:PUBLISH")
+	writeICalLine(&b, "X-WR-CALNAME:"+escapeICalText(name))
+
+	for _, e := range events {
+		writeICalLine(&b, "BEGIN:VEVENT")
+		writeICalLine(&b, "UID:"+escapeICalText(e.UID))
+		writeICalLine(&b, "DTSTAMP:"+now.UTC().Format(icalDateTimeUTC))
+		writeICalLine(&b, "DTSTART:"+e.Start.UTC().Format(icalDateTimeUTC))
+		writeICalLine(&b, "DTEND:"+e.End.UTC().Format(icalDateTimeUTC))
+		writeICalLine(&b, "SUMMARY:"+escapeICalText(e.Summary))
+		if e.Description != "" {
+			writeICalLine(&b, "DESCRIPTION:"+escapeICalText(e.Description))
+		}
+		writeICalLine(&b, "STATUS:CONFIRMED")
+		writeICalLine(&b, "TRANSP:OPAQUE")
+		writeICalLine(&b, "END:VEVENT")
+	}
+
+	writeICalLine(&b, "END:VCALENDAR")
+
+	return b.String()
+}
+
+// writeICalLine folds line at icalLineLimit octets without splitting a utf-8 character
+func writeICalLine(b *strings.Builder, line string) {
+	limit := icalLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of a continuation line counts towards its length
+		limit = icalLineLimit - 1
+	}
+
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+func isRuneStart(c byte) bool {
+	return c&0xC0 != 0x80
+}
+
+var icalTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICalText(s string) string {
+	return icalTextEscaper.Replace(s)
+}
+
+var icalTextUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// parseICalendar reads the VEVENTs of an iCalendar document.
+// Times without a timezone are read in loc, cancelled events are left out. Recurring events are expanded
+// into the occurrences that end after now and start within icalRecurrenceYears of it.
+func parseICalendar(r io.Reader, loc *time.Location, now time.Time) ([]ICalEvent, error) {
+	lines, err := unfoldICalLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		events     []ICalEvent
+		current    *ICalEvent
+		cancelled  bool
+		sawHeader  bool
+		rrule      string
+		exceptions []time.Time
+		duration   *icalDuration
+	)
+	horizon := now.AddDate(icalRecurrenceYears, 0, 0)
+
+	for _, line := range lines {
+		name, params, value := splitICalProperty(line)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCALENDAR"):
+			sawHeader = true
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			current = &ICalEvent{}
+			cancelled = false
+			rrule, exceptions, duration = "", nil, nil
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if current == nil {
+				continue
+			}
+
+			event := *current
+			current = nil
+
+			if cancelled || event.Start.IsZero() {
+				continue
+			}
+
+			// RFC 5545 3.6.1: without DTEND or DURATION an all-day event lasts one day
+			if event.End.IsZero() && duration != nil {
+				event.End = duration.after(event.Start)
+			}
+			if event.End.IsZero() && event.AllDay {
+				event.End = event.Start.AddDate(0, 0, 1)
+			}
+
+			if !event.End.After(event.Start) {
+				continue
+			}
+
+			occurrences := []ICalEvent{event}
+			if rrule != "" {
+				recurrence, err := parseICalRecurrence(rrule, loc)
+				if err != nil {
+					return nil, fmt.Errorf("event %s: %w", event.UID, err)
+				}
+
+				occurrences, err = recurrence.expand(event, exceptions, now, horizon, maxICalEvents-len(events))
+				if err != nil {
+					return nil, fmt.Errorf("event %s: %w", event.UID, err)
+				}
+			}
+
+			events = append(events, occurrences...)
+			if len(events) > maxICalEvents {
+				return nil, fmt.Errorf("calendar has more than %d events", maxICalEvents)
+			}
+		case current == nil:
+			continue
+		case name == "UID":
+			current.UID = value
+		case name == "SUMMARY":
+			current.Summary = icalTextUnescaper.Replace(value)
+		case name == "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "DTSTART":
+			current.Start, current.AllDay, err = parseICalTime(params, value, loc)
+			if err != nil {
+				return nil, fmt.Errorf("event %s: %w", current.UID, err)
+			}
+		case name == "DTEND":
+			current.End, _, err = parseICalTime(params, value, loc)
+			if err != nil {
+				return nil, fmt.Errorf("event %s: %w", current.UID, err)
+			}
+		case name == "DURATION":
+			duration, err = parseICalDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("event %s: %w", current.UID, err)
+			}
+		case name == "RRULE":
+			rrule = value
+		case name == "RDATE":
+			return nil, fmt.Errorf("event %s: RDATE recurrences are not supported", current.UID)
+		case name == "EXDATE":
+			for _, exdate := range strings.Split(value, ",") {
+				exception, _, err := parseICalTime(params, exdate, loc)
+				if err != nil {
+					return nil, fmt.Errorf("event %s: %w", current.UID, err)
+				}
+				exceptions = append(exceptions, exception)
+			}
+		}
+	}
+
+	if !sawHeader {
+		return nil, errors.New("not an iCalendar document")
+	}
+
+	return events, nil
+}
+
+// unfoldICalLines joins continuation lines, which start with a space or a tab
+func unfoldICalLines(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitICalProperty splits NAME;PARAM=VALUE:value, a colon inside a quoted parameter is not a separator
+func splitICalProperty(line string) (string, map[string]string, string) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := map[string]string{}
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+
+	return strings.ToUpper(parts[0]), params, line[colon+1:]
+}
+
+// parseICalTime reads a DATE, a UTC DATE-TIME, a DATE-TIME with a TZID or a floating DATE-TIME
+func parseICalTime(params map[string]string, value string, loc *time.Location) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == len(icalDate) {
+		t, err := time.ParseInLocation(icalDate, value, loc)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icalDateTimeUTC, value)
+		return t, false, err
+	}
+
+	if tzid := params["TZID"]; tzid != "" {
+		if tzLoc, err := time.LoadLocation(tzid); err == nil {
+			loc = tzLoc
+		}
+	}
+
+	t, err := time.ParseInLocation(icalDateTime, value, loc)
+	return t, false, err
+}
+
+// icalDuration is a DURATION value, days and weeks are nominal so an all-day event keeps its dates over a dst change
+type icalDuration struct {
+	Days  int
+	Clock time.Duration
+}
+
+func (d icalDuration) after(start time.Time) time.Time {
+	return start.AddDate(0, 0, d.Days).Add(d.Clock)
+}
+
+// parseICalDuration reads a positive RFC 5545 duration, e.g. P1W, P2D or PT1H30M
+func parseICalDuration(value string) (*icalDuration, error) {
+	value = strings.TrimPrefix(value, "+")
+	if strings.HasPrefix(value, "-") {
+		return nil, fmt.Errorf("negative duration %s", value)
+	}
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return nil, fmt.Errorf("invalid duration %s", value)
+	}
+
+	var (
+		d      icalDuration
+		number int
+		digits bool
+		clock  bool
+	)
+	for _, c := range value[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			number = number*10 + int(c-'0')
+			digits = true
+			continue
+		case c == 'T' && !clock && !digits:
+			clock = true
+			continue
+		case !digits:
+			return nil, fmt.Errorf("invalid duration %s", value)
+		case c == 'W' && !clock:
+			d.Days += 7 * number
+		case c == 'D' && !clock:
+			d.Days += number
+		case c == 'H' && clock:
+			d.Clock += time.Duration(number) * time.Hour
+		case c == 'M' && clock:
+			d.Clock += time.Duration(number) * time.Minute
+		case c == 'S' && clock:
+			d.Clock += time.Duration(number) * time.Second
+		default:
+			return nil, fmt.Errorf("invalid duration %s", value)
+		}
+		number, digits = 0, false
+	}
+
+	if digits {
+		return nil, fmt.Errorf("invalid duration %s", value)
+	}
+
+	return &d, nil
+}
+
+// icalRecurrence is the part of an RRULE that is expanded, a rule using anything else is refused
+// rather than read as a single event
+type icalRecurrence struct {
+	Freq     string
+	Interval int
+	Count    int
+	Until    time.Time
+	ByDay    []time.Weekday // only with FREQ=WEEKLY
+}
+
+var icalWeekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// parseICalRecurrence reads an RRULE value, UNTIL without a timezone is read in loc
+func parseICalRecurrence(value string, loc *time.Location) (*icalRecurrence, error) {
+	rule := &icalRecurrence{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(val)
+			if err == nil && rule.Interval < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(val)
+			if err == nil && rule.Count < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "UNTIL":
+			rule.Until, _, err = parseICalTime(nil, val, loc)
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, ok := icalWeekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("recurrence day %s is not supported", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "WKST":
+			// weeks start on monday, the default, another start shifts the weeks of a rule with an interval
+			if !strings.EqualFold(val, "MO") {
+				return nil, fmt.Errorf("recurrence week start %s is not supported", val)
+			}
+		default:
+			return nil, fmt.Errorf("recurrence rule part %s is not supported", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("recurrence rule %s: %w", part, err)
+		}
+	}
+
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("recurrence frequency %q is not supported", rule.Freq)
+	}
+
+	if len(rule.ByDay) > 0 && rule.Freq != "WEEKLY" {
+		return nil, fmt.Errorf("recurrence days are only supported on weekly rules")
+	}
+
+	return rule, nil
+}
+
+// expand returns the occurrences of event that end after now and start before horizon, leaving out exceptions.
+// It fails once more than limit occurrences are found.
+func (rule *icalRecurrence) expand(event ICalEvent, exceptions []time.Time, now, horizon time.Time, limit int) ([]ICalEvent, error) {
+	length := icalDuration{Clock: event.End.Sub(event.Start)}
+	if event.AllDay {
+		length = icalDuration{Days: int(math.Round(event.End.Sub(event.Start).Hours() / 24))}
+	}
+
+	var occurrences []ICalEvent
+	seen := 0
+	for period := 0; period < maxICalRecurrenceSteps; period++ {
+		for _, start := range rule.periodStarts(event.Start, period) {
+			if start.Before(event.Start) {
+				continue
+			}
+			if !start.Before(horizon) || (!rule.Until.IsZero() && start.After(rule.Until)) {
+				return occurrences, nil
+			}
+
+			// COUNT counts every occurrence, the ones already over and the excluded ones too
+			seen++
+			if rule.Count > 0 && seen > rule.Count {
+				return occurrences, nil
+			}
+
+			end := length.after(start)
+			if !end.After(now) || slices.ContainsFunc(exceptions, start.Equal) {
+				continue
+			}
+
+			occurrences = append(occurrences, ICalEvent{UID: event.UID, Summary: event.Summary, Start: start, End: end, AllDay: event.AllDay})
+			if len(occurrences) > limit {
+				return nil, fmt.Errorf("calendar has more than %d events", maxICalEvents)
+			}
+		}
+	}
+
+	return occurrences, nil
+}
+
+// periodStarts returns the starts in the period-th period of the rule from first, in order. A monthly or yearly
+// start on a day the month doesn't have, e.g. the 31st, is skipped as RFC 5545 asks.
+func (rule *icalRecurrence) periodStarts(first time.Time, period int) []time.Time {
+	step := period * rule.Interval
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, first.Hour(), first.Minute(), first.Second(), 0, first.Location())
+	}
+
+	switch rule.Freq {
+	case "DAILY":
+		return []time.Time{at(first.Year(), first.Month(), first.Day()+step)}
+	case "WEEKLY":
+		if len(rule.ByDay) == 0 {
+			return []time.Time{at(first.Year(), first.Month(), first.Day()+7*step)}
+		}
+
+		monday := first.Day() - (int(first.Weekday())+6)%7
+		starts := make([]time.Time, 0, len(rule.ByDay))
+		for _, weekday := range rule.ByDay {
+			starts = append(starts, at(first.Year(), first.Month(), monday+7*step+(int(weekday)+6)%7))
+		}
+		slices.SortFunc(starts, func(a, b time.Time) int { return a.Compare(b) })
+		return slices.CompactFunc(starts, time.Time.Equal)
+	case "MONTHLY":
+		start := at(first.Year(), first.Month()+time.Month(step), first.Day())
+		if start.Day() != first.Day() {
+			return nil
+		}
+		return []time.Time{start}
+	case "YEARLY":
+		start := at(first.Year()+step, first.Month(), first.Day())
+		if start.Day() != first.Day() {
+			return nil
+		}
+		return []time.Time{start}
+	}
+
+	return nil
+}
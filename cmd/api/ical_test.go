@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderICalendar(t *testing.T) {
+	start := time.Date(2030, 6, 15, 9, 0, 0, 0, time.UTC)
+	events := []ICalFeedEvent{{
+		UID:         "booking-1@broker-service",
+		Summary:     "Booking 1, accepted; " + strings.Repeat("long ", 20),
+		Description: "Inventory: 1\nRenter: 2",
+		Start:       start,
+		End:         start.Add(48 * time.Hour),
+	}}
+
+	feed := renderICalendar("Bookings", events, start)
+
+	t.Log("Checking the feed is a calendar with crlf lines no longer than 75 octets")
+	assert.True(t, strings.HasPrefix(feed, "BEGIN:VCALENDAR\r\n"))
+	assert.True(t, strings.HasSuffix(feed, "END:VCALENDAR\r\n"))
+	for _, line := range strings.Split(strings.TrimSuffix(feed, "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), icalLineLimit)
+	}
+	assert.Contains(t, feed, "DTSTART:20300615T090000Z\r\n")
+	assert.Contains(t, feed, `DESCRIPTION:Inventory: 1\nRenter: 2`)
+
+	t.Log("Checking a rendered feed parses back")
+	parsed, err := parseICalendar(strings.NewReader(feed), time.UTC, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, parsed, 1)
+	assert.Equal(t, events[0].Summary, parsed[0].Summary)
+	assert.True(t, parsed[0].Start.Equal(start))
+	assert.True(t, parsed[0].End.Equal(start.Add(48*time.Hour)))
+}
+
+func TestParseICalendar(t *testing.T) {
+	lagos, _ := time.LoadLocation(defaultBookingTimezone)
+	doc := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VEVENT",
+		"UID:all-day",
+		"DTSTART;VALUE=DATE:20300701",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:zoned",
+		`DTSTART;TZID="Europe/London":20300702T100000`,
+		"DTEND;TZID=Europe/London:20300702T120000",
+		"SUMMARY:Blocked on the other ",
+		" platform",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:cancelled",
+		"STATUS:CANCELLED",
+		"DTSTART:20300703T100000Z",
+		"DTEND:20300703T120000Z",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := parseICalendar(strings.NewReader(doc), lagos, time.Now())
+	assert.NoError(t, err)
+
+	t.Log("Checking cancelled events are left out")
+	assert.Len(t, events, 2)
+
+	t.Log("Checking an all-day event without an end lasts a day in the default timezone")
+	assert.True(t, events[0].AllDay)
+	assert.Equal(t, time.Date(2030, 7, 1, 0, 0, 0, 0, lagos), events[0].Start)
+	assert.Equal(t, 24*time.Hour, events[0].End.Sub(events[0].Start))
+
+	t.Log("Checking TZID times and folded lines")
+	assert.Equal(t, time.Date(2030, 7, 2, 9, 0, 0, 0, time.UTC), events[1].Start.UTC())
+	assert.Equal(t, "Blocked on the other platform", events[1].Summary)
+
+	t.Log("Checking only events that have not ended block the inventory")
+	blocks := calendarBlocks(events, 3, time.Date(2030, 7, 2, 0, 0, 0, 0, time.UTC))
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, 3.0, blocks[0].Quantity)
+	assert.Equal(t, importedBlockSource, blocks[0].Source)
+
+	t.Log("Checking other documents are refused")
+	_, err = parseICalendar(strings.NewReader("hello"), lagos, time.Now())
+	assert.Error(t, err)
+}
+
+func TestParseICalendarRecurrence(t *testing.T) {
+	lagos, _ := time.LoadLocation(defaultBookingTimezone)
+	now := time.Date(2030, 1, 10, 12, 0, 0, 0, lagos)
+	parse := func(lines ...string) ([]ICalEvent, error) {
+		doc := append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "BEGIN:VEVENT", "UID:rule"}, lines...)
+		doc = append(doc, "END:VEVENT", "END:VCALENDAR")
+		return parseICalendar(strings.NewReader(strings.Join(doc, "\r\n")), lagos, now)
+	}
+
+	t.Log("Checking DURATION is used when there is no DTEND")
+	events, err := parse("DTSTART:20300201T090000Z", "DURATION:P1DT2H30M")
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, 26*time.Hour+30*time.Minute, events[0].End.Sub(events[0].Start))
+	events, err = parse("DTSTART;VALUE=DATE:20300201", "DURATION:P1W")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2030, 2, 8, 0, 0, 0, 0, lagos), events[0].End)
+	_, err = parse("DTSTART:20300201T090000Z", "DURATION:-PT1H")
+	assert.Error(t, err)
+	_, err = parse("DTSTART:20300201T090000Z", "DURATION:P1H")
+	assert.Error(t, err)
+
+	t.Log("Checking a daily rule is expanded from now, counting occurrences already over and skipping exceptions")
+	events, err = parse("DTSTART;VALUE=DATE:20300108", "RRULE:FREQ=DAILY;COUNT=5", "EXDATE;VALUE=DATE:20300111")
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, time.Date(2030, 1, 10, 0, 0, 0, 0, lagos), events[0].Start)
+	assert.Equal(t, time.Date(2030, 1, 12, 0, 0, 0, 0, lagos), events[1].Start)
+	assert.Equal(t, time.Date(2030, 1, 13, 0, 0, 0, 0, lagos), events[1].End)
+
+	t.Log("Checking a weekly rule on several days stops at UNTIL")
+	events, err = parse("DTSTART:20300114T090000Z", "DTEND:20300114T100000Z", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;UNTIL=20300201T000000Z")
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+	assert.Equal(t, time.Date(2030, 1, 18, 9, 0, 0, 0, time.UTC), events[1].Start.UTC())
+	assert.Equal(t, time.Date(2030, 1, 28, 9, 0, 0, 0, time.UTC), events[2].Start.UTC())
+
+	t.Log("Checking a monthly rule skips months without the day, they don't count")
+	events, err = parse("DTSTART;VALUE=DATE:20300131", "RRULE:FREQ=MONTHLY;COUNT=3")
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+	assert.Equal(t, time.March, events[1].Start.Month())
+	assert.Equal(t, time.May, events[2].Start.Month())
+
+	t.Log("Checking an endless rule stops at the horizon")
+	events, err = parse("DTSTART;VALUE=DATE:20300101", "RRULE:FREQ=WEEKLY")
+	assert.NoError(t, err)
+	assert.Len(t, events, 104)
+	assert.Equal(t, time.Date(2030, 1, 15, 0, 0, 0, 0, lagos), events[0].Start)
+	assert.True(t, events[len(events)-1].Start.Before(now.AddDate(icalRecurrenceYears, 0, 0)))
+
+	t.Log("Checking rules that can not be expanded are refused")
+	for _, rule := range []string{"RRULE:FREQ=HOURLY", "RRULE:FREQ=MONTHLY;BYDAY=1MO", "RRULE:FREQ=WEEKLY;BYSETPOS=1", "RRULE:FREQ=DAILY;INTERVAL=0"} {
+		_, err = parse("DTSTART;VALUE=DATE:20300101", rule)
+		assert.Error(t, err, rule)
+	}
+	_, err = parse("DTSTART;VALUE=DATE:20300101", "RDATE;VALUE=DATE:20300105")
+	assert.Error(t, err)
+}
+
+func TestCalendarFetchGuard(t *testing.T) {
+	t.Log("Checking only public addresses are dialled")
+	for _, address := range []string{"127.0.0.1:443", "10.0.0.5:80", "172.16.3.1:80", "192.168.1.1:80", "169.254.169.254:80", "100.64.0.1:80", "0.0.0.0:80", "[::1]:443", "[fd00::1]:443", "[::ffff:127.0.0.1]:80"} {
+		assert.Error(t, dialPublicOnly("tcp", address, nil), address)
+	}
+	assert.NoError(t, dialPublicOnly("tcp", "93.184.216.34:443", nil))
+	assert.NoError(t, dialPublicOnly("tcp", "[2606:4700::1111]:443", nil))
+
+	t.Log("Checking redirects are capped and stay on http")
+	redirect, _ := http.NewRequest("GET", "https://example.com/cal.ics", nil)
+	assert.NoError(t, checkCalendarRedirect(redirect, make([]*http.Request, 1)))
+	assert.Error(t, checkCalendarRedirect(redirect, make([]*http.Request, maxCalendarRedirects)))
+	file, _ := http.NewRequest("GET", "file:///etc/passwd", nil)
+	assert.Error(t, checkCalendarRedirect(file, nil))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A4 in points, the unit pdf draws in
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+)
+
+// pdfColor is an rgb colour with every channel between 0 and 1
+type pdfColor struct {
+	R, G, B float64
+}
+
+var (
+	pdfBlack = pdfColor{0, 0, 0}
+	pdfWhite = pdfColor{1, 1, 1}
+	pdfGrey  = pdfColor{0.45, 0.45, 0.45}
+)
+
+// parseHexColor reads a #rrggbb colour, ok is false for anything else
+func parseHexColor(hex string) (pdfColor, bool) {
+	hex = strings.TrimPrefix(strings.TrimSpace(hex), "#")
+	if len(hex) != 6 {
+		return pdfColor{}, false
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return pdfColor{}, false
+	}
+
+	return pdfColor{
+		R: float64(value>>16&0xff) / 255,
+		G: float64(value>>8&0xff) / 255,
+		B: float64(value&0xff) / 255,
+	}, true
+}
+
+// pdfPage collects the drawing operators of a one page document,
+// text is set in the standard helvetica fonts so nothing has to be embedded
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (p *pdfPage) fill(c pdfColor) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f rg\n", c.R, c.G, c.B)
+}
+
+// text draws s with its baseline starting at x, y measured from the bottom left of the page
+func (p *pdfPage) text(x, y, size float64, bold bool, c pdfColor, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+
+	p.fill(c)
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+// textRight draws s so that it ends at x
+func (p *pdfPage) textRight(x, y, size float64, bold bool, c pdfColor, s string) {
+	p.text(x-pdfTextWidth(s, size), y, size, bold, c, s)
+}
+
+func (p *pdfPage) rect(x, y, width, height float64, c pdfColor) {
+	p.fill(c)
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f %.2f re f\n", x, y, width, height)
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2 float64, c pdfColor) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f RG 0.5 w %.2f %.2f m %.2f %.2f l S\n", c.R, c.G, c.B, x1, y1, x2, y2)
+}
+
+// bytes lays the page out as a complete pdf file
+func (p *pdfPage) bytes() []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> /Contents 4 0 R >>", pdfPageWidth, pdfPageHeight),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", p.content.Len(), p.content.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+
+	var doc bytes.Buffer
+	doc.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = doc.Len()
+		fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := doc.Len()
+	fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&doc, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return doc.Bytes()
+}
+
+// pdfEscape makes s safe inside a pdf string, anything the standard fonts can not show becomes ?
+func pdfEscape(s string) string {
+	s = strings.ReplaceAll(s, "₦", "NGN ")
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// pdfTextWidth estimates how wide s is set in helvetica, close enough to right align amounts
+func pdfTextWidth(s string, size float64) float64 {
+	units := 0
+	for _, r := range s {
+		switch {
+		case r == ' ' || r == '.' || r == ',' || r == ':' || r == 'i' || r == 'l':
+			units += 278
+		case r >= 'A' && r <= 'Z':
+			units += 667
+		default:
+			units += 556
+		}
+	}
+
+	return float64(units) * size / 1000
+}
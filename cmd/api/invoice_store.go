@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrInvoiceNotFound is returned when nothing was issued for a booking, order or payment yet
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+// InvoiceStore keeps issued invoices and the invoice sequence of every business
+type InvoiceStore interface {
+	Get(ctx context.Context, subjectType, subjectID string) (Invoice, error)
+	// Issue gives invoice the next number of its business and saves it, an invoice already
+	// issued for the same subject is returned as it is
+	Issue(ctx context.Context, invoice Invoice) (Invoice, error)
+}
+
+// redisInvoiceStore keeps an invoice under its subject and a counter per business
+type redisInvoiceStore struct {
+	cache *redis.Client
+}
+
+func invoiceKey(subjectType, subjectID string) string {
+	return fmt.Sprintf("invoices:%s:%s", subjectType, subjectID)
+}
+
+func invoiceSequenceKey(businessID string) string {
+	return fmt.Sprintf("invoices:seq:%s", businessID)
+}
+
+func (s redisInvoiceStore) Get(ctx context.Context, subjectType, subjectID string) (Invoice, error) {
+	rawInvoice, err := s.cache.Get(ctx, invoiceKey(subjectType, subjectID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Invoice{}, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	var invoice Invoice
+	err = json.Unmarshal(rawInvoice, &invoice)
+
+	return invoice, err
+}
+
+func (s redisInvoiceStore) Issue(ctx context.Context, invoice Invoice) (Invoice, error) {
+	sequence, err := s.cache.Incr(ctx, invoiceSequenceKey(invoice.BusinessID)).Result()
+	if err != nil {
+		return Invoice{}, err
+	}
+	invoice.number(sequence)
+
+	rawInvoice, err := json.Marshal(invoice)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	saved, err := s.cache.SetNX(ctx, invoiceKey(invoice.SubjectType, invoice.SubjectID), rawInvoice, 0).Result()
+	if err != nil {
+		return Invoice{}, err
+	}
+	if !saved {
+		return s.Get(ctx, invoice.SubjectType, invoice.SubjectID)
+	}
+
+	return invoice, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryInvoiceStore is a stand-in store used in tests
+type memoryInvoiceStore struct {
+	mu        sync.Mutex
+	invoices  map[string]Invoice
+	sequences map[string]int64
+}
+
+func newMemoryInvoiceStore() *memoryInvoiceStore {
+	return &memoryInvoiceStore{invoices: make(map[string]Invoice), sequences: make(map[string]int64)}
+}
+
+func (s *memoryInvoiceStore) Get(ctx context.Context, subjectType, subjectID string) (Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invoice, ok := s.invoices[invoiceKey(subjectType, subjectID)]
+	if !ok {
+		return Invoice{}, ErrInvoiceNotFound
+	}
+
+	return invoice, nil
+}
+
+func (s *memoryInvoiceStore) Issue(ctx context.Context, invoice Invoice) (Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := invoiceKey(invoice.SubjectType, invoice.SubjectID)
+	if existing, ok := s.invoices[key]; ok {
+		return existing, nil
+	}
+
+	s.sequences[invoice.BusinessID]++
+	invoice.number(s.sequences[invoice.BusinessID])
+	s.invoices[key] = invoice
+
+	return invoice, nil
+}
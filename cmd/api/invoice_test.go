@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testInvoice() Invoice {
+	invoice, _ := bookingInvoice(BookingSnapshot{
+		ID:                "booking-1",
+		RenterID:          "renter-1",
+		OwnerID:           "owner-1",
+		Status:            BookingCompleted,
+		StartDate:         "2026-01-02",
+		EndDate:           "2026-01-05",
+		Quantity:          1,
+		OfferPricePerUnit: 5000,
+		SecurityDeposit:   2500,
+		TotalAmount:       17500,
+	}, "Canon <EOS> (body)")
+	invoice.Business = BusinessBranding{Name: "Lens & Co", BrandColor: "#0055aa"}
+	invoice.IssuedAt = time.Date(2026, 1, 6, 10, 0, 0, 0, time.UTC).UnixMilli()
+	invoice.number(7)
+
+	return invoice
+}
+
+func TestDraftInvoice(t *testing.T) {
+	t.Log("Checking a booking invoice lists the deposit apart from the rental")
+	invoice := testInvoice()
+	assert.Equal(t, "INV-000007", invoice.Number)
+	assert.Equal(t, "owner-1", invoice.BusinessID)
+	assert.Equal(t, 15000.0, invoice.Subtotal)
+	assert.Equal(t, 2500.0, invoice.SecurityDeposit)
+	assert.Equal(t, 17500.0, invoice.Total)
+	assert.True(t, invoice.visibleTo("renter-1"))
+	assert.False(t, invoice.visibleTo("someone-else"))
+
+	t.Log("Checking nothing is issued before a booking or order is completed")
+	_, err := bookingInvoice(BookingSnapshot{ID: "booking-2", Status: BookingAccepted}, "Tent")
+	assert.Error(t, err)
+	_, err = orderInvoice(OrderSnapshot{ID: "order-1", Status: OrderShipped}, "Tent")
+	assert.Error(t, err)
+
+	t.Log("Checking a subscription payment gets a receipt from the platform")
+	receipt, err := subscriptionReceipt(SubscriptionPayment{ID: "payment-1", UserID: "user-1", PlanName: "Pro", BillingCycle: BillingCycleMonthly, Amount: 9000, Status: subscriptionPaid})
+	assert.NoError(t, err)
+	assert.Equal(t, platformBusinessID, receipt.BusinessID)
+	assert.Equal(t, "Receipt", receipt.Title())
+	_, err = subscriptionReceipt(SubscriptionPayment{ID: "payment-2", Status: "failed"})
+	assert.Error(t, err)
+}
+
+func TestInvoiceNumbering(t *testing.T) {
+	for name, store := range map[string]InvoiceStore{
+		"memory": newMemoryInvoiceStore(),
+		"redis":  redisInvoiceStore{cache: newTestRedis(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Log("Checking every business numbers its invoices from one")
+			first, err := store.Issue(ctx, Invoice{SubjectType: InvoiceOrder, SubjectID: "order-1", BusinessID: "seller-1"})
+			assert.NoError(t, err)
+			second, err := store.Issue(ctx, Invoice{SubjectType: InvoiceBooking, SubjectID: "booking-1", BusinessID: "seller-1"})
+			assert.NoError(t, err)
+			other, err := store.Issue(ctx, Invoice{SubjectType: InvoiceOrder, SubjectID: "order-2", BusinessID: "seller-2"})
+			assert.NoError(t, err)
+			assert.Equal(t, "INV-000001", first.Number)
+			assert.Equal(t, "INV-000002", second.Number)
+			assert.Equal(t, "INV-000001", other.Number)
+
+			t.Log("Checking a subject keeps the invoice it was issued")
+			again, err := store.Issue(ctx, Invoice{SubjectType: InvoiceOrder, SubjectID: "order-1", BusinessID: "seller-1"})
+			assert.NoError(t, err)
+			assert.Equal(t, "INV-000001", again.Number)
+			_, err = store.Get(ctx, InvoiceOrder, "order-3")
+			assert.ErrorIs(t, err, ErrInvoiceNotFound)
+
+			t.Log("Checking an issued invoice is only handed to its parties and admins")
+			app := Config{invoices: store}
+			_, err = app.issueInvoice(ctx, InvoiceOrder, "order-2", "seller-1", false)
+			assert.ErrorIs(t, err, errNotInvoiceParty)
+			issued, err := app.issueInvoice(ctx, InvoiceOrder, "order-2", "admin-1", true)
+			assert.NoError(t, err)
+			assert.Equal(t, "seller-2", issued.BusinessID)
+		})
+	}
+}
+
+func TestRenderInvoicePDF(t *testing.T) {
+	document := renderInvoicePDF(testInvoice())
+
+	t.Log("Checking the document is a pdf whose cross reference table is where the trailer says")
+	assert.True(t, bytes.HasPrefix(document, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(document, []byte("%%EOF\n")))
+
+	trailer := string(document[bytes.LastIndex(document, []byte("startxref")):])
+	offset, err := strconv.Atoi(strings.Fields(trailer)[1])
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(document[offset:], []byte("xref")))
+
+	t.Log("Checking text is escaped and amounts are grouped")
+	assert.Contains(t, string(document), `Canon <EOS> \(body\)`)
+	assert.Contains(t, string(document), "NGN 17,500.00")
+	assert.Equal(t, `a\\b \(c\) ?`, pdfEscape(`a\b (c) é`))
+}
+
+func TestRenderInvoiceHTML(t *testing.T) {
+	document, err := renderInvoiceHTML(testInvoice())
+	assert.NoError(t, err)
+
+	t.Log("Checking the page carries the branding and escapes what it shows")
+	assert.Contains(t, string(document), "background: #0055aa")
+	assert.Contains(t, string(document), "Lens &amp; Co")
+	assert.Contains(t, string(document), "Canon &lt;EOS&gt; (body)")
+	assert.Contains(t, string(document), "INV-000007")
+	assert.Contains(t, string(document), "Security deposit")
+}
+
+func TestFormatAmount(t *testing.T) {
+	t.Log("Checking amounts are grouped in thousands")
+	assert.Equal(t, "NGN 0.50", formatAmount("NGN", 0.5))
+	assert.Equal(t, "NGN 999.00", formatAmount("NGN", 999))
+	assert.Equal(t, "NGN 1,234,567.89", formatAmount("NGN", 1234567.891))
+	assert.Equal(t, "NGN -1,000.00", formatAmount("NGN", -1000))
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// what an invoice can be issued for
+const (
+	InvoiceBooking      = "booking"
+	InvoiceOrder        = "order"
+	InvoiceSubscription = "subscription"
+)
+
+var invoiceSubjects = []string{InvoiceBooking, InvoiceOrder, InvoiceSubscription}
+
+const (
+	invoiceFormatPDF  = "pdf"
+	invoiceFormatHTML = "html"
+
+	// platformBusinessID numbers the receipts of subscriptions, which the platform itself is paid for
+	platformBusinessID = "platform"
+	invoiceCurrency    = "NGN"
+	// subscriptionPaid is the status the payment service gives a successful payment
+	subscriptionPaid = "success"
+	// invoiceLockTTL bounds how long issuing one invoice can block another for the same subject
+	invoiceLockTTL = 30 * time.Second
+	// defaultBrandColor is used when a business did not choose one
+	defaultBrandColor = "#1f2937"
+)
+
+// BusinessBranding is how a business presents itself on its invoices
+type BusinessBranding struct {
+	Name       string `json:"business_name"`
+	Email      string `json:"email,omitempty"`
+	Phone      string `json:"phone_number,omitempty"`
+	Address    string `json:"address,omitempty"`
+	LogoURL    string `json:"logo_url,omitempty"`
+	BrandColor string `json:"brand_color,omitempty"`
+}
+
+// InvoiceLine is one charge on an invoice
+type InvoiceLine struct {
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	UnitPrice   float64 `json:"unit_price"`
+	Amount      float64 `json:"amount"`
+}
+
+// Invoice is the proof of a completed booking, order or subscription payment,
+// it is numbered in the sequence of the business that was paid and never changes once issued
+type Invoice struct {
+	Number          string           `json:"number"`
+	Sequence        int64            `json:"sequence"`
+	SubjectType     string           `json:"subject_type"`
+	SubjectID       string           `json:"subject_id"`
+	BusinessID      string           `json:"business_id"`
+	Business        BusinessBranding `json:"business"`
+	CustomerID      string           `json:"customer_id"`
+	Lines           []InvoiceLine    `json:"lines"`
+	Subtotal        float64          `json:"subtotal"`
+	SecurityDeposit float64          `json:"security_deposit,omitempty"`
+	Total           float64          `json:"total"`
+	Currency        string           `json:"currency"`
+	Reference       string           `json:"reference,omitempty"`
+	IssuedAt        int64            `json:"issued_at"`
+}
+
+func (inv *Invoice) number(sequence int64) {
+	inv.Sequence = sequence
+	inv.Number = fmt.Sprintf("INV-%06d", sequence)
+}
+
+// Title is what the document is called, a subscription is paid up front so it gets a receipt
+func (inv Invoice) Title() string {
+	if inv.SubjectType == InvoiceSubscription {
+		return "Receipt"
+	}
+	return "Invoice"
+}
+
+func (inv Invoice) visibleTo(userID string) bool {
+	return userID == inv.CustomerID || userID == inv.BusinessID
+}
+
+func (inv Invoice) filename(format string) string {
+	return fmt.Sprintf("%s-%s.%s", strings.ToLower(inv.Title()), inv.Number, format)
+}
+
+// SubscriptionPayment is a payment for a subscription as the payment service reports it
+type SubscriptionPayment struct {
+	ID           string       `json:"id"`
+	UserID       string       `json:"user_id"`
+	PlanName     string       `json:"plan_name"`
+	BillingCycle BillingCycle `json:"billing_cycle"`
+	Amount       float64      `json:"amount"`
+	Status       string       `json:"status"`
+	Reference    string       `json:"reference"`
+}
+
+// bookingInvoice drafts the invoice of a completed booking, the deposit is listed apart from the rental
+func bookingInvoice(booking BookingSnapshot, itemName string) (Invoice, error) {
+	if booking.Status != BookingCompleted {
+		return Invoice{}, fmt.Errorf("an invoice is only issued for a completed booking, this one is %s", booking.Status)
+	}
+
+	rental := roundMoney(booking.TotalAmount - booking.SecurityDeposit)
+	return Invoice{
+		SubjectType: InvoiceBooking,
+		SubjectID:   booking.ID,
+		BusinessID:  booking.OwnerID,
+		CustomerID:  booking.RenterID,
+		Lines: []InvoiceLine{{
+			Description: fmt.Sprintf("Rental of %s, %s to %s", itemName, booking.StartDate, booking.EndDate),
+			Quantity:    booking.Quantity,
+			UnitPrice:   booking.OfferPricePerUnit,
+			Amount:      rental,
+		}},
+		Subtotal:        rental,
+		SecurityDeposit: booking.SecurityDeposit,
+		Total:           roundMoney(booking.TotalAmount),
+		Currency:        invoiceCurrency,
+	}, nil
+}
+
+// orderInvoice drafts the invoice of a purchase order the buyer confirmed they received
+func orderInvoice(order OrderSnapshot, itemName string) (Invoice, error) {
+	if order.Status != OrderCompleted {
+		return Invoice{}, fmt.Errorf("an invoice is only issued for a completed order, this one is %s", order.Status)
+	}
+
+	return Invoice{
+		SubjectType: InvoiceOrder,
+		SubjectID:   order.ID,
+		BusinessID:  order.SellerID,
+		CustomerID:  order.BuyerID,
+		Lines: []InvoiceLine{{
+			Description: itemName,
+			Quantity:    order.Quantity,
+			UnitPrice:   order.OfferPricePerUnit,
+			Amount:      roundMoney(order.TotalAmount),
+		}},
+		Subtotal: roundMoney(order.TotalAmount),
+		Total:    roundMoney(order.TotalAmount),
+		Currency: invoiceCurrency,
+	}, nil
+}
+
+// subscriptionReceipt drafts the receipt of a successful subscription payment
+func subscriptionReceipt(payment SubscriptionPayment) (Invoice, error) {
+	if payment.Status != subscriptionPaid {
+		return Invoice{}, errors.New("a receipt is only issued for a successful payment")
+	}
+
+	return Invoice{
+		SubjectType: InvoiceSubscription,
+		SubjectID:   payment.ID,
+		BusinessID:  platformBusinessID,
+		CustomerID:  payment.UserID,
+		Lines: []InvoiceLine{{
+			Description: fmt.Sprintf("%s subscription, billed %s", payment.PlanName, payment.BillingCycle),
+			Quantity:    1,
+			UnitPrice:   payment.Amount,
+			Amount:      roundMoney(payment.Amount),
+		}},
+		Subtotal:  roundMoney(payment.Amount),
+		Total:     roundMoney(payment.Amount),
+		Currency:  invoiceCurrency,
+		Reference: payment.Reference,
+	}, nil
+}
+
+// inventoryName names an item on an invoice, falling back to a generic name if the inventory is gone
+func (app *Config) inventoryName(inventoryID string) string {
+	item, err := app.getInventory(inventoryID)
+	if err != nil || item.GetName() == "" {
+		return "Inventory item"
+	}
+	return item.GetName()
+}
+
+// errNotInvoiceParty is returned to anyone but the customer, the business or an admin
+var errNotInvoiceParty = errors.New("you are not a party to this invoice")
+
+// invoiceSubject is the booking, order or payment an invoice is for, its parties are checked
+// before draft says anything about whether it can be invoiced
+type invoiceSubject struct {
+	CustomerID string
+	BusinessID string
+	draft      func() (Invoice, error)
+}
+
+func (s invoiceSubject) party(userID string) bool {
+	return userID == s.CustomerID || userID == s.BusinessID
+}
+
+// loadInvoiceSubject loads what an invoice is for
+func (app *Config) loadInvoiceSubject(subjectType, subjectID string) (invoiceSubject, error) {
+	switch subjectType {
+	case InvoiceBooking:
+		booking, err := app.getBookingSnapshot(subjectID)
+		if err != nil {
+			return invoiceSubject{}, err
+		}
+		return invoiceSubject{
+			CustomerID: booking.RenterID,
+			BusinessID: booking.OwnerID,
+			draft: func() (Invoice, error) {
+				return bookingInvoice(booking, app.inventoryName(booking.InventoryID))
+			},
+		}, nil
+	case InvoiceOrder:
+		order, err := app.getOrderSnapshot(subjectID)
+		if err != nil {
+			return invoiceSubject{}, err
+		}
+		return invoiceSubject{
+			CustomerID: order.BuyerID,
+			BusinessID: order.SellerID,
+			draft: func() (Invoice, error) {
+				return orderInvoice(order, app.inventoryName(order.InventoryID))
+			},
+		}, nil
+	case InvoiceSubscription:
+		jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("PAYMENT_SERVICE_URL"), "subscription-payment"), map[string]string{
+			"payment_id": subjectID,
+		})
+		if err != nil {
+			return invoiceSubject{}, err
+		}
+
+		var payment SubscriptionPayment
+		err = decodeServiceData(jsonFromService.Data, &payment)
+		if err != nil {
+			return invoiceSubject{}, err
+		}
+		if payment.ID == "" {
+			return invoiceSubject{}, errors.New("payment not found")
+		}
+		return invoiceSubject{
+			CustomerID: payment.UserID,
+			BusinessID: platformBusinessID,
+			draft: func() (Invoice, error) {
+				return subscriptionReceipt(payment)
+			},
+		}, nil
+	default:
+		return invoiceSubject{}, fmt.Errorf("invoices are not issued for a %s", subjectType)
+	}
+}
+
+// platformBranding is what receipts of the platform, and invoices of a business without details, carry
+func platformBranding() BusinessBranding {
+	name := os.Getenv("PLATFORM_NAME")
+	if name == "" {
+		name = "Rental Service"
+	}
+
+	return BusinessBranding{Name: name, Email: os.Getenv("MAIL_FROM"), BrandColor: defaultBrandColor}
+}
+
+// getBusinessBranding loads the business details of businessID the same way GetBusinessDetail does,
+// an invoice is still issued with the platform's branding if they can not be loaded
+func (app *Config) getBusinessBranding(businessID string) BusinessBranding {
+	if businessID == platformBusinessID {
+		return platformBranding()
+	}
+
+	invServiceUrl := fmt.Sprintf("%sbusiness-details?user_id=%s", os.Getenv("INVENTORY_SERVICE_URL"), url.QueryEscape(businessID))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(invServiceUrl)
+	if err != nil {
+		log.Printf("[INVOICES] loading the business details of %s failed: %v", businessID, err)
+		return platformBranding()
+	}
+	defer response.Body.Close()
+
+	var jsonFromService jsonResponse
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil || response.StatusCode != http.StatusAccepted {
+		log.Printf("[INVOICES] loading the business details of %s failed: %d %s", businessID, response.StatusCode, jsonFromService.Message)
+		return platformBranding()
+	}
+
+	var branding BusinessBranding
+	if err := decodeServiceData(jsonFromService.Data, &branding); err != nil || branding.Name == "" {
+		return platformBranding()
+	}
+
+	// only a plain colour ever reaches the pdf and the stylesheet
+	if _, ok := parseHexColor(branding.BrandColor); !ok {
+		branding.BrandColor = defaultBrandColor
+	}
+
+	return branding
+}
+
+// lockInvoice keeps two requests from numbering the same subject twice, which would leave a gap in the sequence,
+// call the returned func to unlock
+func (app *Config) lockInvoice(ctx context.Context, subjectType, subjectID string) (func(), error) {
+	unlock, err := app.acquireLock(ctx, fmt.Sprintf("invoices:lock:%s:%s", subjectType, subjectID), invoiceLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return nil, errors.New("the invoice is being issued, please try again")
+	}
+
+	return unlock, err
+}
+
+// issueInvoice returns the invoice of a subject to one of its parties, or an admin, issuing it the first time
+// it is asked for. Nothing is numbered for anyone else.
+func (app *Config) issueInvoice(ctx context.Context, subjectType, subjectID, userID string, admin bool) (Invoice, error) {
+	invoice, err := app.invoices.Get(ctx, subjectType, subjectID)
+	if err == nil && !admin && !invoice.visibleTo(userID) {
+		return Invoice{}, errNotInvoiceParty
+	}
+	if !errors.Is(err, ErrInvoiceNotFound) {
+		return invoice, err
+	}
+
+	subject, err := app.loadInvoiceSubject(subjectType, subjectID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if !admin && !subject.party(userID) {
+		return Invoice{}, errNotInvoiceParty
+	}
+
+	unlock, err := app.lockInvoice(ctx, subjectType, subjectID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	defer unlock()
+
+	// someone else may have issued it while we waited
+	invoice, err = app.invoices.Get(ctx, subjectType, subjectID)
+	if !errors.Is(err, ErrInvoiceNotFound) {
+		return invoice, err
+	}
+
+	invoice, err = subject.draft()
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	invoice.Business = app.getBusinessBranding(invoice.BusinessID)
+	invoice.IssuedAt = time.Now().UnixMilli()
+
+	return app.invoices.Issue(ctx, invoice)
+}
+
+// formatAmount prints amount with thousands separators, as in NGN 12,500.00
+func formatAmount(currency string, amount float64) string {
+	whole := fmt.Sprintf("%.2f", roundMoney(amount))
+	sign := ""
+	if strings.HasPrefix(whole, "-") {
+		sign, whole = "-", whole[1:]
+	}
+
+	intPart, fraction := whole[:len(whole)-3], whole[len(whole)-3:]
+	var grouped strings.Builder
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+
+	return fmt.Sprintf("%s %s%s%s", currency, sign, grouped.String(), fraction)
+}
+
+func formatQuantity(quantity float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", quantity), "0"), ".")
+}
+
+func (inv Invoice) issuedOn() string {
+	return time.UnixMilli(inv.IssuedAt).UTC().Format("2 Jan 2006")
+}
+
+// renderInvoicePDF lays the invoice out on a single A4 page
+func renderInvoicePDF(inv Invoice) []byte {
+	brand, ok := parseHexColor(inv.Business.BrandColor)
+	if !ok {
+		brand, _ = parseHexColor(defaultBrandColor)
+	}
+
+	const left, right = 40.0, pdfPageWidth - 40
+	page := &pdfPage{}
+
+	// the brand band across the top
+	page.rect(0, pdfPageHeight-90, pdfPageWidth, 90, brand)
+	page.text(left, pdfPageHeight-55, 20, true, pdfWhite, inv.Business.Name)
+	page.textRight(right, pdfPageHeight-55, 20, true, pdfWhite, strings.ToUpper(inv.Title()))
+
+	y := pdfPageHeight - 120
+	for _, detail := range []string{inv.Business.Address, inv.Business.Email, inv.Business.Phone} {
+		if detail == "" {
+			continue
+		}
+		page.text(left, y, 10, false, pdfGrey, detail)
+		y -= 14
+	}
+
+	y = pdfPageHeight - 120
+	details := [][2]string{{"Number", inv.Number}, {"Issued", inv.issuedOn()}, {"Customer", inv.CustomerID}}
+	if inv.Reference != "" {
+		details = append(details, [2]string{"Reference", inv.Reference})
+	}
+	for _, detail := range details {
+		page.textRight(right-150, y, 10, true, pdfBlack, detail[0])
+		page.textRight(right, y, 10, false, pdfBlack, detail[1])
+		y -= 14
+	}
+
+	// the charges
+	y = pdfPageHeight - 230
+	page.rect(left, y-6, right-left, 20, brand)
+	page.text(left+6, y, 10, true, pdfWhite, "Description")
+	page.textRight(right-230, y, 10, true, pdfWhite, "Qty")
+	page.textRight(right-120, y, 10, true, pdfWhite, "Unit price")
+	page.textRight(right-6, y, 10, true, pdfWhite, "Amount")
+
+	for _, line := range inv.Lines {
+		y -= 24
+		page.text(left+6, y, 10, false, pdfBlack, line.Description)
+		page.textRight(right-230, y, 10, false, pdfBlack, formatQuantity(line.Quantity))
+		page.textRight(right-120, y, 10, false, pdfBlack, formatAmount(inv.Currency, line.UnitPrice))
+		page.textRight(right-6, y, 10, false, pdfBlack, formatAmount(inv.Currency, line.Amount))
+	}
+
+	y -= 14
+	page.line(left, y, right, y, pdfGrey)
+
+	totals := [][2]string{{"Subtotal", formatAmount(inv.Currency, inv.Subtotal)}}
+	if inv.SecurityDeposit > 0 {
+		totals = append(totals, [2]string{"Security deposit", formatAmount(inv.Currency, inv.SecurityDeposit)})
+	}
+	for _, total := range totals {
+		y -= 20
+		page.textRight(right-150, y, 10, false, pdfBlack, total[0])
+		page.textRight(right-6, y, 10, false, pdfBlack, total[1])
+	}
+
+	y -= 24
+	page.textRight(right-150, y, 12, true, pdfBlack, "Total paid")
+	page.textRight(right-6, y, 12, true, pdfBlack, formatAmount(inv.Currency, inv.Total))
+
+	page.text(left, 50, 9, false, pdfGrey, fmt.Sprintf("%s %s issued by %s.", inv.Title(), inv.Number, inv.Business.Name))
+
+	return page.bytes()
+}
+
+var invoiceHTML = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"amount":   formatAmount,
+	"quantity": formatQuantity,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #111; margin: 0; }
+.band { background: {{.Business.BrandColor}}; color: #fff; padding: 24px 40px; display: flex; justify-content: space-between; align-items: center; }
+.band img { max-height: 48px; margin-right: 12px; vertical-align: middle; }
+.content { padding: 24px 40px; }
+.muted { color: #666; }
+table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+th { background: {{.Business.BrandColor}}; color: #fff; text-align: left; padding: 6px; }
+td { padding: 8px 6px; border-bottom: 1px solid #eee; }
+.num { text-align: right; }
+.total td { font-weight: bold; border-bottom: none; }
+</style>
+</head>
+<body>
+<div class="band">
+<h1>{{if .Business.LogoURL}}<img src="{{.Business.LogoURL}}" alt="">{{end}}{{.Business.Name}}</h1>
+<h2>{{.Title}}</h2>
+</div>
+<div class="content">
+<p class="muted">{{with .Business.Address}}{{.}}<br>{{end}}{{with .Business.Email}}{{.}}<br>{{end}}{{with .Business.Phone}}{{.}}{{end}}</p>
+<p><strong>Number</strong> {{.Number}}<br><strong>Issued</strong> {{.IssuedOn}}<br><strong>Customer</strong> {{.CustomerID}}{{with .Reference}}<br><strong>Reference</strong> {{.}}{{end}}</p>
+<table>
+<tr><th>Description</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Amount</th></tr>
+{{- range .Lines}}
+<tr><td>{{.Description}}</td><td class="num">{{quantity .Quantity}}</td><td class="num">{{amount $.Currency .UnitPrice}}</td><td class="num">{{amount $.Currency .Amount}}</td></tr>
+{{- end}}
+<tr><td colspan="3" class="num">Subtotal</td><td class="num">{{amount .Currency .Subtotal}}</td></tr>
+{{- if .SecurityDeposit}}
+<tr><td colspan="3" class="num">Security deposit</td><td class="num">{{amount .Currency .SecurityDeposit}}</td></tr>
+{{- end}}
+<tr class="total"><td colspan="3" class="num">Total paid</td><td class="num">{{amount .Currency .Total}}</td></tr>
+</table>
+</div>
+</body>
+</html>
+`))
+
+// renderInvoiceHTML renders the invoice as a standalone page, every value is escaped by the template
+func renderInvoiceHTML(inv Invoice) ([]byte, error) {
+	var page bytes.Buffer
+	err := invoiceHTML.Execute(&page, struct {
+		Invoice
+		IssuedOn string
+	}{inv, inv.issuedOn()})
+
+	return page.Bytes(), err
+}
+
+// renderInvoice renders the invoice in format and says what content type it is
+func renderInvoice(inv Invoice, format string) ([]byte, string, error) {
+	if format == invoiceFormatHTML {
+		document, err := renderInvoiceHTML(inv)
+		return document, "text/html; charset=utf-8", err
+	}
+
+	return renderInvoicePDF(inv), "application/pdf", nil
+}
+
+type InvoicePayload struct {
+	SubjectType string `json:"subject_type"`
+	SubjectID   string `json:"subject_id"`
+	Format      string `json:"format"`
+}
+
+// loadInvoice issues or loads the invoice a request is about, for one of its parties or an admin
+func (app *Config) loadInvoice(ctx context.Context, w http.ResponseWriter, user jsonResponse, req InvoicePayload) (Invoice, bool) {
+	if app.invoices == nil {
+		app.errorJSON(w, errors.New("invoices are not available"), nil, http.StatusServiceUnavailable)
+		return Invoice{}, false
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return Invoice{}, false
+	}
+
+	invoice, err := app.issueInvoice(ctx, req.SubjectType, req.SubjectID, userId, app.isAdminUser(user))
+	if errors.Is(err, errNotInvoiceParty) {
+		app.errorJSON(w, err, nil, http.StatusForbidden)
+		return Invoice{}, false
+	}
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return Invoice{}, false
+	}
+
+	return invoice, true
+}
+
+// DownloadInvoice returns the invoice or receipt of a completed booking, order or subscription payment as a pdf or html file
+func (app *Config) DownloadInvoice(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query params
+	queryParams := r.URL.Query()
+	requestPayload := InvoicePayload{
+		SubjectType: queryParams.Get("subject_type"),
+		SubjectID:   queryParams.Get("subject_id"),
+		Format:      queryParams.Get("format"),
+	}
+
+	validateErrors := app.ValidateInvoiceInput(requestPayload)
+	if len(validateErrors) > 0 {
+		app.errorJSON(w, errors.New("error trying to download invoice"), validateErrors, http.StatusBadRequest)
+		return
+	}
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	invoice, ok := app.loadInvoice(r.Context(), w, user, requestPayload)
+	if !ok {
+		return
+	}
+
+	if requestPayload.Format == "" {
+		requestPayload.Format = invoiceFormatPDF
+	}
+
+	document, contentType, err := renderInvoice(invoice, requestPayload.Format)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", invoice.filename(requestPayload.Format)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(document)
+}
+
+// EmailInvoice sends the invoice or receipt to the caller's email address, with the pdf attached
+func (app *Config) EmailInvoice(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	var requestPayload InvoicePayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	validateErrors := app.ValidateInvoiceInput(requestPayload)
+	if len(validateErrors) > 0 {
+		app.errorJSON(w, errors.New("error trying to email invoice"), validateErrors, http.StatusBadRequest)
+		return
+	}
+
+	invoice, ok := app.loadInvoice(r.Context(), w, user, requestPayload)
+	if !ok {
+		return
+	}
+
+	email := userEmail(user)
+	if email == "" {
+		app.errorJSON(w, errors.New("your account has no email address"), nil)
+		return
+	}
+
+	html, err := renderInvoiceHTML(invoice)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	err = app.sendMail(MailPayload{
+		To:      email,
+		Subject: fmt.Sprintf("Your %s %s from %s", strings.ToLower(invoice.Title()), invoice.Number, invoice.Business.Name),
+		Message: string(html),
+		Data: map[string]interface{}{
+			"invoice_number": invoice.Number,
+			"subject_type":   invoice.SubjectType,
+			"subject_id":     invoice.SubjectID,
+			"total":          invoice.Total,
+		},
+		Attachments: []MailAttachment{{
+			Filename:    invoice.filename(invoiceFormatPDF),
+			ContentType: "application/pdf",
+			Content:     base64.StdEncoding.EncodeToString(renderInvoicePDF(invoice)),
+		}},
+	})
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusBadGateway)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("%s sent to %s", strings.ToLower(invoice.Title()), email),
+		Data:       invoice,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// reminderLead is how long before a booking starts or ends the renter is reminded
+	reminderLead = 24 * time.Hour
+	// subscriptionWarningLead is how long before a renewal the business is warned
+	subscriptionWarningLead = 3 * 24 * time.Hour
+	// unansweredChatAge is how long a chat waits before its receiver is nudged
+	unansweredChatAge = 12 * time.Hour
+	// defaultBookingRequestTTL is how long a pending booking waits for the owner, see BOOKING_REQUEST_TTL_HOURS
+	defaultBookingRequestTTL = 48 * time.Hour
+	// reminderClaimTTL outlives every reminder window so each reminder is sent once
+	reminderClaimTTL = 7 * 24 * time.Hour
+)
+
+// registerJobs adds the broker's scheduled jobs
+func (app *Config) registerJobs() {
+	loc, err := bookingLocation("")
+	if err != nil {
+		loc = time.UTC
+	}
+
+	app.registerUpstreamJob(Job{Name: "booking-reminders", Schedule: Every(15 * time.Minute), Run: app.sendBookingReminders}, "bookings-between")
+	app.registerUpstreamJob(Job{Name: "expire-booking-requests", Schedule: Every(30 * time.Minute), Run: app.expireBookingRequests}, "pending-bookings", "update-booking-status")
+	app.registerUpstreamJob(Job{Name: "subscription-renewal-warnings", Schedule: MustCron("0 8 * * *", loc), Run: app.warnSubscriptionRenewals}, "renewing-subscriptions")
+	app.registerUpstreamJob(Job{Name: "unanswered-chat-nudges", Schedule: MustCron("0 * * * *", loc), Run: app.nudgeUnansweredChats}, "unanswered-chats")
+	app.scheduler.Register(Job{Name: "chat-digests", Schedule: Every(time.Minute), Run: app.notifier.DispatchDue})
+	app.scheduler.Register(Job{Name: "release-deposits", Schedule: Every(30 * time.Minute), Run: app.releaseDueDeposits})
+	app.scheduler.Register(Job{Name: "escalate-stale-disputes", Schedule: Every(time.Hour), Run: app.escalateStaleDisputes})
+	app.scheduler.Register(Job{Name: "run-pending-exports", Schedule: Every(time.Minute), Timeout: exportTimeout, Run: app.runPendingExports})
+}
+
+// registerUpstreamJob registers a job that reads from upstream endpoints, see upstreamEndpoints, only once
+// all of them are switched on so a service without them doesn't fail every slot
+func (app *Config) registerUpstreamJob(job Job, endpoints ...string) {
+	for _, endpoint := range endpoints {
+		if !upstreamEnabled(endpoint) {
+			log.Printf("[JOBS] %s is off, add %s to UPSTREAM_ENDPOINTS once it is deployed", job.Name, endpoint)
+			return
+		}
+	}
+
+	app.scheduler.Register(job)
+}
+
+// bookingRequestTTL reads BOOKING_REQUEST_TTL_HOURS
+func bookingRequestTTL() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("BOOKING_REQUEST_TTL_HOURS"))
+	if err != nil || hours <= 0 {
+		return defaultBookingRequestTTL
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+// bookingsBetween loads the bookings in statuses whose start_date or end_date (field) falls between from and to
+func (app *Config) bookingsBetween(statuses []BookingStatus, field string, from, to time.Time) ([]BookingSnapshot, error) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "bookings-between"), map[string]interface{}{
+		"statuses": statuses,
+		"field":    field,
+		"from":     from.Format("2006-01-02"),
+		"to":       to.Format("2006-01-02"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var bookings []BookingSnapshot
+	err = decodeServiceData(jsonFromService.Data, &bookings)
+
+	return bookings, err
+}
+
+// sendBookingReminders reminds renters a day before their booking starts or ends
+func (app *Config) sendBookingReminders(ctx context.Context) error {
+	now := time.Now()
+
+	starting, err := app.bookingsBetween([]BookingStatus{BookingAccepted}, "start_date", now, now.Add(reminderLead))
+	if err != nil {
+		return err
+	}
+
+	for _, booking := range starting {
+		window, errs := booking.Times().Window()
+		if len(errs) > 0 || !dueForReminder(window.Start, now) {
+			continue
+		}
+
+		app.remind(ctx, "start:"+booking.ID, booking.RenterID,
+			"Your booking starts soon",
+			fmt.Sprintf("Your booking starts on %s", window.Start.Format("Mon 2 Jan 15:04")),
+			map[string]interface{}{"booking_id": booking.ID, "inventory_id": booking.InventoryID},
+		)
+	}
+
+	ending, err := app.bookingsBetween([]BookingStatus{BookingActive}, "end_date", now, now.Add(reminderLead))
+	if err != nil {
+		return err
+	}
+
+	for _, booking := range ending {
+		window, errs := booking.Times().Window()
+		if len(errs) > 0 || !dueForReminder(window.End, now) {
+			continue
+		}
+
+		app.remind(ctx, "end:"+booking.ID, booking.RenterID,
+			"Your booking ends soon",
+			fmt.Sprintf("Your booking ends on %s, please arrange the return", window.End.Format("Mon 2 Jan 15:04")),
+			map[string]interface{}{"booking_id": booking.ID, "inventory_id": booking.InventoryID},
+		)
+	}
+
+	return nil
+}
+
+// dueForReminder reports whether at is within reminderLead of now
+func dueForReminder(at, now time.Time) bool {
+	return at.After(now) && !at.After(now.Add(reminderLead))
+}
+
+// remind notifies userID once per key
+func (app *Config) remind(ctx context.Context, key, userID, title, body string, data map[string]interface{}) {
+	if userID == "" || app.notifications == nil {
+		return
+	}
+
+	if !app.scheduler.Once(ctx, "jobs:reminded:"+key, reminderClaimTTL) {
+		return
+	}
+
+	err := app.notifications.Notify(ctx, Notification{
+		ID:        fmt.Sprintf("reminder:%s:%s", key, userID),
+		UserID:    userID,
+		Type:      NotificationReminder,
+		Title:     title,
+		Body:      body,
+		Data:      data,
+		CreatedAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Printf("[JOBS] reminding %s about %s failed: %v", userID, key, err)
+	}
+}
+
+// expireBookingRequests expires pending bookings the owner did not answer in time
+func (app *Config) expireBookingRequests(ctx context.Context) error {
+	cutoff := time.Now().Add(-bookingRequestTTL())
+
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "pending-bookings"), map[string]interface{}{
+		"created_before": cutoff.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	var bookings []BookingSnapshot
+	err = decodeServiceData(jsonFromService.Data, &bookings)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, booking := range bookings {
+		if err := validateBookingTransition(ExpireBookingAction, booking, bookingSystem, "", ""); err != nil {
+			continue
+		}
+
+		_, err := app.applyBookingTransition(ctx, ExpireBookingAction, booking, bookingSystem, "", "the owner did not answer in time", "")
+		if err != nil {
+			log.Printf("[JOBS] expiring booking %s failed: %v", booking.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d booking requests could not be expired", failed, len(bookings))
+	}
+
+	return nil
+}
+
+// RenewingSubscription is a subscription the payment service will renew soon
+type RenewingSubscription struct {
+	UserID   string    `json:"user_id"`
+	Email    string    `json:"email"`
+	PlanID   string    `json:"plan_id"`
+	PlanName string    `json:"plan_name"`
+	Amount   float64   `json:"amount"`
+	RenewsAt time.Time `json:"renews_at"`
+}
+
+// warnSubscriptionRenewals warns businesses a few days before their subscription renews
+func (app *Config) warnSubscriptionRenewals(ctx context.Context) error {
+	now := time.Now()
+
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("PAYMENT_SERVICE_URL"), "renewing-subscriptions"), map[string]interface{}{
+		"from": now.Format(time.RFC3339),
+		"to":   now.Add(subscriptionWarningLead).Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	var subscriptions []RenewingSubscription
+	err = decodeServiceData(jsonFromService.Data, &subscriptions)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		key := fmt.Sprintf("jobs:renewal-warned:%s:%s", subscription.UserID, subscription.RenewsAt.Format("2006-01-02"))
+		if !app.scheduler.Once(ctx, key, reminderClaimTTL) {
+			continue
+		}
+
+		body := fmt.Sprintf("Your %s subscription renews on %s for %.2f", subscription.PlanName, subscription.RenewsAt.Format("2 Jan 2006"), subscription.Amount)
+
+		if app.notifications != nil {
+			err := app.notifications.Notify(ctx, Notification{
+				ID:        "renewal:" + key,
+				UserID:    subscription.UserID,
+				Type:      NotificationSubscription,
+				Title:     "Subscription renews soon",
+				Body:      body,
+				Data:      map[string]interface{}{"plan_id": subscription.PlanID, "renews_at": subscription.RenewsAt},
+				CreatedAt: now.UnixMilli(),
+			})
+			if err != nil {
+				log.Printf("[JOBS] renewal notification for %s failed: %v", subscription.UserID, err)
+			}
+		}
+
+		if subscription.Email != "" {
+			err := app.sendMail(MailPayload{
+				To:      subscription.Email,
+				Subject: "Your subscription renews soon",
+				Message: body,
+			})
+			if err != nil {
+				log.Printf("[JOBS] renewal email for %s failed: %v", subscription.UserID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// UnansweredChat is the latest message of a conversation its receiver has not replied to
+type UnansweredChat struct {
+	MessageID string    `json:"message_id"`
+	Sender    string    `json:"sender"`
+	Receiver  string    `json:"receiver"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// nudgeUnansweredChats reminds owners of chats they left unanswered
+func (app *Config) nudgeUnansweredChats(ctx context.Context) error {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "unanswered-chats"), map[string]interface{}{
+		"older_than": time.Now().Add(-unansweredChatAge).Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	var chats []UnansweredChat
+	err = decodeServiceData(jsonFromService.Data, &chats)
+	if err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		app.remind(ctx, "chat:"+chat.MessageID, chat.Receiver,
+			"You have an unanswered message",
+			"Someone is waiting for your reply",
+			map[string]interface{}{"sender": chat.Sender, "message_id": chat.MessageID},
+		)
+	}
+
+	return nil
+}
+
+// GetJobs lists the scheduled jobs as this replica sees them
+func (app *Config) GetJobs(w http.ResponseWriter, r *http.Request) {
+	if !app.verifyAdmin(w, r) {
+		return
+	}
+
+	if app.scheduler == nil {
+		app.errorJSON(w, errors.New("the scheduler is not running"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "scheduled jobs retrieved",
+		Data:       app.scheduler.Statuses(),
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
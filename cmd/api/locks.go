@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// errLockHeld is returned by acquireLock when someone else holds the lock
+var errLockHeld = errors.New("lock is held")
+
+// releaseLockScript only deletes the lock while it still holds our token, a lock that expired
+// and was taken by someone else is left alone
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// acquireLock takes the redis lock at key for ttl, call the returned func to release it
+func (app *Config) acquireLock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	token := uuid.NewString()
+	locked, err := app.cache.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, errLockHeld
+	}
+
+	return func() {
+		err := releaseLockScript.Run(context.Background(), app.cache, []string{key}, token).Err()
+		if err != nil {
+			log.Printf("[LOCK] releasing %s failed: %v", key, err)
+		}
+	}, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireLock(t *testing.T) {
+	ctx := context.Background()
+	app := Config{cache: newTestRedis(t)}
+
+	t.Log("Checking a held lock can not be taken again")
+	unlock, err := app.acquireLock(ctx, "test:lock", time.Minute)
+	assert.NoError(t, err)
+	_, err = app.acquireLock(ctx, "test:lock", time.Minute)
+	assert.ErrorIs(t, err, errLockHeld)
+
+	t.Log("Checking a lock that expired and was taken by someone else is not released by its old owner")
+	app.cache.Del(ctx, "test:lock")
+	_, err = app.acquireLock(ctx, "test:lock", time.Minute)
+	assert.NoError(t, err)
+	unlock()
+	_, err = app.acquireLock(ctx, "test:lock", time.Minute)
+	assert.ErrorIs(t, err, errLockHeld)
+
+	t.Log("Checking the owner releases its lock")
+	app.cache.Del(ctx, "test:lock")
+	unlock, err = app.acquireLock(ctx, "test:lock", time.Minute)
+	assert.NoError(t, err)
+	unlock()
+	_, err = app.acquireLock(ctx, "test:lock", time.Minute)
+	assert.NoError(t, err)
+}
@@ -91,6 +91,22 @@ var GCCountGauge = promauto.NewCounter(
 	},
 )
 
+// RabbitConnectionState tracks the rabbitmq connection (0 disconnected, 1 connecting, 2 connected).
+var RabbitConnectionState = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "rabbitmq_connection_state",
+		Help: "RabbitMQ connection state (0 disconnected, 1 connecting, 2 connected)",
+	},
+)
+
+// RabbitReconnects tracks how many times the rabbitmq connection was re-established.
+var RabbitReconnects = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "rabbitmq_reconnects",
+		Help: "Number of times the RabbitMQ connection was re-established",
+	},
+)
+
 // CollectSystemMetrics periodically collects system-level metrics like CPU and memory usage.
 func CollectSystemMetrics() {
 	for {
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxNotificationsPerUser bounds how many notifications are kept, the oldest are dropped first
+const maxNotificationsPerUser = 200
+
+// NotificationStore keeps per-user notifications and their preferences
+type NotificationStore interface {
+	// Add stores n, adding a notification with an existing id replaces it
+	Add(ctx context.Context, n Notification) error
+	// List returns a page of notifications, newest first, and the total count
+	List(ctx context.Context, userID string, offset, limit int) ([]Notification, int, error)
+	MarkRead(ctx context.Context, userID string, ids []string) error
+	MarkAllRead(ctx context.Context, userID string) error
+	UnreadCount(ctx context.Context, userID string) (int, error)
+	Preferences(ctx context.Context, userID string) (NotificationPreferences, error)
+	SavePreferences(ctx context.Context, userID string, prefs NotificationPreferences) error
+}
+
+// redisNotificationStore keeps notifications in a hash, ordered by a sorted set,
+// with the unread ones in a set
+type redisNotificationStore struct {
+	cache *redis.Client
+}
+
+func notificationKey(userID, suffix string) string {
+	return fmt.Sprintf("notifications:%s:%s", userID, suffix)
+}
+
+func (s redisNotificationStore) Add(ctx context.Context, n Notification) error {
+	rawData, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	items := notificationKey(n.UserID, "items")
+	index := notificationKey(n.UserID, "index")
+	unread := notificationKey(n.UserID, "unread")
+
+	pipe := s.cache.TxPipeline()
+	pipe.HSet(ctx, items, n.ID, rawData)
+	pipe.ZAdd(ctx, index, redis.Z{Score: float64(n.CreatedAt), Member: n.ID})
+	if !n.Read {
+		pipe.SAdd(ctx, unread, n.ID)
+	}
+	size := pipe.ZCard(ctx, index)
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	excess := size.Val() - maxNotificationsPerUser
+	if excess <= 0 {
+		return nil
+	}
+
+	oldest, err := s.cache.ZRange(ctx, index, 0, excess-1).Result()
+	if err != nil || len(oldest) == 0 {
+		return err
+	}
+
+	members := make([]interface{}, len(oldest))
+	for i, id := range oldest {
+		members[i] = id
+	}
+
+	pipe = s.cache.TxPipeline()
+	pipe.ZRem(ctx, index, members...)
+	pipe.HDel(ctx, items, oldest...)
+	pipe.SRem(ctx, unread, members...)
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+func (s redisNotificationStore) List(ctx context.Context, userID string, offset, limit int) ([]Notification, int, error) {
+	index := notificationKey(userID, "index")
+
+	total, err := s.cache.ZCard(ctx, index).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids, err := s.cache.ZRevRange(ctx, index, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	notifications := []Notification{}
+	if len(ids) == 0 {
+		return notifications, int(total), nil
+	}
+
+	rawItems, err := s.cache.HMGet(ctx, notificationKey(userID, "items"), ids...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+
+	unread, err := s.cache.SMIsMember(ctx, notificationKey(userID, "unread"), members...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i, raw := range rawItems {
+		rawString, ok := raw.(string)
+		if !ok {
+			continue
+		}
+
+		var n Notification
+		if err := json.Unmarshal([]byte(rawString), &n); err != nil {
+			continue
+		}
+
+		n.Read = !unread[i]
+		notifications = append(notifications, n)
+	}
+
+	return notifications, int(total), nil
+}
+
+func (s redisNotificationStore) MarkRead(ctx context.Context, userID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+
+	return s.cache.SRem(ctx, notificationKey(userID, "unread"), members...).Err()
+}
+
+func (s redisNotificationStore) MarkAllRead(ctx context.Context, userID string) error {
+	return s.cache.Del(ctx, notificationKey(userID, "unread")).Err()
+}
+
+func (s redisNotificationStore) UnreadCount(ctx context.Context, userID string) (int, error) {
+	count, err := s.cache.SCard(ctx, notificationKey(userID, "unread")).Result()
+	return int(count), err
+}
+
+func (s redisNotificationStore) Preferences(ctx context.Context, userID string) (NotificationPreferences, error) {
+	raw, err := s.cache.HGetAll(ctx, notificationKey(userID, "preferences")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := defaultNotificationPreferences()
+	for notificationType, enabled := range raw {
+		prefs[notificationType] = enabled == "1"
+	}
+
+	return prefs, nil
+}
+
+func (s redisNotificationStore) SavePreferences(ctx context.Context, userID string, prefs NotificationPreferences) error {
+	values := make(map[string]interface{}, len(prefs))
+	for notificationType, enabled := range prefs {
+		values[notificationType] = "0"
+		if enabled {
+			values[notificationType] = "1"
+		}
+	}
+
+	return s.cache.HSet(ctx, notificationKey(userID, "preferences"), values).Err()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// memoryNotificationStore is a stand-in store used in tests
+type memoryNotificationStore struct {
+	mu            sync.Mutex
+	notifications map[string]map[string]Notification
+	preferences   map[string]NotificationPreferences
+}
+
+func newMemoryNotificationStore() *memoryNotificationStore {
+	return &memoryNotificationStore{
+		notifications: make(map[string]map[string]Notification),
+		preferences:   make(map[string]NotificationPreferences),
+	}
+}
+
+func (s *memoryNotificationStore) Add(ctx context.Context, n Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.notifications[n.UserID] == nil {
+		s.notifications[n.UserID] = make(map[string]Notification)
+	}
+	s.notifications[n.UserID][n.ID] = n
+
+	all := s.sorted(n.UserID)
+	for _, old := range all[min(len(all), maxNotificationsPerUser):] {
+		delete(s.notifications[n.UserID], old.ID)
+	}
+
+	return nil
+}
+
+// sorted returns the notifications of userID newest first, s.mu must be held
+func (s *memoryNotificationStore) sorted(userID string) []Notification {
+	all := make([]Notification, 0, len(s.notifications[userID]))
+	for _, n := range s.notifications[userID] {
+		all = append(all, n)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].CreatedAt > all[j].CreatedAt
+	})
+
+	return all
+}
+
+func (s *memoryNotificationStore) List(ctx context.Context, userID string, offset, limit int) ([]Notification, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := s.sorted(userID)
+	if offset >= len(all) {
+		return []Notification{}, len(all), nil
+	}
+
+	return all[offset:min(len(all), offset+limit)], len(all), nil
+}
+
+func (s *memoryNotificationStore) MarkRead(ctx context.Context, userID string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if n, ok := s.notifications[userID][id]; ok {
+			n.Read = true
+			s.notifications[userID][id] = n
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryNotificationStore) MarkAllRead(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, n := range s.notifications[userID] {
+		n.Read = true
+		s.notifications[userID][id] = n
+	}
+
+	return nil
+}
+
+func (s *memoryNotificationStore) UnreadCount(ctx context.Context, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, n := range s.notifications[userID] {
+		if !n.Read {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *memoryNotificationStore) Preferences(ctx context.Context, userID string) (NotificationPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefs := defaultNotificationPreferences()
+	for notificationType, enabled := range s.preferences[userID] {
+		prefs[notificationType] = enabled
+	}
+
+	return prefs, nil
+}
+
+func (s *memoryNotificationStore) SavePreferences(ctx context.Context, userID string, prefs NotificationPreferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.preferences[userID] == nil {
+		s.preferences[userID] = NotificationPreferences{}
+	}
+	for notificationType, enabled := range prefs {
+		s.preferences[userID][notificationType] = enabled
+	}
+
+	return nil
+}
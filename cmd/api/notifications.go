@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/obynonwane/broker-service/event"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// notificationsQueue is shared by every broker instance so each event is stored once
+const notificationsQueue = "broker.notifications"
+
+// notification types, users can switch each of them off
+const (
+	NotificationBookingRequest  = "booking_request"
+	NotificationBookingStatus   = "booking_status"
+	NotificationPurchaseRequest = "purchase_request"
+	NotificationOrderStatus     = "order_status"
+	NotificationRatingReply     = "rating_reply"
+	NotificationSubscription    = "subscription"
+	NotificationReminder        = "reminder" // booking and chat reminders sent by scheduled jobs
+	NotificationDeposit         = "deposit"
+	NotificationDispute         = "dispute"
+	NotificationExport          = "export" // a background export finished
+)
+
+var notificationTypes = []string{
+	NotificationBookingRequest,
+	NotificationBookingStatus,
+	NotificationPurchaseRequest,
+	NotificationOrderStatus,
+	NotificationRatingReply,
+	NotificationSubscription,
+	NotificationReminder,
+	NotificationDeposit,
+	NotificationDispute,
+	NotificationExport,
+}
+
+// Notification is a single entry in a user's notification center
+type Notification struct {
+	ID        string                 `json:"id"`
+	UserID    string                 `json:"user_id"`
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Body      string                 `json:"body"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Read      bool                   `json:"read"`
+	CreatedAt int64                  `json:"created_at"`
+}
+
+// NotificationPreferences maps a notification type to whether the user wants it
+type NotificationPreferences map[string]bool
+
+func defaultNotificationPreferences() NotificationPreferences {
+	prefs := NotificationPreferences{}
+	for _, notificationType := range notificationTypes {
+		prefs[notificationType] = true
+	}
+
+	return prefs
+}
+
+// NotificationCenter turns domain events into notifications
+type NotificationCenter struct {
+	store NotificationStore
+}
+
+func NewNotificationCenter(store NotificationStore) *NotificationCenter {
+	return &NotificationCenter{store: store}
+}
+
+// Notify stores n unless the user switched its type off
+func (c *NotificationCenter) Notify(ctx context.Context, n Notification) error {
+	if n.UserID == "" {
+		return nil
+	}
+
+	prefs, err := c.store.Preferences(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+
+	if enabled, ok := prefs[n.Type]; ok && !enabled {
+		return nil
+	}
+
+	return c.store.Add(ctx, n)
+}
+
+// newNotification builds a notification for e.
+// The id comes from the event so a redelivered event replaces its notification instead of duplicating it.
+func newNotification(e event.Envelope, userID, notificationType, title, body string, data map[string]interface{}) Notification {
+	id := uuid.NewString()
+	if e.ID != "" {
+		id = fmt.Sprintf("%s:%s", e.ID, userID)
+	}
+
+	createdAt := e.OccurredAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	return Notification{
+		ID:        id,
+		UserID:    userID,
+		Type:      notificationType,
+		Title:     title,
+		Body:      body,
+		Data:      data,
+		CreatedAt: createdAt.UnixMilli(),
+	}
+}
+
+func (c *NotificationCenter) onBookingCreated(ctx context.Context, e event.Envelope, booking event.BookingCreatedEvent) error {
+	return c.Notify(ctx, newNotification(e, booking.OwnerID, NotificationBookingRequest,
+		"New booking request",
+		fmt.Sprintf("You have a new booking request from %s to %s", booking.StartDate, booking.EndDate),
+		map[string]interface{}{"booking_id": booking.BookingID, "inventory_id": booking.InventoryID},
+	))
+}
+
+func (c *NotificationCenter) onBookingStatusChanged(ctx context.Context, e event.Envelope, booking event.BookingStatusChangedEvent) error {
+	// tell the other party of the booking
+	recipient := booking.RenterID
+	if booking.ChangedBy == booking.RenterID {
+		recipient = booking.OwnerID
+	}
+
+	return c.Notify(ctx, newNotification(e, recipient, NotificationBookingStatus,
+		"Booking updated",
+		fmt.Sprintf("A booking was %s", booking.To),
+		map[string]interface{}{"booking_id": booking.BookingID, "status": booking.To, "reason_code": booking.ReasonCode},
+	))
+}
+
+func (c *NotificationCenter) onBookingChangeUpdated(ctx context.Context, e event.Envelope, change event.BookingChangeUpdatedEvent) error {
+	// tell the other party of the booking
+	recipient := change.RenterID
+	if change.ChangedBy == change.RenterID {
+		recipient = change.OwnerID
+	}
+
+	title := "Booking change updated"
+	if change.Status == string(ChangePending) {
+		title = "Booking change requested"
+	}
+
+	return c.Notify(ctx, newNotification(e, recipient, NotificationBookingStatus,
+		title,
+		fmt.Sprintf("A booking %s to %s - %s is %s", change.Kind, change.StartDate, change.EndDate, change.Status),
+		map[string]interface{}{"booking_id": change.BookingID, "change_id": change.ChangeID, "status": change.Status, "price_delta": change.PriceDelta},
+	))
+}
+
+func (c *NotificationCenter) onDepositUpdated(ctx context.Context, e event.Envelope, deposit event.DepositUpdatedEvent) error {
+	// tell the other party, the renter hears about what the owner or the scheduler did
+	recipient := deposit.RenterID
+	if deposit.ChangedBy == deposit.RenterID {
+		recipient = deposit.OwnerID
+	}
+
+	body := fmt.Sprintf("The %.2f deposit of a booking is now %s", deposit.Amount, strings.ReplaceAll(deposit.Status, "_", " "))
+	if deposit.Status == string(DepositDeducted) {
+		body = fmt.Sprintf("%.2f was deducted from a deposit and %.2f refunded: %s", deposit.DeductedAmount, deposit.RefundedAmount, deposit.Reason)
+	}
+
+	return c.Notify(ctx, newNotification(e, recipient, NotificationDeposit,
+		"Deposit updated",
+		body,
+		map[string]interface{}{"booking_id": deposit.BookingID, "status": deposit.Status},
+	))
+}
+
+func (c *NotificationCenter) onDisputeUpdated(ctx context.Context, e event.Envelope, dispute event.DisputeUpdatedEvent) error {
+	title, body := "Dispute updated", fmt.Sprintf("A dispute on your %s is now %s", dispute.SubjectType, strings.ReplaceAll(dispute.Status, "_", " "))
+	switch dispute.Action {
+	case disputeOpenedAction:
+		title, body = "New dispute", fmt.Sprintf("A dispute was opened on your %s", dispute.SubjectType)
+	case disputeMessageAction:
+		title, body = "New dispute message", fmt.Sprintf("There is a new message on a dispute about your %s", dispute.SubjectType)
+	case disputeResolvedAction:
+		title, body = "Dispute resolved", fmt.Sprintf("A dispute on your %s was resolved: %s", dispute.SubjectType, strings.ReplaceAll(dispute.Outcome, "_", " "))
+	}
+
+	// both parties hear about it, except whoever made the change
+	var errs []error
+	for _, recipient := range []string{dispute.ClaimantID, dispute.RespondentID} {
+		if recipient == dispute.ChangedBy {
+			continue
+		}
+
+		errs = append(errs, c.Notify(ctx, newNotification(e, recipient, NotificationDispute,
+			title,
+			body,
+			map[string]interface{}{"dispute_id": dispute.DisputeID, "subject_type": dispute.SubjectType, "subject_id": dispute.SubjectID, "status": dispute.Status},
+		)))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *NotificationCenter) onOrderCreated(ctx context.Context, e event.Envelope, order event.OrderCreatedEvent) error {
+	return c.Notify(ctx, newNotification(e, order.SellerID, NotificationPurchaseRequest,
+		"New purchase request",
+		fmt.Sprintf("You have a new purchase request for %g unit(s)", order.Quantity),
+		map[string]interface{}{"order_id": order.OrderID, "inventory_id": order.InventoryID},
+	))
+}
+
+func (c *NotificationCenter) onOrderStatusChanged(ctx context.Context, e event.Envelope, order event.OrderStatusChangedEvent) error {
+	// the seller hears about what the buyer did, the buyer about everything else
+	recipient := order.BuyerID
+	if order.ChangedBy != "" && order.ChangedBy == order.BuyerID {
+		recipient = order.SellerID
+	}
+
+	return c.Notify(ctx, newNotification(e, recipient, NotificationOrderStatus,
+		"Order updated",
+		fmt.Sprintf("An order is now %s", strings.ReplaceAll(order.Status, "_", " ")),
+		map[string]interface{}{"order_id": order.OrderID, "status": order.Status},
+	))
+}
+
+func (c *NotificationCenter) onRatingReplied(ctx context.Context, e event.Envelope, reply event.RatingRepliedEvent) error {
+	// nobody needs to hear about their own reply
+	if reply.RaterID == reply.ReplierID {
+		return nil
+	}
+
+	return c.Notify(ctx, newNotification(e, reply.RaterID, NotificationRatingReply,
+		"New reply to your rating",
+		reply.Comment,
+		map[string]interface{}{"rating_id": reply.RatingID, "reply_id": reply.ReplyID, "kind": reply.Kind},
+	))
+}
+
+func (c *NotificationCenter) onSubscriptionChanged(ctx context.Context, e event.Envelope, subscription event.SubscriptionChangedEvent) error {
+	return c.Notify(ctx, newNotification(e, subscription.UserID, NotificationSubscription,
+		"Subscription updated",
+		fmt.Sprintf("Your subscription was %s", subscription.Status),
+		map[string]interface{}{"status": subscription.Status},
+	))
+}
+
+// consumeNotificationEvents is run by the supervisor on every (re)connect
+func (app *Config) consumeNotificationEvents(conn *amqp.Connection) error {
+	consumer, err := event.NewConsumer(conn, event.ConsumerConfig{QueueName: notificationsQueue})
+	if err != nil {
+		return err
+	}
+
+	register := func(eventType event.EventType, handler event.HandlerFunc) {
+		handler = event.Deduplicate(redisIDStore{cache: app.cache, ttl: 24 * time.Hour}, handler)
+		consumer.Register(eventType, handler)
+	}
+
+	register(event.BookingCreated, event.Typed(app.notifications.onBookingCreated))
+	register(event.BookingStatusChanged, event.Typed(app.notifications.onBookingStatusChanged))
+	register(event.BookingChangeUpdated, event.Typed(app.notifications.onBookingChangeUpdated))
+	register(event.DepositUpdated, event.Typed(app.notifications.onDepositUpdated))
+	register(event.DisputeUpdated, event.Typed(app.notifications.onDisputeUpdated))
+	register(event.OrderCreated, event.Typed(app.notifications.onOrderCreated))
+	register(event.OrderStatusChanged, event.Typed(app.notifications.onOrderStatusChanged))
+	register(event.RatingReplied, event.Typed(app.notifications.onRatingReplied))
+	register(event.SubscriptionChanged, event.Typed(app.notifications.onSubscriptionChanged))
+
+	go func() {
+		err := consumer.Listen(context.Background(), consumer.Topics())
+		log.Printf("[NOTIFICATIONS] consumer stopped: %v", err)
+	}()
+
+	return nil
+}
+
+type MarkNotificationsReadPayload struct {
+	IDs []string `json:"ids"`
+}
+
+type UpdateNotificationPreferencesPayload struct {
+	Preferences map[string]bool `json:"preferences"`
+}
+
+// notificationUser verifies the token and returns the logged in user id, it writes the error response itself
+func (app *Config) notificationUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return "", false
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return "", false
+	}
+
+	if app.notifications == nil {
+		app.errorJSON(w, errors.New("notifications are unavailable"), nil, http.StatusServiceUnavailable)
+		return "", false
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return "", false
+	}
+
+	return userId, true
+}
+
+func (app *Config) GetNotifications(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query param, page and limit are optional
+	page, limit := 1, 20
+	queryParams := r.URL.Query()
+	if pageStr := queryParams.Get("page"); pageStr != "" {
+		p, err := strconv.Atoi(pageStr)
+		if err != nil || p < 1 {
+			app.errorJSON(w, errors.New("invalid page number"), nil)
+			return
+		}
+		page = p
+	}
+
+	if limitStr := queryParams.Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 || l > 100 {
+			app.errorJSON(w, errors.New("invalid limit number"), nil)
+			return
+		}
+		limit = l
+	}
+
+	userId, ok := app.notificationUser(w, r)
+	if !ok {
+		return
+	}
+
+	notifications, total, err := app.notifications.store.List(r.Context(), userId, (page-1)*limit, limit)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "notifications retrieved",
+		Data: map[string]interface{}{
+			"notifications": notifications,
+			"total":         total,
+			"page":          page,
+			"limit":         limit,
+		},
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) GetUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+
+	userId, ok := app.notificationUser(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := app.notifications.store.UnreadCount(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "unread notification count retrieved",
+		Data:       map[string]int{"count": count},
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+
+	userId, ok := app.notificationUser(w, r)
+	if !ok {
+		return
+	}
+
+	//extract the request body
+	var requestPayload MarkNotificationsReadPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if len(requestPayload.IDs) == 0 {
+		app.errorJSON(w, errors.New("ids not supplied"), nil)
+		return
+	}
+
+	err = app.notifications.store.MarkRead(r.Context(), userId, requestPayload.IDs)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "notifications marked as read",
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+
+	userId, ok := app.notificationUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.notifications.store.MarkAllRead(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "all notifications marked as read",
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+
+	userId, ok := app.notificationUser(w, r)
+	if !ok {
+		return
+	}
+
+	prefs, err := app.notifications.store.Preferences(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "notification preferences retrieved",
+		Data:       prefs,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+
+	userId, ok := app.notificationUser(w, r)
+	if !ok {
+		return
+	}
+
+	//extract the request body
+	var requestPayload UpdateNotificationPreferencesPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateNotificationPreferencesInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to update notification preferences"), err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.notifications.store.SavePreferences(r.Context(), userId, requestPayload.Preferences)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	prefs, err := app.notifications.store.Preferences(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "notification preferences updated",
+		Data:       prefs,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
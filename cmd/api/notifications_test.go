@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/obynonwane/broker-service/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationCenter(t *testing.T) {
+	for name, store := range map[string]NotificationStore{
+		"memory": newMemoryNotificationStore(),
+		"redis":  redisNotificationStore{cache: newTestRedis(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			center := NewNotificationCenter(store)
+
+			envelope, err := event.NewEnvelope("test", event.BookingCreatedEvent{BookingID: "booking-1", OwnerID: "owner-1"}, "")
+			assert.NoError(t, err)
+
+			booking := event.BookingCreatedEvent{BookingID: "booking-1", OwnerID: "owner-1"}
+
+			t.Log("Checking a booking notifies the owner once, even when redelivered")
+			assert.NoError(t, center.onBookingCreated(ctx, envelope, booking))
+			assert.NoError(t, center.onBookingCreated(ctx, envelope, booking))
+
+			notifications, total, err := store.List(ctx, "owner-1", 0, 10)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, total)
+			assert.Equal(t, NotificationBookingRequest, notifications[0].Type)
+			assert.Equal(t, "booking-1", notifications[0].Data["booking_id"])
+
+			count, _ := store.UnreadCount(ctx, "owner-1")
+			assert.Equal(t, 1, count)
+
+			t.Log("Checking mark all read clears the unread count")
+			assert.NoError(t, store.MarkAllRead(ctx, "owner-1"))
+			count, _ = store.UnreadCount(ctx, "owner-1")
+			assert.Equal(t, 0, count)
+
+			t.Log("Checking a switched off type is not stored")
+			assert.NoError(t, store.SavePreferences(ctx, "buyer-1", NotificationPreferences{NotificationOrderStatus: false}))
+
+			envelope, err = event.NewEnvelope("test", event.OrderStatusChangedEvent{OrderID: "order-1", BuyerID: "buyer-1"}, "")
+			assert.NoError(t, err)
+			assert.NoError(t, center.onOrderStatusChanged(ctx, envelope, event.OrderStatusChangedEvent{OrderID: "order-1", BuyerID: "buyer-1", Status: "shipped"}))
+
+			_, total, _ = store.List(ctx, "buyer-1", 0, 10)
+			assert.Equal(t, 0, total)
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/obynonwane/broker-service/event"
+)
+
+type OrderStatus string
+
+const (
+	OrderPending        OrderStatus = "pending"
+	OrderAccepted       OrderStatus = "accepted"
+	OrderDeclined       OrderStatus = "declined"
+	OrderShipped        OrderStatus = "shipped"
+	OrderReadyForPickup OrderStatus = "ready_for_pickup"
+	OrderCompleted      OrderStatus = "completed" // the buyer confirmed they received it
+	OrderCancelled      OrderStatus = "cancelled"
+)
+
+var orderStatuses = []OrderStatus{OrderPending, OrderAccepted, OrderDeclined, OrderShipped, OrderReadyForPickup, OrderCompleted, OrderCancelled}
+
+// the party of an order allowed to perform an action
+const (
+	orderBuyer  = "buyer"
+	orderSeller = "seller"
+)
+
+// how an order reaches the buyer, the fulfilment actions need its details
+const (
+	orderShipping = "shipping"
+	orderPickup   = "pickup"
+)
+
+// OrderAction is a purchase order transition a user can request
+type OrderAction struct {
+	Name        string
+	From        []OrderStatus
+	To          OrderStatus
+	Actor       string
+	ReasonCodes []string // empty means no reason is needed
+	Fulfilment  string   // empty, orderShipping or orderPickup
+}
+
+var (
+	AcceptOrderAction = OrderAction{
+		Name:  "accept",
+		From:  []OrderStatus{OrderPending},
+		To:    OrderAccepted,
+		Actor: orderSeller,
+	}
+	DeclineOrderAction = OrderAction{
+		Name:        "decline",
+		From:        []OrderStatus{OrderPending},
+		To:          OrderDeclined,
+		Actor:       orderSeller,
+		ReasonCodes: []string{"out_of_stock", "price_changed", "cannot_deliver", ReasonOther},
+	}
+	ShipOrderAction = OrderAction{
+		Name:       "ship",
+		From:       []OrderStatus{OrderAccepted},
+		To:         OrderShipped,
+		Actor:      orderSeller,
+		Fulfilment: orderShipping,
+	}
+	ReadyForPickupOrderAction = OrderAction{
+		Name:       "mark ready for pickup",
+		From:       []OrderStatus{OrderAccepted},
+		To:         OrderReadyForPickup,
+		Actor:      orderSeller,
+		Fulfilment: orderPickup,
+	}
+	ConfirmReceivedOrderAction = OrderAction{
+		Name:  "confirm receipt of",
+		From:  []OrderStatus{OrderShipped, OrderReadyForPickup},
+		To:    OrderCompleted,
+		Actor: orderBuyer,
+	}
+	// CancelOrderAction is the buyer's cancel, before anything was sent
+	CancelOrderAction = OrderAction{
+		Name:        "cancel",
+		From:        []OrderStatus{OrderPending, OrderAccepted},
+		To:          OrderCancelled,
+		Actor:       orderBuyer,
+		ReasonCodes: []string{"change_of_plans", "found_alternative", "seller_unresponsive", ReasonOther},
+	}
+	// SellerCancelOrderAction lets the seller back out of an accepted order, a pending one is declined instead
+	SellerCancelOrderAction = OrderAction{
+		Name:        "cancel",
+		From:        []OrderStatus{OrderAccepted},
+		To:          OrderCancelled,
+		Actor:       orderSeller,
+		ReasonCodes: []string{"out_of_stock", "cannot_deliver", "buyer_unresponsive", ReasonOther},
+	}
+)
+
+// OrderSnapshot is the part of a purchase order the broker's rules need
+type OrderSnapshot struct {
+	ID                string      `json:"id"`
+	InventoryID       string      `json:"inventory_id"`
+	BuyerID           string      `json:"buyer_id"`
+	SellerID          string      `json:"seller_id"`
+	Status            OrderStatus `json:"status"`
+	Quantity          float64     `json:"quantity"`
+	OfferPricePerUnit float64     `json:"offer_price_per_unit"`
+	TotalAmount       float64     `json:"total_amount"`
+}
+
+// OrderFulfilment is how a shipped or ready order reaches the buyer
+type OrderFulfilment struct {
+	Carrier        string `json:"carrier,omitempty"`
+	TrackingNumber string `json:"tracking_number,omitempty"`
+	TrackingURL    string `json:"tracking_url,omitempty"`
+	PickupAddress  string `json:"pickup_address,omitempty"`
+	PickupNote     string `json:"pickup_note,omitempty"`
+}
+
+// validateOrderTransition checks that userID may perform action on order
+func validateOrderTransition(action OrderAction, order OrderSnapshot, userID, reasonCode, reason string) error {
+	switch action.Actor {
+	case orderSeller:
+		if order.SellerID != userID {
+			return fmt.Errorf("only the seller can %s an order", action.Name)
+		}
+	case orderBuyer:
+		if order.BuyerID != userID {
+			return fmt.Errorf("only the buyer can %s an order", action.Name)
+		}
+	}
+
+	if !slices.Contains(action.From, order.Status) {
+		return fmt.Errorf("a %s order can not be %s", order.Status, action.To)
+	}
+
+	if len(action.ReasonCodes) == 0 {
+		return nil
+	}
+
+	if !slices.Contains(action.ReasonCodes, reasonCode) {
+		return fmt.Errorf("reason_code must be one of %v", action.ReasonCodes)
+	}
+
+	if reasonCode == ReasonOther && reason == "" {
+		return errors.New("reason is required when reason_code is other")
+	}
+
+	return nil
+}
+
+type OrderTransitionPayload struct {
+	OrderID    string `json:"order_id"`
+	ReasonCode string `json:"reason_code"`
+	Reason     string `json:"reason"`
+	OrderFulfilment
+}
+
+type UpdateOrderStatusPayload struct {
+	OrderID    string           `json:"order_id"`
+	UserID     string           `json:"user_id"`
+	FromStatus OrderStatus      `json:"from_status"` // lets the inventory service reject a concurrent change
+	Status     OrderStatus      `json:"status"`
+	ReasonCode string           `json:"reason_code,omitempty"`
+	Reason     string           `json:"reason,omitempty"`
+	Fulfilment *OrderFulfilment `json:"fulfilment,omitempty"`
+}
+
+func (app *Config) AcceptOrder(w http.ResponseWriter, r *http.Request) {
+	app.transitionOrder(w, r, AcceptOrderAction)
+}
+
+func (app *Config) DeclineOrder(w http.ResponseWriter, r *http.Request) {
+	app.transitionOrder(w, r, DeclineOrderAction)
+}
+
+func (app *Config) ShipOrder(w http.ResponseWriter, r *http.Request) {
+	app.transitionOrder(w, r, ShipOrderAction)
+}
+
+func (app *Config) MarkOrderReadyForPickup(w http.ResponseWriter, r *http.Request) {
+	app.transitionOrder(w, r, ReadyForPickupOrderAction)
+}
+
+func (app *Config) ConfirmOrderReceived(w http.ResponseWriter, r *http.Request) {
+	app.transitionOrder(w, r, ConfirmReceivedOrderAction)
+}
+
+// CancelOrder cancels as the buyer, or as the seller when the seller calls it
+func (app *Config) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	app.transitionOrder(w, r, CancelOrderAction)
+}
+
+// transitionOrder validates action against the current order, applies it in the
+// inventory service and records an order.status_changed event
+func (app *Config) transitionOrder(w http.ResponseWriter, r *http.Request, action OrderAction) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload OrderTransitionPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// Validate the request payload
+	if err := app.ValidateOrderTransitionInput(requestPayload, action); len(err) > 0 {
+		app.errorJSON(w, fmt.Errorf("error trying to %s order", action.Name), err, http.StatusBadRequest)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	order, err := app.getOrderSnapshot(requestPayload.OrderID)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if action.To == OrderCancelled && order.SellerID == userId {
+		action = SellerCancelOrderAction
+	}
+
+	err = validateOrderTransition(action, order, userId, requestPayload.ReasonCode, requestPayload.Reason)
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusConflict)
+		return
+	}
+
+	var fulfilment *OrderFulfilment
+	if action.Fulfilment != "" {
+		fulfilment = &requestPayload.OrderFulfilment
+	}
+
+	jsonFromService, err := app.applyOrderTransition(r.Context(), action, order, userId, requestPayload.ReasonCode, requestPayload.Reason, fulfilment, correlationID(r))
+	if err != nil {
+		app.errorJSON(w, err, nil, jsonFromService.StatusCode)
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = jsonFromService.Error
+	payload.StatusCode = http.StatusOK
+	payload.Message = jsonFromService.Message
+	payload.Data = jsonFromService.Data
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// applyOrderTransition updates the order status in the inventory service and records an
+// order.status_changed event, the transition must already be validated
+func (app *Config) applyOrderTransition(ctx context.Context, action OrderAction, order OrderSnapshot, userId, reasonCode, reason string, fulfilment *OrderFulfilment, corrID string) (jsonResponse, error) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "update-order-status"), UpdateOrderStatusPayload{
+		OrderID:    order.ID,
+		UserID:     userId,
+		FromStatus: order.Status,
+		Status:     action.To,
+		ReasonCode: reasonCode,
+		Reason:     reason,
+		Fulfilment: fulfilment,
+	})
+	if err != nil {
+		return jsonFromService, err
+	}
+
+	app.recordEventOrPublish(ctx, event.OrderStatusChangedEvent{
+		OrderID:    order.ID,
+		BuyerID:    order.BuyerID,
+		SellerID:   order.SellerID,
+		From:       string(order.Status),
+		Status:     string(action.To),
+		ReasonCode: reasonCode,
+		Reason:     reason,
+		ChangedBy:  userId,
+	}, corrID)
+
+	return jsonFromService, nil
+}
+
+// getOrderSnapshot loads the current state of a purchase order from the inventory service
+func (app *Config) getOrderSnapshot(orderID string) (OrderSnapshot, error) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "order-detail"), map[string]string{
+		"order_id": orderID,
+	})
+	if err != nil {
+		return OrderSnapshot{}, err
+	}
+
+	var order OrderSnapshot
+	err = decodeServiceData(jsonFromService.Data, &order)
+	if err != nil {
+		return OrderSnapshot{}, err
+	}
+
+	if order.ID == "" {
+		return OrderSnapshot{}, errors.New("order not found")
+	}
+
+	return order, nil
+}
+
+// GetOrderStatusCounts counts the user's orders in every status, as the seller by default or as the buyer
+func (app *Config) GetOrderStatusCounts(w http.ResponseWriter, r *http.Request) {
+
+	// 1. retrieve query param
+	role := r.URL.Query().Get("role")
+	if role == "" {
+		role = orderSeller
+	}
+
+	if role != orderSeller && role != orderBuyer {
+		app.errorJSON(w, fmt.Errorf("role must be %s or %s", orderSeller, orderBuyer), nil)
+		return
+	}
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	userId, err := app.returnLoggedInUserID(user)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "order-status-counts"), map[string]string{
+		"user_id": userId,
+		"role":    role,
+	})
+	if err != nil {
+		app.errorJSON(w, err, nil, jsonFromService.StatusCode)
+		return
+	}
+
+	var upstream map[OrderStatus]int
+	err = decodeServiceData(jsonFromService.Data, &upstream)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	// every status is listed, the inventory service leaves out the empty ones
+	counts := make(map[OrderStatus]int, len(orderStatuses))
+	for _, status := range orderStatuses {
+		counts[status] = upstream[status]
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "order counts retrieved",
+		Data:       map[string]interface{}{"role": role, "counts": counts},
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
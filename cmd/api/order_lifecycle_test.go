@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateOrderTransition(t *testing.T) {
+	order := OrderSnapshot{ID: "order-1", SellerID: "seller-1", BuyerID: "buyer-1", Status: OrderPending}
+
+	t.Log("Checking the seller can accept a pending order")
+	assert.NoError(t, validateOrderTransition(AcceptOrderAction, order, "seller-1", "", ""))
+
+	t.Log("Checking the buyer can't accept their own order")
+	assert.Error(t, validateOrderTransition(AcceptOrderAction, order, "buyer-1", "", ""))
+
+	t.Log("Checking a decline needs a known reason code")
+	assert.Error(t, validateOrderTransition(DeclineOrderAction, order, "seller-1", "", ""))
+	assert.NoError(t, validateOrderTransition(DeclineOrderAction, order, "seller-1", "out_of_stock", ""))
+	assert.Error(t, validateOrderTransition(DeclineOrderAction, order, "seller-1", ReasonOther, ""))
+
+	t.Log("Checking a pending order can't be shipped or received")
+	assert.Error(t, validateOrderTransition(ShipOrderAction, order, "seller-1", "", ""))
+	assert.Error(t, validateOrderTransition(ConfirmReceivedOrderAction, order, "buyer-1", "", ""))
+
+	t.Log("Checking only the buyer cancels a pending order")
+	assert.NoError(t, validateOrderTransition(CancelOrderAction, order, "buyer-1", "change_of_plans", ""))
+	assert.Error(t, validateOrderTransition(SellerCancelOrderAction, order, "seller-1", "out_of_stock", ""))
+
+	t.Log("Checking an accepted order can be shipped or made ready for pickup")
+	order.Status = OrderAccepted
+	assert.NoError(t, validateOrderTransition(ShipOrderAction, order, "seller-1", "", ""))
+	assert.NoError(t, validateOrderTransition(ReadyForPickupOrderAction, order, "seller-1", "", ""))
+	assert.NoError(t, validateOrderTransition(SellerCancelOrderAction, order, "seller-1", "out_of_stock", ""))
+
+	t.Log("Checking the buyer confirms a shipped order, which can no longer be cancelled")
+	order.Status = OrderShipped
+	assert.NoError(t, validateOrderTransition(ConfirmReceivedOrderAction, order, "buyer-1", "", ""))
+	assert.Error(t, validateOrderTransition(ConfirmReceivedOrderAction, order, "seller-1", "", ""))
+	assert.Error(t, validateOrderTransition(CancelOrderAction, order, "buyer-1", "change_of_plans", ""))
+}
+
+func TestValidateOrderTransitionInput(t *testing.T) {
+	app := Config{}
+
+	t.Log("Checking shipping needs a carrier and tracking number")
+	errs := app.ValidateOrderTransitionInput(OrderTransitionPayload{OrderID: "order-1"}, ShipOrderAction)
+	assert.Contains(t, errs, "carrier")
+	assert.Contains(t, errs, "tracking_number")
+
+	errs = app.ValidateOrderTransitionInput(OrderTransitionPayload{OrderID: "order-1", OrderFulfilment: OrderFulfilment{Carrier: "GIG", TrackingNumber: "GIG123", TrackingURL: "ftp://track"}}, ShipOrderAction)
+	assert.Contains(t, errs, "tracking_url")
+
+	t.Log("Checking pickup needs an address")
+	errs = app.ValidateOrderTransitionInput(OrderTransitionPayload{OrderID: "order-1"}, ReadyForPickupOrderAction)
+	assert.Contains(t, errs, "pickup_address")
+
+	t.Log("Checking accepting needs nothing else")
+	assert.Empty(t, app.ValidateOrderTransitionInput(OrderTransitionPayload{OrderID: "order-1"}, AcceptOrderAction))
+}
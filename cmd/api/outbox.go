@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/obynonwane/broker-service/event"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// outboxStream is the redis stream events are recorded in before they are published
+	outboxStream = "events:outbox"
+	// outboxGroup is the consumer group of the relay workers
+	outboxGroup = "outbox-relay"
+	// maxOutboxLength bounds the stream so an unreachable broker can't exhaust redis
+	maxOutboxLength = 100000
+	// outboxBatchSize is how many entries a relay reads at once
+	outboxBatchSize = 50
+	// outboxBlock is how long a relay waits for new entries
+	outboxBlock = 5 * time.Second
+	// outboxClaimIdle is how long an entry stays pending before another relay takes it over
+	outboxClaimIdle = 30 * time.Second
+)
+
+// recordEvent writes e to the outbox, the relay publishes it to rabbitmq.
+// The envelope id is used as the amqp message id so consumers can drop duplicates.
+func (app *Config) recordEvent(ctx context.Context, e event.DomainEvent, correlationID string) error {
+	envelope, err := event.NewEnvelope(eventProducer, e, correlationID)
+	if err != nil {
+		return err
+	}
+
+	rawData, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return app.cache.XAdd(ctx, &redis.XAddArgs{
+		Stream: outboxStream,
+		MaxLen: maxOutboxLength,
+		Approx: true,
+		Values: map[string]interface{}{"envelope": rawData},
+	}).Err()
+}
+
+// recordEventOrPublish records e in the outbox, publishing it directly if redis is unavailable.
+// It is used once the upstream call succeeded, so failures are logged rather than returned.
+func (app *Config) recordEventOrPublish(ctx context.Context, e event.DomainEvent, correlationID string) {
+	err := app.recordEvent(ctx, e, correlationID)
+	if err == nil {
+		return
+	}
+
+	log.Printf("[OUTBOX] recording %s failed, publishing directly: %v", e.EventType(), err)
+	err = app.publishEvent(ctx, e, correlationID)
+	if err != nil {
+		log.Printf("[OUTBOX] %s was lost: %v", e.EventType(), err)
+	}
+}
+
+// RelayOutbox publishes outbox entries to rabbitmq, an entry is only removed once rabbitmq confirmed it.
+// Several broker instances can relay at once, each entry is handed to one of them.
+func (app *Config) RelayOutbox() {
+	consumer, err := os.Hostname()
+	if err != nil || consumer == "" {
+		consumer = "broker"
+	}
+
+	ctx := context.Background()
+	for {
+		err := app.cache.XGroupCreateMkStream(ctx, outboxStream, outboxGroup, "0").Err()
+		if err == nil || err.Error() == "BUSYGROUP Consumer Group name already exists" {
+			break
+		}
+
+		log.Printf("[OUTBOX] creating relay group failed: %v", err)
+		time.Sleep(outboxBlock)
+	}
+
+	for {
+		// take over entries another relay read but never confirmed, e.g. because it crashed
+		claimed, _, err := app.cache.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   outboxStream,
+			Group:    outboxGroup,
+			Consumer: consumer,
+			MinIdle:  outboxClaimIdle,
+			Start:    "0-0",
+			Count:    outboxBatchSize,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			log.Printf("[OUTBOX] claiming pending entries failed: %v", err)
+		}
+		app.relayEntries(ctx, claimed)
+
+		streams, err := app.cache.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    outboxGroup,
+			Consumer: consumer,
+			Streams:  []string{outboxStream, ">"},
+			Count:    outboxBatchSize,
+			Block:    outboxBlock,
+		}).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				log.Printf("[OUTBOX] reading outbox failed: %v", err)
+				time.Sleep(outboxBlock)
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			app.relayEntries(ctx, stream.Messages)
+		}
+	}
+}
+
+func (app *Config) relayEntries(ctx context.Context, entries []redis.XMessage) {
+	for _, entry := range entries {
+		raw, _ := entry.Values["envelope"].(string)
+
+		var envelope event.Envelope
+		err := json.Unmarshal([]byte(raw), &envelope)
+		if err != nil {
+			log.Printf("[OUTBOX] dropping unreadable entry %s: %v", entry.ID, err)
+			app.ackOutboxEntry(ctx, entry.ID)
+			continue
+		}
+
+		err = app.publishEnvelope(ctx, envelope)
+		if err != nil {
+			// stays pending, it is claimed again once outboxClaimIdle passed
+			log.Printf("[OUTBOX] relaying %s failed: %v", envelope.ID, err)
+			return
+		}
+
+		app.ackOutboxEntry(ctx, entry.ID)
+	}
+}
+
+func (app *Config) publishEnvelope(ctx context.Context, envelope event.Envelope) error {
+	if app.Publisher == nil {
+		return errors.New("rabbitmq publisher is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return app.Publisher.PublishEnvelope(ctx, envelope)
+}
+
+func (app *Config) ackOutboxEntry(ctx context.Context, id string) {
+	pipe := app.cache.TxPipeline()
+	pipe.XAck(ctx, outboxStream, outboxGroup, id)
+	pipe.XDel(ctx, outboxStream, id)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		log.Printf("[OUTBOX] removing relayed entry %s failed: %v", id, err)
+	}
+}
+
+// createdID pulls the id of a newly created record out of an upstream response
+func createdID(data any) string {
+	dataMap, ok := data.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	id, _ := dataMap["id"].(string)
+	return id
+}
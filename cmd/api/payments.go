@@ -8,6 +8,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+
+	"github.com/obynonwane/broker-service/event"
 )
 
 type BillingCycle string
@@ -168,6 +170,11 @@ func (app *Config) CancelSubscription(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.recordEventOrPublish(r.Context(), event.SubscriptionChangedEvent{
+		UserID: userId,
+		Status: "cancelled",
+	}, correlationID(r))
+
 	var payload jsonResponse
 	payload.Error = jsonFromService.Error
 	payload.StatusCode = jsonFromService.StatusCode
@@ -243,6 +250,11 @@ func (app *Config) ActivateSubscription(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	app.recordEventOrPublish(r.Context(), event.SubscriptionChangedEvent{
+		UserID: userId,
+		Status: "activated",
+	}, correlationID(r))
+
 	var payload jsonResponse
 	payload.Error = jsonFromService.Error
 	payload.StatusCode = jsonFromService.StatusCode
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/obynonwane/rental-service-proto/inventory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// priceTolerance absorbs rounding differences between the client and the broker
+const priceTolerance = 0.01
+
+// RentalQuote is the server-side price of a rental, clients must submit the same totals
+type RentalQuote struct {
+	InventoryID     string         `json:"inventory_id"`
+	OwnerID         string         `json:"owner_id"`
+	RentalType      RentalDuration `json:"rental_type"`
+	Start           time.Time      `json:"start"`
+	End             time.Time      `json:"end"`
+	StockQuantity   float64        `json:"stock_quantity"` // units of the inventory that exist
+	Units           float64        `json:"units"`          // hours, days, months or years
+	ListedPrice     float64        `json:"listed_price"`
+	UnitPrice       float64        `json:"unit_price"`
+	Quantity        float64        `json:"quantity"`
+	Subtotal        float64        `json:"subtotal"`
+	SecurityDeposit float64        `json:"security_deposit"`
+	TotalAmount     float64        `json:"total_amount"`
+	Negotiated      bool           `json:"negotiated"` // a price below the listing, the owner accepts or rejects it with the booking
+}
+
+// PricingInput is what a quote is computed from
+type PricingInput struct {
+	RentalType RentalDuration
+	Start      time.Time
+	End        time.Time
+	Quantity   float64
+	// OfferPricePerUnit is a negotiated price, zero means the listed price
+	OfferPricePerUnit float64
+}
+
+// rentalUnits counts how many units of rentalType the rental spans, a started unit counts as a whole one
+func rentalUnits(rentalType RentalDuration, start, end time.Time) (float64, error) {
+	if !end.After(start) {
+		return 0, errors.New("the rental must end after it starts")
+	}
+
+	switch rentalType {
+	case Hourly:
+		return math.Ceil(end.Sub(start).Hours()), nil
+	case Daily:
+		return math.Ceil(end.Sub(start).Hours() / 24), nil
+	case Monthly:
+		return float64(calendarUnits(start, end, 0, 1)), nil
+	case Annually:
+		return float64(calendarUnits(start, end, 1, 0)), nil
+	default:
+		return 0, fmt.Errorf("unsupported rental type %s", rentalType)
+	}
+}
+
+// calendarUnits counts whole calendar steps of years/months from start until end is covered
+func calendarUnits(start, end time.Time, years, months int) int {
+	units := 0
+	for cursor := start; cursor.Before(end); cursor = start.AddDate(years*units, months*units, 0) {
+		units++
+	}
+
+	return units
+}
+
+// quoteRental prices a rental of item, it is shared by the quote endpoint and CreateBooking
+func quoteRental(item *inventory.Inventory, input PricingInput) (RentalQuote, error) {
+	if item == nil {
+		return RentalQuote{}, errors.New("inventory not found")
+	}
+
+	if ProductPurpose(item.ProductPurpose) != ProductPurposeRental {
+		return RentalQuote{}, errors.New("inventory is not available for rent")
+	}
+
+	if item.IsAvailable == string(Unavailable) {
+		return RentalQuote{}, errors.New("inventory is currently unavailable")
+	}
+
+	if RentalDuration(item.RentalDuration) != input.RentalType {
+		return RentalQuote{}, fmt.Errorf("inventory is rented %s, not %s", item.RentalDuration, input.RentalType)
+	}
+
+	if input.Quantity <= 0 {
+		return RentalQuote{}, errors.New("quantity must be greater than zero")
+	}
+
+	if item.Quantity > 0 && input.Quantity > item.Quantity {
+		return RentalQuote{}, fmt.Errorf("only %g unit(s) of this inventory exist", item.Quantity)
+	}
+
+	unitPrice, err := negotiatedPrice(item, input.OfferPricePerUnit)
+	if err != nil {
+		return RentalQuote{}, err
+	}
+
+	units, err := rentalUnits(input.RentalType, input.Start, input.End)
+	if err != nil {
+		return RentalQuote{}, err
+	}
+
+	subtotal := roundMoney(unitPrice * units * input.Quantity)
+	deposit := roundMoney(item.SecurityDeposit * input.Quantity)
+
+	return RentalQuote{
+		InventoryID:     item.Id,
+		OwnerID:         item.UserId,
+		RentalType:      input.RentalType,
+		Start:           input.Start,
+		End:             input.End,
+		StockQuantity:   max(item.Quantity, 1),
+		Units:           units,
+		ListedPrice:     item.OfferPrice,
+		UnitPrice:       unitPrice,
+		Negotiated:      !withinTolerance(unitPrice, item.OfferPrice),
+		Quantity:        input.Quantity,
+		Subtotal:        subtotal,
+		SecurityDeposit: deposit,
+		TotalAmount:     roundMoney(subtotal + deposit),
+	}, nil
+}
+
+// negotiatedPrice returns the price per unit to charge.
+// An offer is only honoured on negotiable inventory and never below its minimum price. Nothing else
+// backs a rental offer on purpose, every booking waits for the owner who sees the price before accepting.
+func negotiatedPrice(item *inventory.Inventory, offer float64) (float64, error) {
+	if offer <= 0 || withinTolerance(offer, item.OfferPrice) {
+		return item.OfferPrice, nil
+	}
+
+	if NegotiableStatus(item.Negotiable) != Negotiable {
+		return 0, fmt.Errorf("inventory price is not negotiable, the price is %.2f", item.OfferPrice)
+	}
+
+	if offer > item.OfferPrice {
+		return 0, fmt.Errorf("offer price can not be above the listed price of %.2f", item.OfferPrice)
+	}
+
+	if offer < item.MinimumPrice {
+		return 0, fmt.Errorf("offer price can not be below the minimum price of %.2f", item.MinimumPrice)
+	}
+
+	return offer, nil
+}
+
+// checkSubmittedTotals compares what the client sent against the quote
+func (q RentalQuote) checkSubmittedTotals(units, deposit, total float64) map[string]string {
+	errors := map[string]string{}
+
+	if !withinTolerance(units, q.Units) {
+		errors["rental_duration"] = fmt.Sprintf("rental_duration should be %g", q.Units)
+	}
+
+	if !withinTolerance(deposit, q.SecurityDeposit) {
+		errors["security_deposit"] = fmt.Sprintf("security_deposit should be %.2f", q.SecurityDeposit)
+	}
+
+	if !withinTolerance(total, q.TotalAmount) {
+		errors["total_amount"] = fmt.Sprintf("total_amount should be %.2f", q.TotalAmount)
+	}
+
+	return errors
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func withinTolerance(a, b float64) bool {
+	return math.Abs(a-b) <= priceTolerance
+}
+
+// getInventory fetches an inventory from the inventory service over grpc
+func (app *Config) getInventory(inventoryID string) (*inventory.Inventory, error) {
+	conn, err := grpc.Dial("inventory-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	c := inventory.NewInventoryServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.GetInventoryByID(ctx, &inventory.SingleInventoryRequestDetail{
+		InventoryId: inventoryID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.GetInventory(), nil
+}
+
+type BookingQuotePayload struct {
+	InventoryId       string  `json:"inventory_id"`
+	RentalType        string  `json:"rental_type"`
+	OfferPricePerUnit float64 `json:"offer_price_per_unit"`
+	Quantity          float64 `json:"quantity"`
+	StartDate         string  `json:"start_date"`
+	EndDate           string  `json:"end_date"`
+	StartTime         string  `json:"start_time"`
+	EndTime           string  `json:"end_time"`
+	Timezone          string  `json:"timezone"` // defaults to Africa/Lagos
+}
+
+// quoteBooking computes the quote for the given booking fields
+func (app *Config) quoteBooking(req BookingQuotePayload) (RentalQuote, error) {
+	window, errs := BookingTimes{
+		StartDate: req.StartDate,
+		StartTime: req.StartTime,
+		EndDate:   req.EndDate,
+		EndTime:   req.EndTime,
+		Timezone:  req.Timezone,
+	}.Window()
+	if len(errs) > 0 {
+		return RentalQuote{}, firstError(errs)
+	}
+
+	item, err := app.getInventory(req.InventoryId)
+	if err != nil {
+		return RentalQuote{}, err
+	}
+
+	return quoteRental(item, PricingInput{
+		RentalType:        RentalDuration(req.RentalType),
+		Start:             window.Start,
+		End:               window.End,
+		Quantity:          req.Quantity,
+		OfferPricePerUnit: req.OfferPricePerUnit,
+	})
+}
+
+func (app *Config) QuoteBooking(w http.ResponseWriter, r *http.Request) {
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	//extract the request body
+	var requestPayload BookingQuotePayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	if requestPayload.Timezone == "" {
+		requestPayload.Timezone = userTimezone(user)
+	}
+
+	// Validate the request payload
+	if err := app.ValidateBookingQuoteInput(requestPayload); len(err) > 0 {
+		app.errorJSON(w, errors.New("error trying to quote booking"), err, http.StatusBadRequest)
+		return
+	}
+
+	quote, err := app.quoteBooking(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:      false,
+		StatusCode: http.StatusOK,
+		Message:    "booking quote computed",
+		Data:       quote,
+	}
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// SaleQuote is the server-side price of a purchase, clients must submit the same totals
+type SaleQuote struct {
+	InventoryID string  `json:"inventory_id"`
+	SellerID    string  `json:"seller_id"`
+	ListedPrice float64 `json:"listed_price"`
+	UnitPrice   float64 `json:"unit_price"`
+	Quantity    float64 `json:"quantity"`
+	TotalAmount float64 `json:"total_amount"`
+	OfferID     string  `json:"offer_id,omitempty"`
+}
+
+// PurchaseOffer is a price a buyer offered on a sale listing, it only counts once the seller accepts it
+type PurchaseOffer struct {
+	ID           string    `json:"id"`
+	InventoryID  string    `json:"inventory_id"`
+	BuyerID      string    `json:"buyer_id"`
+	SellerID     string    `json:"seller_id"`
+	PricePerUnit float64   `json:"price_per_unit"`
+	Quantity     float64   `json:"quantity"` // zero means any quantity
+	Status       string    `json:"status"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// offerAccepted is the status of an offer the seller agreed to
+const offerAccepted = "accepted"
+
+// quoteSale prices a purchase of item by buyerID. The listed price is charged unless offerPrice
+// is lower, which needs offer to be an accepted offer of buyerID on item for that price.
+// An accepted offer backs one order, see claimOffer.
+func quoteSale(item *inventory.Inventory, buyerID string, quantity, offerPrice float64, offer *PurchaseOffer, now time.Time) (SaleQuote, error) {
+	if item == nil {
+		return SaleQuote{}, errors.New("inventory not found")
+	}
+
+	if ProductPurpose(item.ProductPurpose) != ProductPurposeSale {
+		return SaleQuote{}, errors.New("inventory is not for sale")
+	}
+
+	if item.IsAvailable == string(Unavailable) || item.Deactivated {
+		return SaleQuote{}, errors.New("inventory is currently unavailable")
+	}
+
+	if item.UserId == buyerID {
+		return SaleQuote{}, errors.New("you can not buy your own inventory")
+	}
+
+	if quantity <= 0 {
+		return SaleQuote{}, errors.New("quantity must be greater than zero")
+	}
+
+	if quantity > item.Quantity {
+		return SaleQuote{}, fmt.Errorf("only %g unit(s) of this inventory are in stock", item.Quantity)
+	}
+
+	quote := SaleQuote{
+		InventoryID: item.Id,
+		SellerID:    item.UserId,
+		ListedPrice: item.OfferPrice,
+		UnitPrice:   item.OfferPrice,
+		Quantity:    quantity,
+	}
+
+	if offerPrice > 0 && offerPrice < item.OfferPrice && !withinTolerance(offerPrice, item.OfferPrice) {
+		if offer == nil {
+			return SaleQuote{}, fmt.Errorf("a price below the listed %.2f needs an accepted offer", item.OfferPrice)
+		}
+
+		if err := offer.backs(item, buyerID, quantity, offerPrice, now); err != nil {
+			return SaleQuote{}, err
+		}
+
+		// the seller agreed to this price, it stands below the minimum and on a fixed price listing
+		quote.OfferID = offer.ID
+		quote.UnitPrice = offer.PricePerUnit
+	} else {
+		unitPrice, err := negotiatedPrice(item, offerPrice)
+		if err != nil {
+			return SaleQuote{}, err
+		}
+		quote.UnitPrice = unitPrice
+	}
+
+	quote.TotalAmount = roundMoney(quote.UnitPrice * quantity)
+
+	return quote, nil
+}
+
+// backs checks the offer allows buyerID to buy quantity of item at price
+func (o PurchaseOffer) backs(item *inventory.Inventory, buyerID string, quantity, price float64, now time.Time) error {
+	if o.Status != offerAccepted {
+		return fmt.Errorf("the offer is %s, not accepted", o.Status)
+	}
+
+	if o.InventoryID != item.Id || o.BuyerID != buyerID {
+		return errors.New("the offer is not for this inventory and buyer")
+	}
+
+	if !o.ExpiresAt.IsZero() && now.After(o.ExpiresAt) {
+		return errors.New("the offer has expired")
+	}
+
+	if !withinTolerance(price, o.PricePerUnit) {
+		return fmt.Errorf("the accepted offer is %.2f per unit", o.PricePerUnit)
+	}
+
+	if o.Quantity > 0 && quantity > o.Quantity {
+		return fmt.Errorf("the accepted offer covers %g unit(s)", o.Quantity)
+	}
+
+	return nil
+}
+
+// checkSubmittedTotals compares what the client sent against the quote
+func (q SaleQuote) checkSubmittedTotals(unitPrice, total float64) map[string]string {
+	errors := map[string]string{}
+
+	if !withinTolerance(unitPrice, q.UnitPrice) {
+		errors["offer_price_per_unit"] = fmt.Sprintf("offer_price_per_unit should be %.2f", q.UnitPrice)
+	}
+
+	if !withinTolerance(total, q.TotalAmount) {
+		errors["total_amount"] = fmt.Sprintf("total_amount should be %.2f", q.TotalAmount)
+	}
+
+	return errors
+}
+
+// claimOffer marks offer used so it backs one order, call the returned func to free it again
+func (app *Config) claimOffer(ctx context.Context, offer PurchaseOffer) (func(), error) {
+	// nothing can be bought with the offer once it expires, so neither is the claim kept
+	var ttl time.Duration
+	if !offer.ExpiresAt.IsZero() {
+		ttl = max(time.Until(offer.ExpiresAt), time.Minute)
+	}
+
+	claimKey := fmt.Sprintf("offers:used:%s", offer.ID)
+	claimed, err := app.cache.SetNX(ctx, claimKey, offer.BuyerID, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, errors.New("this offer has already been used")
+	}
+
+	return func() { app.cache.Del(context.Background(), claimKey) }, nil
+}
+
+// getPurchaseOffer loads an offer from the inventory service
+func (app *Config) getPurchaseOffer(offerID string) (PurchaseOffer, error) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "offer-detail"), map[string]string{
+		"offer_id": offerID,
+	})
+	if err != nil {
+		return PurchaseOffer{}, err
+	}
+
+	var offer PurchaseOffer
+	err = decodeServiceData(jsonFromService.Data, &offer)
+	if err != nil {
+		return PurchaseOffer{}, err
+	}
+
+	if offer.ID == "" {
+		return PurchaseOffer{}, errors.New("offer not found")
+	}
+
+	return offer, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/obynonwane/rental-service-proto/inventory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRentalUnits(t *testing.T) {
+	start := time.Date(2025, 1, 31, 10, 0, 0, 0, time.UTC)
+
+	t.Log("Checking a started hour or day counts as a whole one")
+	units, err := rentalUnits(Hourly, start, start.Add(90*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, units)
+
+	units, _ = rentalUnits(Daily, start, start.Add(49*time.Hour))
+	assert.Equal(t, 3.0, units)
+
+	t.Log("Checking months follow the calendar")
+	units, _ = rentalUnits(Monthly, start, time.Date(2025, 2, 28, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, 1.0, units)
+	units, _ = rentalUnits(Monthly, start, time.Date(2025, 3, 31, 11, 0, 0, 0, time.UTC))
+	assert.Equal(t, 3.0, units)
+
+	units, _ = rentalUnits(Annually, start, time.Date(2026, 1, 31, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, 1.0, units)
+
+	t.Log("Checking an empty rental is refused")
+	_, err = rentalUnits(Daily, start, start)
+	assert.Error(t, err)
+}
+
+func TestQuoteRental(t *testing.T) {
+	item := &inventory.Inventory{
+		Id:              "inventory-1",
+		UserId:          "owner-1",
+		ProductPurpose:  string(ProductPurposeRental),
+		RentalDuration:  string(Daily),
+		OfferPrice:      1000,
+		MinimumPrice:    800,
+		Negotiable:      string(Negotiable),
+		SecurityDeposit: 500,
+		Quantity:        5,
+	}
+
+	window, errs := BookingTimes{StartDate: "2025-06-15", EndDate: "2025-06-17"}.Window()
+	assert.Empty(t, errs)
+	start, end := window.Start, window.End
+
+	t.Log("Checking the listed price is used without an offer")
+	quote, err := quoteRental(item, PricingInput{RentalType: Daily, Start: start, End: end, Quantity: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, quote.Units)
+	assert.Equal(t, 6000.0, quote.Subtotal)
+	assert.Equal(t, 1000.0, quote.SecurityDeposit)
+	assert.Equal(t, 7000.0, quote.TotalAmount)
+	assert.False(t, quote.Negotiated)
+	assert.Empty(t, quote.checkSubmittedTotals(3, 1000, 7000))
+	assert.Contains(t, quote.checkSubmittedTotals(3, 1000, 6000), "total_amount")
+
+	t.Log("Checking offers are held to the minimum price and left for the owner to accept")
+	quote, err = quoteRental(item, PricingInput{RentalType: Daily, Start: start, End: end, Quantity: 1, OfferPricePerUnit: 900})
+	assert.NoError(t, err)
+	assert.Equal(t, 900.0, quote.UnitPrice)
+	assert.Equal(t, 1000.0, quote.ListedPrice)
+	assert.True(t, quote.Negotiated)
+	_, err = quoteRental(item, PricingInput{RentalType: Daily, Start: start, End: end, Quantity: 1, OfferPricePerUnit: 700})
+	assert.Error(t, err)
+
+	t.Log("Checking the rental type must match the inventory")
+	_, err = quoteRental(item, PricingInput{RentalType: Hourly, Start: start, End: end, Quantity: 1})
+	assert.Error(t, err)
+}
+
+func TestQuoteSale(t *testing.T) {
+	item := &inventory.Inventory{
+		Id:             "inventory-1",
+		UserId:         "seller-1",
+		ProductPurpose: string(ProductPurposeSale),
+		OfferPrice:     1000,
+		MinimumPrice:   800,
+		Negotiable:     string(Negotiable),
+		Quantity:       5,
+	}
+	now := time.Date(2025, 6, 15, 10, 0, 0, 0, time.UTC)
+	offer := &PurchaseOffer{ID: "offer-1", InventoryID: "inventory-1", BuyerID: "buyer-1", SellerID: "seller-1", PricePerUnit: 850, Status: offerAccepted, ExpiresAt: now.Add(time.Hour)}
+
+	t.Log("Checking the listed price is used without an offer")
+	quote, err := quoteSale(item, "buyer-1", 2, 1000, nil, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2000.0, quote.TotalAmount)
+	assert.Empty(t, quote.checkSubmittedTotals(1000, 2000))
+	assert.Contains(t, quote.checkSubmittedTotals(1000, 1700), "total_amount")
+
+	t.Log("Checking a lower price needs an accepted offer")
+	_, err = quoteSale(item, "buyer-1", 2, 850, nil, now)
+	assert.Error(t, err)
+
+	quote, err = quoteSale(item, "buyer-1", 2, 850, offer, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1700.0, quote.TotalAmount)
+	assert.Equal(t, "offer-1", quote.OfferID)
+
+	t.Log("Checking the offer must match the price, buyer and be current")
+	_, err = quoteSale(item, "buyer-1", 2, 820, offer, now)
+	assert.Error(t, err)
+	_, err = quoteSale(item, "buyer-2", 2, 850, offer, now)
+	assert.Error(t, err)
+	_, err = quoteSale(item, "buyer-1", 2, 850, offer, now.Add(2*time.Hour))
+	assert.Error(t, err)
+
+	pending := *offer
+	pending.Status = "pending"
+	_, err = quoteSale(item, "buyer-1", 2, 850, &pending, now)
+	assert.Error(t, err)
+
+	t.Log("Checking an accepted offer stands below the minimum price and on a fixed price listing")
+	cheap := *offer
+	cheap.PricePerUnit = 700
+	quote, err = quoteSale(item, "buyer-1", 2, 700, &cheap, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1400.0, quote.TotalAmount)
+
+	fixed := &inventory.Inventory{
+		Id:             "inventory-1",
+		UserId:         "seller-1",
+		ProductPurpose: string(ProductPurposeSale),
+		OfferPrice:     1000,
+		Negotiable:     string(NonNegotiable),
+		Quantity:       5,
+	}
+	quote, err = quoteSale(fixed, "buyer-1", 2, 850, offer, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 850.0, quote.UnitPrice)
+	_, err = quoteSale(fixed, "buyer-1", 2, 850, nil, now)
+	assert.Error(t, err)
+
+	t.Log("Checking a price above the listing is refused")
+	_, err = quoteSale(item, "buyer-1", 2, 1200, nil, now)
+	assert.Error(t, err)
+}
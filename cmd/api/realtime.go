@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/obynonwane/broker-service/event"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// realtimeHeartbeat keeps idle streams open through proxies
+	realtimeHeartbeat = 25 * time.Second
+	// realtimeBuffer is how many events a slow stream can fall behind before events are dropped
+	realtimeBuffer = 32
+)
+
+// RealtimeEvent is a single server-sent event
+type RealtimeEvent struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// CounterUpdate is the data of a "counter" event, it replaces polling the count endpoints
+type CounterUpdate struct {
+	Name  string `json:"name"`
+	Count any    `json:"count"`
+}
+
+// RealtimeHub fans events out to the streams a user has open on this instance
+type RealtimeHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan RealtimeEvent]struct{}
+}
+
+func NewRealtimeHub() *RealtimeHub {
+	return &RealtimeHub{subscribers: make(map[string]map[chan RealtimeEvent]struct{})}
+}
+
+// Subscribe opens a stream for userID, call the returned func to close it
+func (h *RealtimeHub) Subscribe(userID string) (<-chan RealtimeEvent, func()) {
+	ch := make(chan RealtimeEvent, realtimeBuffer)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan RealtimeEvent]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.subscribers[userID], ch)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}
+}
+
+// Publish sends e to every stream of userID, streams that can't keep up miss it
+func (h *RealtimeHub) Publish(userID string, e RealtimeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[userID] {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("[REALTIME] dropping %s for %s, stream is behind", e.Type, userID)
+		}
+	}
+}
+
+// Online reports whether userID has a stream open on this instance
+func (h *RealtimeHub) Online(userID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers[userID]) > 0
+}
+
+// StreamEvents pushes counter changes and domain events to the logged in user over server-sent events
+func (app *Config) StreamEvents(w http.ResponseWriter, r *http.Request) {
+
+	// EventSource can't set headers, so the token may come as a query param
+	if r.Header.Get("Authorization") == "" {
+		if token := r.URL.Query().Get("token"); token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	// verify the user token
+	user, err := app.getToken(r)
+	if err != nil {
+		app.errorJSON(w, err, user.Data, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Error {
+		app.errorJSON(w, errors.New(user.Message), user.Data, user.StatusCode)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming is not supported"), nil, http.StatusInternalServerError)
+		return
+	}
+
+	if app.realtime == nil {
+		app.errorJSON(w, errors.New("event stream is not available"), nil, http.StatusServiceUnavailable)
+		return
+	}
+
+	userId := user.Data.(map[string]interface{})["user"].(map[string]interface{})["id"].(string)
+
+	events, unsubscribe := app.realtime.Subscribe(userId)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// tell the browser how long to wait before reconnecting
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	// push the current counts so the badges are right straight away
+	go app.refreshCounters(userId)
+
+	heartbeat := time.NewTicker(realtimeHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case e := <-events:
+			if err := writeServerSentEvent(w, e); err != nil {
+				log.Printf("[REALTIME] writing to %s failed: %v", userId, err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, e RealtimeEvent) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
+
+// consumeRealtimeEvents is run by the supervisor on every (re)connect.
+// Each instance uses its own exclusive queue, as it needs every event for the streams it holds.
+func (app *Config) consumeRealtimeEvents(conn *amqp.Connection) error {
+	consumer, err := event.NewConsumer(conn, event.ConsumerConfig{})
+	if err != nil {
+		return err
+	}
+
+	consumer.Register(event.BookingCreated, event.Typed(app.pushBookingCreated))
+	consumer.Register(event.BookingStatusChanged, event.Typed(app.pushBookingStatusChanged))
+	consumer.Register(event.OrderCreated, event.Typed(app.pushOrderCreated))
+	consumer.Register(event.OrderStatusChanged, event.Typed(app.pushOrderStatusChanged))
+	consumer.Register(event.SubscriptionChanged, event.Typed(app.pushSubscriptionChanged))
+	consumer.Register(event.ChatPersisted, event.Typed(app.pushChatMessage))
+
+	go func() {
+		err := consumer.Listen(context.Background(), consumer.Topics())
+		log.Printf("[REALTIME] consumer stopped: %v", err)
+	}()
+
+	return nil
+}
+
+func (app *Config) pushBookingCreated(ctx context.Context, e event.Envelope, booking event.BookingCreatedEvent) error {
+	if !app.realtime.Online(booking.OwnerID) {
+		return nil
+	}
+
+	app.realtime.Publish(booking.OwnerID, RealtimeEvent{Type: string(e.Type), Data: booking})
+	app.pushCounter(booking.OwnerID, "pending_booking_count", "pending-booking-count")
+
+	return nil
+}
+
+func (app *Config) pushBookingStatusChanged(ctx context.Context, e event.Envelope, booking event.BookingStatusChangedEvent) error {
+	for _, userId := range []string{booking.RenterID, booking.OwnerID} {
+		if !app.realtime.Online(userId) {
+			continue
+		}
+
+		app.realtime.Publish(userId, RealtimeEvent{Type: string(e.Type), Data: booking})
+	}
+
+	if booking.From == string(BookingPending) && app.realtime.Online(booking.OwnerID) {
+		app.pushCounter(booking.OwnerID, "pending_booking_count", "pending-booking-count")
+	}
+
+	return nil
+}
+
+func (app *Config) pushOrderCreated(ctx context.Context, e event.Envelope, order event.OrderCreatedEvent) error {
+	if !app.realtime.Online(order.SellerID) {
+		return nil
+	}
+
+	app.realtime.Publish(order.SellerID, RealtimeEvent{Type: string(e.Type), Data: order})
+	app.pushCounter(order.SellerID, "pending_purchase_count", "pending-purchase-count")
+
+	return nil
+}
+
+func (app *Config) pushOrderStatusChanged(ctx context.Context, e event.Envelope, order event.OrderStatusChangedEvent) error {
+	for _, userId := range []string{order.BuyerID, order.SellerID} {
+		if !app.realtime.Online(userId) {
+			continue
+		}
+
+		app.realtime.Publish(userId, RealtimeEvent{Type: string(e.Type), Data: order})
+	}
+
+	if app.realtime.Online(order.SellerID) {
+		app.pushCounter(order.SellerID, "pending_purchase_count", "pending-purchase-count")
+	}
+
+	return nil
+}
+
+func (app *Config) pushSubscriptionChanged(ctx context.Context, e event.Envelope, subscription event.SubscriptionChangedEvent) error {
+	app.realtime.Publish(subscription.UserID, RealtimeEvent{Type: string(e.Type), Data: subscription})
+
+	return nil
+}
+
+func (app *Config) pushChatMessage(ctx context.Context, e event.Envelope, chat event.ChatPersistedEvent) error {
+	app.realtime.Publish(chat.Receiver, RealtimeEvent{
+		Type: "chat.message",
+		Data: map[string]interface{}{
+			"message_id": chat.MessageID,
+			"sender":     chat.Sender,
+			"sent_at":    chat.SentAt,
+		},
+	})
+
+	if app.realtime.Online(chat.Receiver) {
+		app.pushUnreadChatCount(chat.Receiver)
+	}
+
+	return nil
+}
+
+// refreshCounters pushes every badge count of userId
+func (app *Config) refreshCounters(userId string) {
+	app.pushCounter(userId, "pending_booking_count", "pending-booking-count")
+	app.pushCounter(userId, "pending_purchase_count", "pending-purchase-count")
+	app.pushUnreadChatCount(userId)
+}
+
+// pushUnreadChatCount counts the unread chats of userId, as GetUnreadChat lists them, and pushes the count
+func (app *Config) pushUnreadChatCount(userId string) {
+	jsonFromService, err := app.callService(fmt.Sprintf("%s%s", os.Getenv("INVENTORY_SERVICE_URL"), "unread-chat"), map[string]string{
+		"user_id": userId,
+	})
+	if err != nil {
+		log.Printf("[REALTIME] fetching unread_chat_count for %s failed: %v", userId, err)
+		return
+	}
+
+	app.realtime.Publish(userId, RealtimeEvent{Type: "counter", Data: CounterUpdate{Name: "unread_chat_count", Count: unreadChatCount(jsonFromService.Data)}})
+}
+
+// unreadChatCount counts the chats of an unread-chat reply, the list itself or an object holding it
+func unreadChatCount(data any) int {
+	if count, ok := data.(float64); ok {
+		return int(count)
+	}
+
+	return len(exportPageRows(data))
+}
+
+// pushCounter fetches a count from the inventory service and pushes it to userId
+func (app *Config) pushCounter(userId, name, path string) {
+	count, err := app.fetchCount(path, userId)
+	if err != nil {
+		log.Printf("[REALTIME] fetching %s for %s failed: %v", name, userId, err)
+		return
+	}
+
+	app.realtime.Publish(userId, RealtimeEvent{Type: "counter", Data: CounterUpdate{Name: name, Count: count}})
+}
+
+// fetchCount calls one of the inventory service count endpoints, e.g. pending-booking-count
+func (app *Config) fetchCount(path, userId string) (any, error) {
+	reqUrl := fmt.Sprintf("%s%s?userId=%s", os.Getenv("INVENTORY_SERVICE_URL"), path, userId)
+
+	request, err := http.NewRequest("GET", reqUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var jsonFromService jsonResponse
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusAccepted {
+		return nil, errors.New(jsonFromService.Message)
+	}
+
+	return jsonFromService.Data, nil
+}
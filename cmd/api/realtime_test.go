@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealtimeHub(t *testing.T) {
+	hub := NewRealtimeHub()
+
+	first, closeFirst := hub.Subscribe("user-1")
+	second, closeSecond := hub.Subscribe("user-1")
+	defer closeSecond()
+
+	t.Log("Checking every stream of a user gets the event")
+	hub.Publish("user-1", RealtimeEvent{Type: "counter"})
+	assert.Equal(t, "counter", (<-first).Type)
+	assert.Equal(t, "counter", (<-second).Type)
+
+	t.Log("Checking other users don't get it")
+	hub.Publish("user-2", RealtimeEvent{Type: "counter"})
+	assert.Len(t, second, 0)
+
+	t.Log("Checking a closed stream is removed")
+	closeFirst()
+	assert.True(t, hub.Online("user-1"))
+	closeSecond()
+	assert.False(t, hub.Online("user-1"))
+}
+
+func TestUnreadChatCount(t *testing.T) {
+	t.Log("Checking unread chats are counted whether the service sends a list, an object holding one or a count")
+	assert.Equal(t, 2, unreadChatCount([]interface{}{map[string]interface{}{"id": "a"}, map[string]interface{}{"id": "b"}}))
+	assert.Equal(t, 1, unreadChatCount(map[string]interface{}{"chats": []interface{}{map[string]interface{}{"id": "a"}}}))
+	assert.Equal(t, 4, unreadChatCount(float64(4)))
+	assert.Equal(t, 0, unreadChatCount(nil))
+}
@@ -26,6 +26,7 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Get("/api/v1/health", app.HealthCheck)
 	mux.Post("/api/v1/authentication/signup", app.Signup)
 	mux.Post("/api/v1/authentication/admin/signup", app.SignupAdmin)
 	mux.Post("/api/v1/authentication/login", app.Login)
@@ -83,6 +84,7 @@ func (app *Config) routes() http.Handler {
 	mux.Post("/api/v1/inventory/save-inventory", app.SaveInventory)
 	mux.Post("/api/v1/inventory/delete-saved-inventory", app.DeleteSaveInventory)
 	mux.Post("/api/v1/inventory/inventory-availability", app.MarkInventoryAvailability)
+	mux.Get("/api/v1/inventory/availability-calendar", app.GetInventoryAvailability)
 	mux.Get("/api/v1/inventory/delete-inventory/{id}", app.DeleteInventory)
 	mux.Get("/api/v1/inventory/user-saved-inventory", app.GetUserSavedInventory)
 	mux.Get("/api/v1/inventory/premium-extras", app.GetPremiumUsersExtras)
@@ -97,11 +99,64 @@ func (app *Config) routes() http.Handler {
 	mux.Get("/api/v1/booking/my-booking", app.MyBookings)
 	mux.Get("/api/v1/booking/booking-requests", app.GetBookingRequest)
 	mux.Get("/api/v1/booking/pending-booking-count", app.GetPendingBookingCount)
+	mux.Post("/api/v1/booking/quote", app.QuoteBooking)
+	mux.Post("/api/v1/booking/accept", app.AcceptBooking)
+	mux.Post("/api/v1/booking/reject", app.RejectBooking)
+	mux.Post("/api/v1/booking/cancel", app.CancelBooking)
+	mux.Post("/api/v1/booking/start", app.StartBooking)
+	mux.Post("/api/v1/booking/complete", app.CompleteBooking)
+	mux.Post("/api/v1/booking/change/request", app.RequestBookingChange)
+	mux.Post("/api/v1/booking/change/approve", app.ApproveBookingChange)
+	mux.Post("/api/v1/booking/change/decline", app.DeclineBookingChange)
+	mux.Post("/api/v1/booking/change/withdraw", app.WithdrawBookingChange)
+	mux.Get("/api/v1/booking/changes", app.GetBookingChanges)
+	mux.Get("/api/v1/booking/detail", app.GetBookingDetail)
+	mux.Post("/api/v1/booking/deposit/collect", app.CollectDeposit)
+	mux.Post("/api/v1/booking/deposit/verify", app.VerifyDeposit)
+	mux.Post("/api/v1/booking/deposit/release", app.ReleaseDeposit)
+	mux.Post("/api/v1/booking/deposit/deduct", app.DeductDeposit)
+
+	mux.Post("/api/v1/calendar/feed-token", app.CreateCalendarFeedToken)
+	mux.Get("/api/v1/calendar/feed/{token}.ics", app.GetCalendarFeed)
+	mux.Post("/api/v1/calendar/import", app.ImportCalendar)
+
+	mux.Post("/api/v1/disputes/open", app.OpenDispute)
+	mux.Post("/api/v1/disputes/message", app.PostDisputeMessage)
+	mux.Post("/api/v1/disputes/escalate", app.EscalateDispute)
+	mux.Post("/api/v1/disputes/withdraw", app.WithdrawDispute)
+	mux.Get("/api/v1/disputes", app.MyDisputes)
+	mux.Get("/api/v1/disputes/detail", app.GetDispute)
+
+	mux.Get("/api/v1/admin/jobs", app.GetJobs)
+	mux.Get("/api/v1/admin/disputes", app.GetDisputeQueue)
+	mux.Post("/api/v1/admin/disputes/resolve", app.ResolveDispute)
 
 	mux.Get("/api/v1/purchase/pending-purchase-count", app.GetPendingPurchaseCount)
 
 	mux.Post("/api/v1/purchase/create-order", app.CreatePrurchaseOrder)
 	mux.Get("/api/v1/purchase/my-purchase", app.MyPurchase)
+	mux.Post("/api/v1/purchase/accept", app.AcceptOrder)
+	mux.Post("/api/v1/purchase/decline", app.DeclineOrder)
+	mux.Post("/api/v1/purchase/ship", app.ShipOrder)
+	mux.Post("/api/v1/purchase/ready-for-pickup", app.MarkOrderReadyForPickup)
+	mux.Post("/api/v1/purchase/confirm-received", app.ConfirmOrderReceived)
+	mux.Post("/api/v1/purchase/cancel", app.CancelOrder)
+	mux.Get("/api/v1/purchase/order-counts", app.GetOrderStatusCounts)
+
+	mux.Get("/api/v1/cart", app.GetCart)
+	mux.Post("/api/v1/cart/add", app.AddCartItem)
+	mux.Post("/api/v1/cart/update", app.UpdateCartItem)
+	mux.Post("/api/v1/cart/remove", app.RemoveCartItem)
+	mux.Post("/api/v1/cart/checkout", app.CheckoutCart)
+	mux.Post("/api/v1/cart/checkout/pay", app.PayCheckout)
+
+	mux.Get("/api/v1/invoices/download", app.DownloadInvoice)
+	mux.Post("/api/v1/invoices/email", app.EmailInvoice)
+
+	mux.Get("/api/v1/exports/stream", app.ExportData)
+	mux.Post("/api/v1/exports", app.StartExport)
+	mux.Get("/api/v1/exports/status", app.GetExport)
+	mux.Get("/api/v1/exports/download", app.DownloadExport)
 	mux.Get("/api/v1/purchase/purchase-requests", app.GetPurchaseRequest)
 
 	mux.Get("/api/v1/inventory/my-inventories", app.MyInventories)
@@ -115,6 +170,19 @@ func (app *Config) routes() http.Handler {
 	mux.Get("/api/v1/chat/unread-chat", app.GetUnreadChat)
 	mux.Get("/api/v1/chat/mark-chat-as-read", app.MarkChatAsRead)
 	mux.Post("/api/v1/chat/delete-chat", app.DeleteChat)
+	mux.Get("/api/v1/chat/notification-preference", app.GetChatNotificationPreference)
+	mux.Post("/api/v1/chat/notification-preference", app.UpdateChatNotificationPreference)
+
+	//notification routes
+	mux.Get("/api/v1/notifications", app.GetNotifications)
+	mux.Get("/api/v1/notifications/unread-count", app.GetUnreadNotificationCount)
+	mux.Post("/api/v1/notifications/mark-read", app.MarkNotificationsRead)
+	mux.Post("/api/v1/notifications/mark-all-read", app.MarkAllNotificationsRead)
+	mux.Get("/api/v1/notifications/preferences", app.GetNotificationPreferences)
+	mux.Post("/api/v1/notifications/preferences", app.UpdateNotificationPreferences)
+
+	//realtime routes
+	mux.Get("/api/v1/events/stream", app.StreamEvents)
 
 	// Profile routes-----------------------------------------------//
 	mux.Post("/api/v1/authentication/profile-image", app.UploadProfileImage)
@@ -136,5 +204,10 @@ func (app *Config) routes() http.Handler {
 	mux.Get("/api/v1/subscription/subscription-history", app.GetSubscriptionHistory)
 	mux.Get("/api/v1/subscription/plans", app.GetPlans)
 
+	//admin event routes
+	mux.Get("/api/v1/admin/events/dead-letters", app.GetDeadLetters)
+	mux.Post("/api/v1/admin/events/dead-letters/replay", app.ReplayDeadLetters)
+	mux.Post("/api/v1/admin/events/dead-letters/purge", app.PurgeDeadLetters)
+
 	return mux
 }
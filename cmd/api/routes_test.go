@@ -48,6 +48,65 @@ func Test_routes_exist(t *testing.T) {
 		"/api/v1/inventory/category",
 		"/api/v1/inventory/rating",
 		"/api/v1/inventory/rating-user",
+		"/api/v1/chat/notification-preference",
+		"/api/v1/health",
+		"/api/v1/events/stream",
+		"/api/v1/booking/quote",
+		"/api/v1/inventory/availability-calendar",
+		"/api/v1/booking/accept",
+		"/api/v1/booking/reject",
+		"/api/v1/booking/cancel",
+		"/api/v1/booking/start",
+		"/api/v1/booking/complete",
+		"/api/v1/booking/change/request",
+		"/api/v1/booking/change/approve",
+		"/api/v1/booking/change/decline",
+		"/api/v1/booking/change/withdraw",
+		"/api/v1/booking/changes",
+		"/api/v1/booking/detail",
+		"/api/v1/booking/deposit/collect",
+		"/api/v1/booking/deposit/verify",
+		"/api/v1/booking/deposit/release",
+		"/api/v1/booking/deposit/deduct",
+		"/api/v1/calendar/feed-token",
+		"/api/v1/calendar/feed/{token}.ics",
+		"/api/v1/calendar/import",
+		"/api/v1/purchase/accept",
+		"/api/v1/purchase/decline",
+		"/api/v1/purchase/ship",
+		"/api/v1/purchase/ready-for-pickup",
+		"/api/v1/purchase/confirm-received",
+		"/api/v1/purchase/cancel",
+		"/api/v1/purchase/order-counts",
+		"/api/v1/cart",
+		"/api/v1/cart/add",
+		"/api/v1/cart/update",
+		"/api/v1/cart/remove",
+		"/api/v1/cart/checkout",
+		"/api/v1/cart/checkout/pay",
+		"/api/v1/invoices/download",
+		"/api/v1/invoices/email",
+		"/api/v1/exports/stream",
+		"/api/v1/exports",
+		"/api/v1/exports/status",
+		"/api/v1/exports/download",
+		"/api/v1/disputes/open",
+		"/api/v1/disputes/message",
+		"/api/v1/disputes/escalate",
+		"/api/v1/disputes/withdraw",
+		"/api/v1/disputes",
+		"/api/v1/disputes/detail",
+		"/api/v1/admin/jobs",
+		"/api/v1/admin/disputes",
+		"/api/v1/admin/disputes/resolve",
+		"/api/v1/notifications",
+		"/api/v1/notifications/unread-count",
+		"/api/v1/notifications/mark-read",
+		"/api/v1/notifications/mark-all-read",
+		"/api/v1/notifications/preferences",
+		"/api/v1/admin/events/dead-letters",
+		"/api/v1/admin/events/dead-letters/replay",
+		"/api/v1/admin/events/dead-letters/purge",
 		"/metrics",
 	}
 
@@ -9,6 +9,9 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
+
+	"github.com/obynonwane/broker-service/event"
 )
 
 type CreatePrurchaseOrderPayload struct {
@@ -18,6 +21,9 @@ type CreatePrurchaseOrderPayload struct {
 	OfferPricePerUnit float64 `json:"offer_price_per_unit" binding:"required"`
 	Quantity          float64 `json:"quantity" binding:"required"`
 	TotalAmount       float64 `json:"total_amount" binding:"required"`
+	// the accepted offer backing a price below the listed one, create-order gets it so the inventory
+	// service can mark the offer used, the broker also claims it so it backs one order
+	OfferID string `json:"offer_id"`
 }
 
 func (app *Config) CreatePrurchaseOrder(w http.ResponseWriter, r *http.Request) {
@@ -53,6 +59,55 @@ func (app *Config) CreatePrurchaseOrder(w http.ResponseWriter, r *http.Request)
 	userId := user.Data.(map[string]interface{})["user"].(map[string]interface{})["id"].(string)
 	requestPayload.BuyerId = userId
 
+	item, err := app.getInventory(requestPayload.InventoryId)
+	if err != nil {
+		app.errorJSON(w, err, nil)
+		return
+	}
+
+	var offer *PurchaseOffer
+	if requestPayload.OfferID != "" {
+		found, err := app.getPurchaseOffer(requestPayload.OfferID)
+		if err != nil {
+			app.errorJSON(w, err, nil, http.StatusBadRequest)
+			return
+		}
+		offer = &found
+	}
+
+	// price the order ourselves, the client totals must match
+	quote, err := quoteSale(item, userId, requestPayload.Quantity, requestPayload.OfferPricePerUnit, offer, time.Now())
+	if err != nil {
+		app.errorJSON(w, err, nil, http.StatusBadRequest)
+		return
+	}
+
+	if mismatch := quote.checkSubmittedTotals(requestPayload.OfferPricePerUnit, requestPayload.TotalAmount); len(mismatch) > 0 {
+		app.errorJSON(w, errors.New("order totals do not match the quote"), map[string]interface{}{"errors": mismatch, "quote": quote}, http.StatusBadRequest)
+		return
+	}
+
+	// the seller and price come from the inventory, not the client
+	requestPayload.SellerId = quote.SellerID
+	requestPayload.OfferPricePerUnit = quote.UnitPrice
+	requestPayload.TotalAmount = quote.TotalAmount
+	requestPayload.OfferID = quote.OfferID
+
+	// an offer backs one order, it is free again if the order is not created
+	orderCreated := false
+	if quote.OfferID != "" {
+		release, err := app.claimOffer(r.Context(), *offer)
+		if err != nil {
+			app.errorJSON(w, err, nil, http.StatusConflict)
+			return
+		}
+		defer func() {
+			if !orderCreated {
+				release()
+			}
+		}()
+	}
+
 	//create some json we will send to authservice
 	jsonData, _ := json.MarshalIndent(requestPayload, "", "\t")
 
@@ -93,6 +148,17 @@ func (app *Config) CreatePrurchaseOrder(w http.ResponseWriter, r *http.Request)
 		app.errorJSON(w, errors.New(jsonFromService.Message), nil, response.StatusCode)
 		return
 	}
+	orderCreated = true
+
+	// record the event before answering, the outbox relay publishes it
+	app.recordEventOrPublish(r.Context(), event.OrderCreatedEvent{
+		OrderID:     createdID(jsonFromService.Data),
+		InventoryID: requestPayload.InventoryId,
+		BuyerID:     requestPayload.BuyerId,
+		SellerID:    requestPayload.SellerId,
+		Quantity:    requestPayload.Quantity,
+		TotalAmount: requestPayload.TotalAmount,
+	}, correlationID(r))
 
 	var payload jsonResponse
 	payload.Error = jsonFromService.Error
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Schedule decides when a job runs next
+type Schedule interface {
+	// Next returns the first run strictly after after
+	Next(after time.Time) time.Time
+	String() string
+}
+
+// every runs a job at a fixed interval, aligned to the epoch so every replica picks the same slots
+type every struct {
+	interval time.Duration
+}
+
+func Every(interval time.Duration) Schedule {
+	return every{interval: interval}
+}
+
+func (e every) Next(after time.Time) time.Time {
+	return after.Truncate(e.interval).Add(e.interval)
+}
+
+func (e every) String() string {
+	return fmt.Sprintf("every %s", e.interval)
+}
+
+// cronSchedule is a standard five field cron expression: minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+	loc                           *time.Location
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = []cronField{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// Cron parses spec, read in loc. Fields take *, a value, a range a-b, a step */n or a-b/n and lists of those.
+func Cron(spec string, loc *time.Location) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron %q must have %d fields", spec, len(cronFields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		bits[i], err = parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", spec, err)
+		}
+	}
+
+	// sunday is both 0 and 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &cronSchedule{
+		spec:          spec,
+		minute:        bits[0],
+		hour:          bits[1],
+		dom:           bits[2],
+		month:         bits[3],
+		dow:           bits[4],
+		domRestricted: fields[2] != "*",
+		dowRestricted: fields[4] != "*",
+		loc:           loc,
+	}, nil
+}
+
+// MustCron is Cron for specs written in code
+func MustCron(spec string, loc *time.Location) Schedule {
+	schedule, err := Cron(spec, loc)
+	if err != nil {
+		panic(err)
+	}
+
+	return schedule
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		low, high := bounds.min, bounds.max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			low, err = strconv.Atoi(lowPart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+
+			high = low
+			if isRange {
+				high, err = strconv.Atoi(highPart)
+				if err != nil {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+			} else if hasStep {
+				high = bounds.max
+			}
+		}
+
+		if low < bounds.min || high > bounds.max || low > high {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, bounds.min, bounds.max)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+
+	// a valid expression matches within a few years, give up after that
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows cron: when both day fields are restricted either of them may match
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case c.domRestricted && c.dowRestricted:
+		return domMatch || dowMatch
+	case c.domRestricted:
+		return domMatch
+	case c.dowRestricted:
+		return dowMatch
+	default:
+		return true
+	}
+}
+
+func (c *cronSchedule) String() string {
+	return c.spec
+}
+
+// JobLock makes sure only one replica does something, e.g. runs a slot of a job
+type JobLock interface {
+	// Acquire claims key for ttl, it reports false when another replica already has it
+	Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+type redisJobLock struct {
+	cache *redis.Client
+}
+
+func (l redisJobLock) Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return l.cache.SetNX(ctx, key, 1, ttl).Result()
+}
+
+// Job is a task run on a schedule by one replica at a time
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Timeout  time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// JobStatus is what this replica knows about a job
+type JobStatus struct {
+	Name         string    `json:"name"`
+	Schedule     string    `json:"schedule"`
+	NextRun      time.Time `json:"next_run"`
+	LastRun      time.Time `json:"last_run,omitempty"`
+	LastDuration string    `json:"last_duration,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	Runs         int       `json:"runs"`
+	Running      bool      `json:"running"`
+}
+
+type scheduledJob struct {
+	Job
+	status JobStatus
+}
+
+// Scheduler runs registered jobs on their schedules. Every replica runs a scheduler,
+// the lock lets only one of them run each slot of a job.
+type Scheduler struct {
+	lock JobLock
+	now  func() time.Time
+
+	mu   sync.Mutex
+	jobs []*scheduledJob
+	wake chan struct{}
+}
+
+func NewScheduler(lock JobLock) *Scheduler {
+	return &Scheduler{lock: lock, now: time.Now, wake: make(chan struct{}, 1)}
+}
+
+// Register adds a job, it can be called before or after Start
+func (s *Scheduler) Register(job Job) {
+	if job.Timeout <= 0 {
+		job.Timeout = 5 * time.Minute
+	}
+
+	s.mu.Lock()
+	s.jobs = append(s.jobs, &scheduledJob{
+		Job: job,
+		status: JobStatus{
+			Name:     job.Name,
+			Schedule: job.Schedule.String(),
+			NextRun:  job.Schedule.Next(s.now()),
+		},
+	})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Once claims key for ttl across replicas, jobs use it so a reminder is only sent once
+func (s *Scheduler) Once(ctx context.Context, key string, ttl time.Duration) bool {
+	claimed, err := s.lock.Acquire(ctx, key, ttl)
+	if err != nil {
+		log.Printf("[JOBS] claiming %s failed: %v", key, err)
+		return false
+	}
+
+	return claimed
+}
+
+// Start runs due jobs until ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	for {
+		wait := time.Minute
+		if next, ok := s.nextRun(); ok {
+			wait = max(next.Sub(s.now()), 0)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+			s.runDue(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) nextRun() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, job := range s.jobs {
+		if job.status.NextRun.IsZero() {
+			continue
+		}
+		if next.IsZero() || job.status.NextRun.Before(next) {
+			next = job.status.NextRun
+		}
+	}
+
+	return next, !next.IsZero()
+}
+
+// runDue starts every job whose slot has come, a job still running from its last slot skips this one
+func (s *Scheduler) runDue(ctx context.Context) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		slot := job.status.NextRun
+		if slot.IsZero() || slot.After(now) {
+			continue
+		}
+
+		job.status.NextRun = job.Schedule.Next(now)
+		if job.status.Running {
+			log.Printf("[JOBS] %s is still running, skipping the %s run", job.Name, slot.Format(time.RFC3339))
+			continue
+		}
+
+		job.status.Running = true
+		go s.runJob(ctx, job, slot)
+	}
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job *scheduledJob, slot time.Time) {
+	defer func() {
+		s.mu.Lock()
+		job.status.Running = false
+		s.mu.Unlock()
+	}()
+
+	key := fmt.Sprintf("jobs:run:%s:%d", job.Name, slot.Unix())
+	claimed, err := s.lock.Acquire(ctx, key, job.Timeout+time.Minute)
+	if err != nil {
+		log.Printf("[JOBS] claiming %s failed: %v", job.Name, err)
+		return
+	}
+	if !claimed {
+		// another replica has this slot
+		return
+	}
+
+	started := s.now()
+	err = s.safeRun(ctx, job)
+
+	s.mu.Lock()
+	job.status.LastRun = started
+	job.status.LastDuration = s.now().Sub(started).Round(time.Millisecond).String()
+	job.status.LastError = ""
+	if err != nil {
+		job.status.LastError = err.Error()
+	}
+	job.status.Runs++
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("[JOBS] %s failed: %v", job.Name, err)
+	}
+}
+
+func (s *Scheduler) safeRun(ctx context.Context, job *scheduledJob) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	err = job.Run(ctx)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s: %w", job.Timeout, ctx.Err())
+	}
+
+	return err
+}
+
+// Statuses returns every job sorted by name
+func (s *Scheduler) Statuses() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		statuses = append(statuses, job.status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
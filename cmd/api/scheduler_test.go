@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronSchedule(t *testing.T) {
+	lagos, _ := time.LoadLocation(defaultBookingTimezone)
+
+	t.Log("Checking a daily schedule runs at its time in its timezone")
+	daily := MustCron("0 8 * * *", lagos)
+	next := daily.Next(time.Date(2030, 6, 15, 8, 0, 0, 0, lagos))
+	assert.Equal(t, time.Date(2030, 6, 16, 8, 0, 0, 0, lagos), next)
+
+	t.Log("Checking steps, ranges and lists")
+	quarter := MustCron("*/15 9-17 * * 1-5", time.UTC)
+	// saturday evening runs next on monday morning
+	next = quarter.Next(time.Date(2030, 6, 15, 18, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2030, 6, 17, 9, 0, 0, 0, time.UTC), next)
+	next = quarter.Next(next)
+	assert.Equal(t, time.Date(2030, 6, 17, 9, 15, 0, 0, time.UTC), next)
+
+	t.Log("Checking either restricted day field may match")
+	monthly := MustCron("0 0 1 * 0", time.UTC)
+	next = monthly.Next(time.Date(2030, 6, 15, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2030, 6, 16, 0, 0, 0, 0, time.UTC), next) // a sunday
+
+	t.Log("Checking bad expressions are refused")
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := Cron(spec, time.UTC)
+		assert.Error(t, err, spec)
+	}
+
+	t.Log("Checking intervals are aligned so replicas pick the same slots")
+	next = Every(15 * time.Minute).Next(time.Date(2030, 6, 15, 8, 7, 30, 0, time.UTC))
+	assert.Equal(t, time.Date(2030, 6, 15, 8, 15, 0, 0, time.UTC), next)
+}
+
+func TestSchedulerRunsEachSlotOnce(t *testing.T) {
+	lock := newLocalJobLock()
+	clock := time.Date(2030, 6, 15, 8, 0, 0, 0, time.UTC)
+
+	// two replicas sharing a lock
+	replicas := []*Scheduler{NewScheduler(lock), NewScheduler(lock)}
+
+	var runs int32
+	for _, s := range replicas {
+		s.now = func() time.Time { return clock }
+		s.Register(Job{
+			Name:     "test-job",
+			Schedule: Every(time.Minute),
+			Run: func(ctx context.Context) error {
+				atomic.AddInt32(&runs, 1)
+				return nil
+			},
+		})
+	}
+
+	clock = clock.Add(time.Minute)
+	for _, s := range replicas {
+		s.runDue(context.Background())
+	}
+
+	// wait for both replicas to finish the slot
+	assert.Eventually(t, func() bool {
+		for _, s := range replicas {
+			if s.Statuses()[0].Running {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	t.Log("Checking only one replica ran the slot")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+	assert.Equal(t, clock.Add(time.Minute), replicas[0].Statuses()[0].NextRun)
+
+	t.Log("Checking once claims a key a single time")
+	assert.True(t, replicas[0].Once(context.Background(), "key", time.Minute))
+	assert.False(t, replicas[1].Once(context.Background(), "key", time.Minute))
+}
+
+// localJobLock stands in for redis in tests, it only guards this process
+type localJobLock struct {
+	mu   sync.Mutex
+	held map[string]time.Time
+}
+
+func newLocalJobLock() *localJobLock {
+	return &localJobLock{held: make(map[string]time.Time)}
+}
+
+func (l *localJobLock) Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for k, expiresAt := range l.held {
+		if now.After(expiresAt) {
+			delete(l.held, k)
+		}
+	}
+
+	if _, ok := l.held[key]; ok {
+		return false, nil
+	}
+
+	l.held[key] = now.Add(ttl)
+	return true, nil
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"os"
 	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 )
 
 // Special function to setup and tear down testing environments
@@ -14,3 +17,9 @@ func TestMain(m *testing.M) {
 	//whether the tests passed or failed
 	os.Exit(m.Run()) // run all of my test
 }
+
+// newTestRedis starts an in-memory redis for one test, the redis stores are tested against it
+func newTestRedis(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrUpstreamDisabled is returned for a call to an upstream endpoint that is not switched on yet
+var ErrUpstreamDisabled = errors.New("this feature is not available yet")
+
+// upstreamEndpoints are the endpoints the broker needs from the inventory and payment services beyond the
+// ones they already serve. Each one is only called once it is listed in UPSTREAM_ENDPOINTS (comma separated),
+// so a service that hasn't deployed it yet answers 503 instead of failing in the middle of a flow.
+// Requests are json posts, dates are YYYY-MM-DD and times RFC 3339, answers come back as 202 in the usual
+// jsonResponse with the result in data.
+var upstreamEndpoints = map[string]string{
+	// inventory service
+	"booking-detail":        "{booking_id} returns a BookingSnapshot",
+	"update-booking-status": "UpdateBookingStatusPayload, rejected when from_status is stale",
+	"update-booking-dates":  "UpdateBookingDatesPayload, rejected when from_status is stale",
+	"inventory-bookings":    "{inventory_id, from, to, statuses} returns the BookingSnapshots overlapping from-to",
+	"owner-bookings":        "{owner_id, statuses} returns BookingSnapshots",
+	"bookings-between":      "{statuses, field, from, to} returns the BookingSnapshots whose field falls between from and to",
+	"pending-bookings":      "{created_before} returns BookingSnapshots",
+	"order-detail":          "{order_id} returns an OrderSnapshot",
+	"update-order-status":   "UpdateOrderStatusPayload, rejected when from_status is stale",
+	"order-status-counts":   "{user_id, role} returns a count per OrderStatus",
+	"offer-detail":          "{offer_id} returns a PurchaseOffer",
+	"create-cart-orders":    "{checkout_reference, buyer_id, orders} returns [{id, seller_id}]",
+	"unanswered-chats":      "{older_than} returns UnansweredChats",
+
+	// payment service
+	"initialize-checkout":    "{reference, buyer_id, email, amount, orders} returns {authorization_url}",
+	"initialize-deposit":     "{booking_id, reference, renter_id, owner_id, amount, email} returns {authorization_url}",
+	"verify-deposit":         "{reference} returns {status}",
+	"settle-deposit":         "{booking_id, reference, idempotency_key, renter_id, owner_id, amount, deducted_amount, refund_amount, reason}",
+	"refund-payment":         "{reference, subject_type, subject_id, user_id, amount, reason}, reference is the idempotency key",
+	"subscription-payment":   "{payment_id} returns a SubscriptionPayment",
+	"renewing-subscriptions": "{from, to} returns RenewingSubscriptions",
+}
+
+// upstreamEnabled reports whether endpoint may be called, endpoints the services already serve always may
+func upstreamEnabled(endpoint string) bool {
+	if _, ok := upstreamEndpoints[endpoint]; !ok {
+		return true
+	}
+
+	for _, enabled := range strings.Split(os.Getenv("UPSTREAM_ENDPOINTS"), ",") {
+		if strings.TrimSpace(enabled) == endpoint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// upstreamEndpoint is the endpoint a service url points at
+func upstreamEndpoint(url string) string {
+	return path.Base(url)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamEndpoints(t *testing.T) {
+	t.Setenv("UPSTREAM_ENDPOINTS", "order-detail, booking-detail")
+
+	t.Log("Checking endpoints the services already serve are always called")
+	assert.True(t, upstreamEnabled("create-booking"))
+
+	t.Log("Checking new endpoints are only called once listed")
+	assert.True(t, upstreamEnabled("booking-detail"))
+	assert.False(t, upstreamEnabled("inventory-bookings"))
+
+	t.Log("Checking a call to an endpoint that is off is answered with 503 without reaching the service")
+	app := Config{}
+	response, err := app.callService("http://inventory.invalid/inventory-bookings", map[string]string{})
+	assert.ErrorIs(t, err, ErrUpstreamDisabled)
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+}
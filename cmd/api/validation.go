@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"regexp"
+	"slices"
 	"time"
 )
 
@@ -18,6 +20,7 @@ const (
 	minCommentLen   = 5
 	tokenMinLen     = 30
 	descLen         = 100
+	maxReasonLen    = 500
 )
 
 func (app *Config) ValidateLoginInput(req LoginPayload) map[string]string {
@@ -252,27 +255,25 @@ func (app *Config) ValidateBookingInput(req CreateBookingPayload) map[string]str
 		errors["quantity"] = "quantity must be greater than zero"
 	}
 
-	// Validate date formats (assuming YYYY-MM-DD)
-	if _, err := time.Parse("2006-01-02", req.StartDate); err != nil {
-		errors["start_date"] = "start_date must be in YYYY-MM-DD format"
+	if !RentalDuration(req.RentalType).IsValid() {
+		errors["rental_type"] = "rental_type must be either hourly, daily, monthly or annually"
+		return errors
 	}
 
-	if _, err := time.Parse("2006-01-02", req.EndDate); err != nil {
-		errors["end_date"] = "end_date must be in YYYY-MM-DD format"
+	// dates are YYYY-MM-DD, times HH:MM or HH:MM:SS, read in the booking timezone
+	_, units, windowErrors := validateBookingWindow(RentalDuration(req.RentalType), BookingTimes{
+		StartDate: req.StartDate,
+		StartTime: req.StartTime,
+		EndDate:   req.EndDate,
+		EndTime:   req.EndTime,
+		Timezone:  req.Timezone,
+	}, time.Now())
+	for field, message := range windowErrors {
+		errors[field] = message
 	}
 
-	// EndTime is optional, but if provided validate it (HH:MM:SS)
-	if req.EndTime != "" {
-		if _, err := time.Parse("15:04", req.EndTime); err != nil {
-			errors["end_time"] = "end_time must be in HH:MM format"
-		}
-	}
-
-	// EndTime is optional, but if provided validate it (HH:MM:SS)
-	if req.StartTime != "" {
-		if _, err := time.Parse("15:04", req.EndTime); err != nil {
-			errors["start_time"] = "start_time must be in HH:MM format"
-		}
+	if len(windowErrors) == 0 && req.RentalDuration > 0 && !withinTolerance(req.RentalDuration, units) {
+		errors["rental_duration"] = fmt.Sprintf("rental_duration should be %g for the dates supplied", units)
 	}
 
 	if req.TotalAmount <= 0 {
@@ -304,6 +305,144 @@ func (app *Config) ValidatePuchaseOrderInput(req CreatePrurchaseOrderPayload) ma
 	return errors
 }
 
+func (app *Config) ValidateChatNotificationPreferenceInput(req UpdateChatNotificationPreferencePayload) map[string]string {
+	errors := map[string]string{}
+
+	// quiet hours are optional, but both ends must be supplied together (HH:MM)
+	if (req.QuietHoursStart == "") != (req.QuietHoursEnd == "") {
+		errors["quiet_hours"] = "quiet_hours_start and quiet_hours_end must be supplied together"
+	}
+
+	if req.QuietHoursStart != "" {
+		if _, err := time.Parse("15:04", req.QuietHoursStart); err != nil {
+			errors["quiet_hours_start"] = "quiet_hours_start must be in HH:MM format"
+		}
+	}
+
+	if req.QuietHoursEnd != "" {
+		if _, err := time.Parse("15:04", req.QuietHoursEnd); err != nil {
+			errors["quiet_hours_end"] = "quiet_hours_end must be in HH:MM format"
+		}
+	}
+
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			errors["timezone"] = "timezone supplied is invalid"
+		}
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateBookingQuoteInput(req BookingQuotePayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.InventoryId) == 0 {
+		errors["inventory_id"] = "inventory_id is required"
+	}
+
+	if !RentalDuration(req.RentalType).IsValid() {
+		errors["rental_type"] = "rental_type must be either hourly, daily, monthly or annually"
+	}
+
+	if req.Quantity <= 0 {
+		errors["quantity"] = "quantity must be greater than zero"
+	}
+
+	if req.OfferPricePerUnit < 0 {
+		errors["offer_price_per_unit"] = "offer_price_per_unit cannot be negative"
+	}
+
+	if len(req.StartDate) == 0 {
+		errors["start_date"] = "start_date is required"
+	}
+
+	if len(req.EndDate) == 0 {
+		errors["end_date"] = "end_date is required"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+
+	_, _, windowErrors := validateBookingWindow(RentalDuration(req.RentalType), BookingTimes{
+		StartDate: req.StartDate,
+		StartTime: req.StartTime,
+		EndDate:   req.EndDate,
+		EndTime:   req.EndTime,
+		Timezone:  req.Timezone,
+	}, time.Now())
+	for field, message := range windowErrors {
+		errors[field] = message
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateBookingTransitionInput(req BookingTransitionPayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.BookingID) == 0 {
+		errors["booking_id"] = "booking_id is required"
+	}
+
+	if len(req.Reason) > maxReasonLen {
+		errors["reason"] = fmt.Sprintf("reason length should be at most %d characters", maxReasonLen)
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateOrderTransitionInput(req OrderTransitionPayload, action OrderAction) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.OrderID) == 0 {
+		errors["order_id"] = "order_id is required"
+	}
+
+	if len(req.Reason) > maxReasonLen {
+		errors["reason"] = fmt.Sprintf("reason length should be at most %d characters", maxReasonLen)
+	}
+
+	switch action.Fulfilment {
+	case orderShipping:
+		if len(req.Carrier) == 0 {
+			errors["carrier"] = "carrier is required"
+		}
+		if len(req.TrackingNumber) == 0 {
+			errors["tracking_number"] = "tracking_number is required"
+		}
+		if req.TrackingURL != "" && !isHTTPURL(req.TrackingURL) {
+			errors["tracking_url"] = "tracking_url must be an http or https url"
+		}
+	case orderPickup:
+		if len(req.PickupAddress) == 0 {
+			errors["pickup_address"] = "pickup_address is required"
+		}
+		if len(req.PickupNote) > maxReasonLen {
+			errors["pickup_note"] = fmt.Sprintf("pickup_note length should be at most %d characters", maxReasonLen)
+		}
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateNotificationPreferencesInput(req UpdateNotificationPreferencesPayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.Preferences) == 0 {
+		errors["preferences"] = "preferences are required"
+	}
+
+	for notificationType := range req.Preferences {
+		if !slices.Contains(notificationTypes, notificationType) {
+			errors[notificationType] = "notification type is not supported"
+		}
+	}
+
+	return errors
+}
+
 type ProductPurpose string
 type AvailabilityStatus string
 type RentalDuration string
@@ -345,3 +484,261 @@ func (d RentalDuration) IsValid() bool {
 func (n NegotiableStatus) IsValid() bool {
 	return n == Negotiable || n == NonNegotiable
 }
+
+func (app *Config) ValidateBookingChangeInput(req BookingChangePayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.BookingID) == 0 {
+		errors["booking_id"] = "booking_id is required"
+	}
+
+	if len(req.StartDate) == 0 {
+		errors["start_date"] = "start_date is required"
+	}
+
+	if len(req.EndDate) == 0 {
+		errors["end_date"] = "end_date is required"
+	}
+
+	if len(req.Reason) > maxReasonLen {
+		errors["reason"] = fmt.Sprintf("reason length should be at most %d characters", maxReasonLen)
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateBookingChangeDecisionInput(req BookingChangeDecisionPayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.ChangeID) == 0 {
+		errors["change_id"] = "change_id is required"
+	}
+
+	if len(req.Reason) > maxReasonLen {
+		errors["reason"] = fmt.Sprintf("reason length should be at most %d characters", maxReasonLen)
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateCalendarImportInput(req CalendarImportPayload, uploaded bool) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.InventoryID) == 0 {
+		errors["inventory_id"] = "inventory_id is required"
+	}
+
+	if !uploaded && !isCalendarURL(req.URL) {
+		errors["url"] = "url must be an http, https or webcal url when no calendar file is uploaded"
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateDepositInput(req DepositPayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.BookingID) == 0 {
+		errors["booking_id"] = "booking_id is required"
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateDepositDeductionInput(req DepositDeductionPayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.BookingID) == 0 {
+		errors["booking_id"] = "booking_id is required"
+	}
+
+	if req.Amount <= 0 {
+		errors["amount"] = "amount must be greater than zero"
+	}
+
+	if len(req.Reason) == 0 {
+		errors["reason"] = "reason is required"
+	} else if len(req.Reason) > maxReasonLen {
+		errors["reason"] = fmt.Sprintf("reason length should be at most %d characters", maxReasonLen)
+	}
+
+	if len(req.Evidence) == 0 || len(req.Evidence) > maxDepositEvidence {
+		errors["evidence"] = fmt.Sprintf("between 1 and %d evidence urls are required", maxDepositEvidence)
+	}
+
+	if !validEvidence(req.Evidence) {
+		errors["evidence"] = "evidence must be http or https urls"
+	}
+
+	return errors
+}
+
+// validEvidence reports whether every evidence entry is an http or https url
+func validEvidence(evidence []string) bool {
+	for _, e := range evidence {
+		if !isHTTPURL(e) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHTTPURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func (app *Config) ValidateCartItemInput(req CartItemPayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.InventoryID) == 0 {
+		errors["inventory_id"] = "inventory_id is required"
+	}
+
+	if req.Quantity <= 0 {
+		errors["quantity"] = "quantity must be greater than zero"
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateOpenDisputeInput(req OpenDisputePayload) map[string]string {
+	errors := map[string]string{}
+
+	if req.SubjectType != DisputeBooking && req.SubjectType != DisputeOrder {
+		errors["subject_type"] = fmt.Sprintf("subject_type must be %s or %s", DisputeBooking, DisputeOrder)
+	}
+
+	if len(req.SubjectID) == 0 {
+		errors["subject_id"] = "subject_id is required"
+	}
+
+	if len(req.Description) < minCommentLen {
+		errors["description"] = fmt.Sprintf("description length should be at least %d characters", minCommentLen)
+	} else if len(req.Description) > maxDisputeTextLen {
+		errors["description"] = fmt.Sprintf("description length should be at most %d characters", maxDisputeTextLen)
+	}
+
+	if len(req.Evidence) > maxDisputeEvidence {
+		errors["evidence"] = fmt.Sprintf("at most %d evidence urls are allowed", maxDisputeEvidence)
+	} else if !validEvidence(req.Evidence) {
+		errors["evidence"] = "evidence must be http or https urls"
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateDisputeMessageInput(req DisputeMessagePayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.DisputeID) == 0 {
+		errors["dispute_id"] = "dispute_id is required"
+	}
+
+	if len(req.Body) == 0 {
+		errors["body"] = "body is required"
+	} else if len(req.Body) > maxDisputeTextLen {
+		errors["body"] = fmt.Sprintf("body length should be at most %d characters", maxDisputeTextLen)
+	}
+
+	if len(req.Evidence) > maxDisputeEvidence {
+		errors["evidence"] = fmt.Sprintf("at most %d evidence urls are allowed", maxDisputeEvidence)
+	} else if !validEvidence(req.Evidence) {
+		errors["evidence"] = "evidence must be http or https urls"
+	}
+
+	if req.AwaitFrom != "" && req.AwaitFrom != disputeClaimant && req.AwaitFrom != disputeRespondent {
+		errors["await_from"] = fmt.Sprintf("await_from must be %s or %s", disputeClaimant, disputeRespondent)
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateDisputeActionInput(req DisputeActionPayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.DisputeID) == 0 {
+		errors["dispute_id"] = "dispute_id is required"
+	}
+
+	if len(req.Reason) > maxReasonLen {
+		errors["reason"] = fmt.Sprintf("reason length should be at most %d characters", maxReasonLen)
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateResolveDisputeInput(req ResolveDisputePayload) map[string]string {
+	errors := map[string]string{}
+
+	if len(req.DisputeID) == 0 {
+		errors["dispute_id"] = "dispute_id is required"
+	}
+
+	if !slices.Contains(disputeOutcomes, req.Outcome) {
+		errors["outcome"] = fmt.Sprintf("outcome must be one of %v", disputeOutcomes)
+	}
+
+	if req.Amount < 0 {
+		errors["amount"] = "amount cannot be negative"
+	}
+
+	if len(req.Reason) == 0 {
+		errors["reason"] = "reason is required"
+	} else if len(req.Reason) > maxReasonLen {
+		errors["reason"] = fmt.Sprintf("reason length should be at most %d characters", maxReasonLen)
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateInvoiceInput(req InvoicePayload) map[string]string {
+	errors := map[string]string{}
+
+	if !slices.Contains(invoiceSubjects, req.SubjectType) {
+		errors["subject_type"] = fmt.Sprintf("subject_type must be one of %v", invoiceSubjects)
+	}
+
+	if len(req.SubjectID) == 0 {
+		errors["subject_id"] = "subject_id is required"
+	}
+
+	if req.Format != "" && req.Format != invoiceFormatPDF && req.Format != invoiceFormatHTML {
+		errors["format"] = fmt.Sprintf("format must be %s or %s", invoiceFormatPDF, invoiceFormatHTML)
+	}
+
+	return errors
+}
+
+func (app *Config) ValidateExportInput(req ExportPayload) map[string]string {
+	errors := map[string]string{}
+
+	if !slices.Contains(exportKinds, req.Kind) {
+		errors["kind"] = fmt.Sprintf("kind must be one of %v", exportKinds)
+	}
+
+	if !slices.Contains(exportFormats, req.Format) {
+		errors["format"] = fmt.Sprintf("format must be one of %v", exportFormats)
+	}
+
+	var from, to time.Time
+	var err error
+	if req.From != "" {
+		if from, err = time.Parse(exportDateLayout, req.From); err != nil {
+			errors["from"] = "from must be a date like 2006-01-02"
+		}
+	}
+
+	if req.To != "" {
+		if to, err = time.Parse(exportDateLayout, req.To); err != nil {
+			errors["to"] = "to must be a date like 2006-01-02"
+		}
+	}
+
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		errors["to"] = "to must not be before from"
+	}
+
+	return errors
+}
@@ -1,26 +1,82 @@
 package event
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// HandlerFunc handles a single event, returning an error nacks the delivery
+type HandlerFunc func(ctx context.Context, e Envelope) error
+
+// Typed adapts a handler that works on a decoded payload of type T
+func Typed[T any](fn func(ctx context.Context, e Envelope, payload T) error) HandlerFunc {
+	return func(ctx context.Context, e Envelope) error {
+		var payload T
+		if err := e.Decode(&payload); err != nil {
+			return fmt.Errorf("decoding %s payload: %w", e.Type, err)
+		}
+
+		return fn(ctx, e, payload)
+	}
+}
+
+// ConsumerConfig tunes how a consumer reads from rabbitmq
+type ConsumerConfig struct {
+	// QueueName is the durable queue to consume from, it gets retry and dead-letter queues.
+	// Empty means an exclusive server-named queue without retries.
+	QueueName string
+	// Prefetch is how many unacknowledged deliveries rabbitmq hands us at once
+	Prefetch int
+	// Workers is how many deliveries are handled concurrently
+	Workers int
+	// Retry controls retries of failed deliveries on a named queue
+	Retry RetryPolicy
+}
+
+const (
+	defaultPrefetch = 20
+	defaultWorkers  = 5
+)
+
 type Consumer struct {
 	conn      *amqp.Connection
 	queueName string
+	prefetch  int
+	workers   int
+	retry     RetryPolicy
+
+	mu       sync.RWMutex
+	handlers map[EventType]HandlerFunc
+	fallback HandlerFunc
 }
 
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
-	consumer := Consumer{
-		conn: conn,
+func NewConsumer(conn *amqp.Connection, config ConsumerConfig) (*Consumer, error) {
+	consumer := &Consumer{
+		conn:      conn,
+		queueName: config.QueueName,
+		prefetch:  config.Prefetch,
+		workers:   config.Workers,
+		retry:     config.Retry.withDefaults(),
+		handlers:  make(map[EventType]HandlerFunc),
+		fallback:  logEvent,
+	}
+
+	if consumer.prefetch <= 0 {
+		consumer.prefetch = defaultPrefetch
+	}
+
+	if consumer.workers <= 0 {
+		consumer.workers = defaultWorkers
 	}
 
 	err := consumer.setup()
 	if err != nil {
-		return Consumer{}, err
+		return nil, err
 	}
 
 	return consumer, nil
@@ -31,29 +87,67 @@ func (consumer *Consumer) setup() error {
 	if err != nil {
 		return err
 	}
+	defer channel.Close()
 
 	return declareExchange(channel)
 }
 
+// Payload is the legacy event shape, see ParseEnvelope
 type Payload struct {
 	Name string `json:"name"`
 	Data string `json:"data"`
 }
 
-func (consumer *Consumer) Listen(topics []string) error {
+// Register sets the handler for eventType, replacing any previous one
+func (consumer *Consumer) Register(eventType EventType, handler HandlerFunc) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	consumer.handlers[eventType] = handler
+}
+
+// RegisterFallback sets the handler for events without a registered handler, by default they are logged
+func (consumer *Consumer) RegisterFallback(handler HandlerFunc) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	consumer.fallback = handler
+}
+
+// Topics returns the event types with a registered handler, handy as Listen topics
+func (consumer *Consumer) Topics() []string {
+	consumer.mu.RLock()
+	defer consumer.mu.RUnlock()
+
+	topics := make([]string, 0, len(consumer.handlers))
+	for eventType := range consumer.handlers {
+		topics = append(topics, string(eventType))
+	}
+
+	return topics
+}
+
+// Listen binds the queue to topics and handles deliveries with a bounded pool of workers.
+// It returns when ctx is cancelled or the channel closes (e.g. the connection dropped).
+func (consumer *Consumer) Listen(ctx context.Context, topics []string) error {
 	ch, err := consumer.conn.Channel()
 	if err != nil {
 		return err
 	}
 	defer ch.Close()
 
-	q, err := declareRandomQueue(ch)
+	err = ch.Qos(consumer.prefetch, 0, false)
+	if err != nil {
+		return err
+	}
+
+	q, err := consumer.declareQueue(ch)
 	if err != nil {
 		return err
 	}
 
 	for _, s := range topics {
-		ch.QueueBind(
+		err = ch.QueueBind(
 			q.Name,
 			s,
 			"logs_topic",
@@ -62,55 +156,90 @@ func (consumer *Consumer) Listen(topics []string) error {
 		)
 
 		if err != nil {
-			return err
+			return fmt.Errorf("binding %s to %s: %w", q.Name, s, err)
 		}
 	}
 
-	messages, err := ch.Consume(q.Name, "", true, false, false, false, nil)
+	messages, err := ch.Consume(q.Name, "", false, false, false, false, nil)
 	if err != nil {
 		return err
 	}
 
-	forever := make(chan bool)
-	go func() {
-		for d := range messages {
-			var payload Payload
-			_ = json.Unmarshal(d.Body, &payload)
+	fmt.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", q.Name)
 
-			go handlePayload(payload)
-		}
+	var wg sync.WaitGroup
+	for i := 0; i < consumer.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range messages {
+				consumer.handleDelivery(ctx, ch, d)
+			}
+		}()
+	}
+
+	// closing the channel stops the deliveries, which lets the workers drain and exit
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
 	}()
 
-	fmt.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", q.Name)
-	<-forever
+	select {
+	case <-ctx.Done():
+		ch.Close()
+		<-stopped
+		return ctx.Err()
+	case <-stopped:
+		return errors.New("consumer channel closed")
+	}
+}
 
-	return nil
+func (consumer *Consumer) declareQueue(ch *amqp.Channel) (amqp.Queue, error) {
+	if consumer.queueName == "" {
+		return declareRandomQueue(ch)
+	}
+
+	return declareRetryTopology(ch, consumer.queueName, consumer.retry)
 }
 
-func handlePayload(payload Payload) {
-	switch payload.Name {
-	case "log", "event":
-		// log whatever we get
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
+// handleDelivery runs the handler for d and acks or nacks it.
+// On a named queue failures are retried with backoff and end up in the dead-letter queue.
+func (consumer *Consumer) handleDelivery(ctx context.Context, ch *amqp.Channel, d amqp.Delivery) {
+	envelope, err := ParseEnvelope(d.Body)
+	if err != nil {
+		// unreadable bodies never get better, dead-letter them (or drop them on a random queue)
+		log.Printf("discarding unreadable event %s: %v", d.MessageId, err)
+		_ = d.Nack(false, false)
+		return
+	}
 
-	case "auth":
-		// authenticate
+	consumer.mu.RLock()
+	handler, ok := consumer.handlers[envelope.Type]
+	if !ok {
+		handler = consumer.fallback
+	}
+	consumer.mu.RUnlock()
 
-	// you can have as many cases as you want, as long as you write the logic
+	err = handler(ctx, envelope)
+	if err == nil {
+		_ = d.Ack(false)
+		return
+	}
 
-	default:
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
+	log.Printf("handling %s event %s failed: %v", envelope.Type, envelope.ID, err)
+
+	if consumer.queueName != "" {
+		retryOrDeadLetter(ctx, ch, consumer.queueName, consumer.retry, d, err)
+		return
 	}
+
+	// give the event one more chance, then drop it so a poison message can't loop forever
+	_ = d.Nack(false, !d.Redelivered)
 }
 
-func logEvent(entry Payload) error {
-	log.Println(entry)
+func logEvent(ctx context.Context, entry Envelope) error {
+	log.Println(entry.Type, string(entry.Payload))
 
 	return nil
 }
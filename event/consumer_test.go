@@ -0,0 +1,146 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAcknowledger records what the consumer did with a delivery
+type fakeAcknowledger struct {
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (f *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	f.acked = true
+	return nil
+}
+
+func (f *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	f.nacked = true
+	f.requeue = requeue
+	return nil
+}
+
+func (f *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
+
+func newTestDelivery(t *testing.T, e DomainEvent, redelivered bool) (amqp.Delivery, *fakeAcknowledger) {
+	envelope, err := NewEnvelope("test", e, "")
+	assert.NoError(t, err)
+
+	msg, err := envelope.Publishing()
+	assert.NoError(t, err)
+
+	ack := &fakeAcknowledger{}
+	return amqp.Delivery{Acknowledger: ack, Body: msg.Body, Redelivered: redelivered}, ack
+}
+
+func TestConsumerDispatchesTypedHandlers(t *testing.T) {
+	consumer := &Consumer{handlers: make(map[EventType]HandlerFunc), fallback: logEvent}
+
+	var received OrderCreatedEvent
+	consumer.Register(OrderCreated, Typed(func(ctx context.Context, e Envelope, order OrderCreatedEvent) error {
+		received = order
+		return nil
+	}))
+
+	d, ack := newTestDelivery(t, OrderCreatedEvent{OrderID: "order-1", Quantity: 3}, false)
+	consumer.handleDelivery(context.Background(), nil, d)
+
+	t.Log("Checking the typed handler got the decoded payload and the delivery was acked")
+	assert.Equal(t, "order-1", received.OrderID)
+	assert.True(t, ack.acked)
+	assert.Equal(t, []string{"order.created"}, consumer.Topics())
+}
+
+func TestConsumerNacksFailedHandlers(t *testing.T) {
+	consumer := &Consumer{handlers: make(map[EventType]HandlerFunc), fallback: logEvent}
+	consumer.Register(BookingCreated, func(ctx context.Context, e Envelope) error {
+		return errors.New("downstream unavailable")
+	})
+
+	t.Log("Checking a first failure is requeued")
+	d, ack := newTestDelivery(t, BookingCreatedEvent{BookingID: "booking-1"}, false)
+	consumer.handleDelivery(context.Background(), nil, d)
+	assert.True(t, ack.nacked)
+	assert.True(t, ack.requeue)
+
+	t.Log("Checking a redelivered failure is not requeued again")
+	d, ack = newTestDelivery(t, BookingCreatedEvent{BookingID: "booking-1"}, true)
+	consumer.handleDelivery(context.Background(), nil, d)
+	assert.True(t, ack.nacked)
+	assert.False(t, ack.requeue)
+
+	t.Log("Checking an unreadable body is dropped")
+	ack = &fakeAcknowledger{}
+	consumer.handleDelivery(context.Background(), nil, amqp.Delivery{Acknowledger: ack, Body: []byte("not json")})
+	assert.True(t, ack.nacked)
+	assert.False(t, ack.requeue)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{}.withDefaults()
+
+	t.Log("Checking the delay doubles on every attempt")
+	assert.Equal(t, defaultRetryBaseDelay, policy.Delay(1))
+	assert.Equal(t, 2*defaultRetryBaseDelay, policy.Delay(2))
+	assert.Equal(t, 8*defaultRetryBaseDelay, policy.Delay(4))
+
+	t.Log("Checking the attempt count is read from the delivery headers")
+	assert.Equal(t, 0, deliveryAttempts(amqp.Delivery{}))
+	assert.Equal(t, 3, deliveryAttempts(amqp.Delivery{Headers: amqp.Table{attemptsHeader: int32(3)}}))
+
+	t.Log("Checking an exhausted delivery is dead-lettered without being requeued")
+	ack := &fakeAcknowledger{}
+	d := amqp.Delivery{Acknowledger: ack, Headers: amqp.Table{attemptsHeader: int32(policy.MaxAttempts - 1)}}
+	retryOrDeadLetter(context.Background(), nil, "broker.events", policy, d, errors.New("boom"))
+	assert.True(t, ack.nacked)
+	assert.False(t, ack.requeue)
+}
+
+// memoryIDStore is an in-memory IDStore
+type memoryIDStore map[string]bool
+
+func (s memoryIDStore) Remember(ctx context.Context, id string) (bool, error) {
+	if s[id] {
+		return false, nil
+	}
+	s[id] = true
+	return true, nil
+}
+
+func (s memoryIDStore) Forget(ctx context.Context, id string) error {
+	delete(s, id)
+	return nil
+}
+
+func TestDeduplicate(t *testing.T) {
+	calls := 0
+	fail := true
+	handler := Deduplicate(memoryIDStore{}, func(ctx context.Context, e Envelope) error {
+		calls++
+		if fail {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	})
+
+	envelope, err := NewEnvelope("test", OrderCreatedEvent{OrderID: "order-1"}, "")
+	assert.NoError(t, err)
+
+	t.Log("Checking a failed event is handled again")
+	assert.Error(t, handler(context.Background(), envelope))
+	fail = false
+	assert.NoError(t, handler(context.Background(), envelope))
+
+	t.Log("Checking a redelivered event is skipped")
+	assert.NoError(t, handler(context.Background(), envelope))
+	assert.Equal(t, 2, calls)
+}
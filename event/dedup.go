@@ -0,0 +1,45 @@
+package event
+
+import (
+	"context"
+	"log"
+)
+
+// IDStore remembers which event ids were already handled
+type IDStore interface {
+	// Remember marks id as handled, it returns false if it already was
+	Remember(ctx context.Context, id string) (bool, error)
+	// Forget unmarks id so a failed event can be handled again
+	Forget(ctx context.Context, id string) error
+}
+
+// Deduplicate skips events whose id was already handled.
+// Events are published at least once, so handlers with side effects should be wrapped in it.
+func Deduplicate(store IDStore, handler HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, e Envelope) error {
+		// legacy events have no id, there is nothing to deduplicate on
+		if e.ID == "" {
+			return handler(ctx, e)
+		}
+
+		first, err := store.Remember(ctx, e.ID)
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			log.Printf("skipping duplicate %s event %s", e.Type, e.ID)
+			return nil
+		}
+
+		err = handler(ctx, e)
+		if err != nil {
+			if forgetErr := store.Forget(ctx, e.ID); forgetErr != nil {
+				log.Printf("forgetting %s event %s failed: %v", e.Type, e.ID, forgetErr)
+			}
+			return err
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,292 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// EnvelopeVersion is the schema version of Envelope, bump it on breaking changes
+const EnvelopeVersion = 1
+
+// EventType names a domain event, it doubles as the routing key
+type EventType string
+
+const (
+	// ChatPersisted carries a chat message that must be stored
+	ChatPersisted EventType = "chat.persisted"
+	// ChatAccessAudited is raised when an admin reads another user's chats
+	ChatAccessAudited EventType = "chat.access_audited"
+	// BookingCreated is raised when a renter books an inventory
+	BookingCreated EventType = "booking.created"
+	// BookingStatusChanged is raised on every booking lifecycle transition
+	BookingStatusChanged EventType = "booking.status_changed"
+	// BookingChangeUpdated is raised when a date change is requested, approved, declined or withdrawn
+	BookingChangeUpdated EventType = "booking.change_updated"
+	// DepositUpdated is raised when a security deposit is requested, held, released, deducted or cancelled
+	DepositUpdated EventType = "deposit.updated"
+	// DisputeUpdated is raised when a dispute is opened, answered, escalated, withdrawn or resolved
+	DisputeUpdated EventType = "dispute.updated"
+	// OrderCreated is raised when a buyer places a purchase order
+	OrderCreated EventType = "order.created"
+	// OrderStatusChanged is raised when an order is accepted, shipped, completed or cancelled
+	OrderStatusChanged EventType = "order.status_changed"
+	// RatingReplied is raised when someone replies to a user or inventory rating
+	RatingReplied EventType = "rating.replied"
+	// SubscriptionChanged is raised when a subscription is activated, cancelled or renewed
+	SubscriptionChanged EventType = "subscription.changed"
+)
+
+// DomainEvent is implemented by every event payload that can be wrapped in an Envelope
+type DomainEvent interface {
+	EventType() EventType
+}
+
+// Envelope is the versioned wrapper every event is published in
+type Envelope struct {
+	ID            string          `json:"id"`
+	Type          EventType       `json:"type"`
+	Version       int             `json:"version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Producer      string          `json:"producer"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope wraps e for publishing
+func NewEnvelope(producer string, e DomainEvent, correlationID string) (Envelope, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		ID:            uuid.NewString(),
+		Type:          e.EventType(),
+		Version:       EnvelopeVersion,
+		OccurredAt:    time.Now().UTC(),
+		Producer:      producer,
+		CorrelationID: correlationID,
+		Payload:       payload,
+	}, nil
+}
+
+// RoutingKey derives the routing key from the event type, e.g. "booking.created"
+func (e Envelope) RoutingKey() string {
+	return string(e.Type)
+}
+
+// Decode unmarshals the payload into v
+func (e Envelope) Decode(v any) error {
+	if len(e.Payload) == 0 {
+		return errors.New("event has no payload")
+	}
+
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Publishing turns the envelope into an amqp message
+func (e Envelope) Publishing() (amqp.Publishing, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	return amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     e.ID,
+		CorrelationId: e.CorrelationID,
+		Type:          string(e.Type),
+		AppId:         e.Producer,
+		Timestamp:     e.OccurredAt,
+		Body:          body,
+	}, nil
+}
+
+// ParseEnvelope reads an envelope from a delivery body.
+// Bodies in the legacy {name, data} shape are converted so older producers keep working.
+func ParseEnvelope(body []byte) (Envelope, error) {
+	var envelope Envelope
+	err := json.Unmarshal(body, &envelope)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	if envelope.Type != "" {
+		return envelope, nil
+	}
+
+	// legacy producers send data as a string or as an object, keep it as it came
+	var legacy struct {
+		Name string          `json:"name"`
+		Data json.RawMessage `json:"data"`
+	}
+	err = json.Unmarshal(body, &legacy)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	if legacy.Name == "" {
+		return Envelope{}, errors.New("event has no type")
+	}
+
+	data := legacy.Data
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+
+	return Envelope{
+		Type:    EventType(legacy.Name),
+		Version: 0,
+		Payload: data,
+	}, nil
+}
+
+// ChatPersistedEvent is the payload of chat.persisted
+type ChatPersistedEvent struct {
+	MessageID   string `json:"message_id"`
+	Sender      string `json:"sender"`
+	Receiver    string `json:"receiver"`
+	ReplyTo     string `json:"reply_to"`
+	Content     string `json:"content"`
+	ContentType string `json:"content_type"`
+	SentAt      int64  `json:"sent_at"`
+}
+
+func (ChatPersistedEvent) EventType() EventType { return ChatPersisted }
+
+// ChatAccessAuditedEvent is the payload of chat.access_audited
+type ChatAccessAuditedEvent struct {
+	AdminID    string   `json:"admin_id"`
+	Action     string   `json:"action"`
+	Subjects   []string `json:"subjects"`
+	AccessedAt int64    `json:"accessed_at"`
+}
+
+func (ChatAccessAuditedEvent) EventType() EventType { return ChatAccessAudited }
+
+// BookingCreatedEvent is the payload of booking.created
+type BookingCreatedEvent struct {
+	BookingID   string  `json:"booking_id"`
+	InventoryID string  `json:"inventory_id"`
+	RenterID    string  `json:"renter_id"`
+	OwnerID     string  `json:"owner_id"`
+	StartDate   string  `json:"start_date"`
+	EndDate     string  `json:"end_date"`
+	Quantity    float64 `json:"quantity"`
+	TotalAmount float64 `json:"total_amount"`
+}
+
+func (BookingCreatedEvent) EventType() EventType { return BookingCreated }
+
+// BookingStatusChangedEvent is the payload of booking.status_changed
+type BookingStatusChangedEvent struct {
+	BookingID   string `json:"booking_id"`
+	InventoryID string `json:"inventory_id"`
+	RenterID    string `json:"renter_id"`
+	OwnerID     string `json:"owner_id"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	ReasonCode  string `json:"reason_code,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	ChangedBy   string `json:"changed_by"`
+}
+
+func (BookingStatusChangedEvent) EventType() EventType { return BookingStatusChanged }
+
+// BookingChangeUpdatedEvent is the payload of booking.change_updated
+type BookingChangeUpdatedEvent struct {
+	ChangeID   string  `json:"change_id"`
+	BookingID  string  `json:"booking_id"`
+	RenterID   string  `json:"renter_id"`
+	OwnerID    string  `json:"owner_id"`
+	Kind       string  `json:"kind"` // "extension" or "reschedule"
+	Status     string  `json:"status"`
+	StartDate  string  `json:"start_date"`
+	EndDate    string  `json:"end_date"`
+	PriceDelta float64 `json:"price_delta"`
+	ChangedBy  string  `json:"changed_by"`
+}
+
+func (BookingChangeUpdatedEvent) EventType() EventType { return BookingChangeUpdated }
+
+// DepositUpdatedEvent is the payload of deposit.updated
+type DepositUpdatedEvent struct {
+	BookingID      string  `json:"booking_id"`
+	RenterID       string  `json:"renter_id"`
+	OwnerID        string  `json:"owner_id"`
+	Status         string  `json:"status"`
+	Amount         float64 `json:"amount"`
+	DeductedAmount float64 `json:"deducted_amount"`
+	RefundedAmount float64 `json:"refunded_amount"`
+	Reason         string  `json:"reason,omitempty"`
+	ChangedBy      string  `json:"changed_by"`
+}
+
+func (DepositUpdatedEvent) EventType() EventType { return DepositUpdated }
+
+// DisputeUpdatedEvent is the payload of dispute.updated
+type DisputeUpdatedEvent struct {
+	DisputeID    string  `json:"dispute_id"`
+	SubjectType  string  `json:"subject_type"` // "booking" or "order"
+	SubjectID    string  `json:"subject_id"`
+	ClaimantID   string  `json:"claimant_id"`
+	RespondentID string  `json:"respondent_id"`
+	Action       string  `json:"action"`
+	Status       string  `json:"status"`
+	Outcome      string  `json:"outcome,omitempty"`
+	Amount       float64 `json:"amount,omitempty"`
+	ChangedBy    string  `json:"changed_by"`
+}
+
+func (DisputeUpdatedEvent) EventType() EventType { return DisputeUpdated }
+
+// OrderCreatedEvent is the payload of order.created
+type OrderCreatedEvent struct {
+	OrderID     string  `json:"order_id"`
+	InventoryID string  `json:"inventory_id"`
+	BuyerID     string  `json:"buyer_id"`
+	SellerID    string  `json:"seller_id"`
+	Quantity    float64 `json:"quantity"`
+	TotalAmount float64 `json:"total_amount"`
+}
+
+func (OrderCreatedEvent) EventType() EventType { return OrderCreated }
+
+// OrderStatusChangedEvent is the payload of order.status_changed
+type OrderStatusChangedEvent struct {
+	OrderID    string `json:"order_id"`
+	BuyerID    string `json:"buyer_id"`
+	SellerID   string `json:"seller_id"`
+	From       string `json:"from,omitempty"`
+	Status     string `json:"status"`
+	ReasonCode string `json:"reason_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	ChangedBy  string `json:"changed_by,omitempty"`
+}
+
+func (OrderStatusChangedEvent) EventType() EventType { return OrderStatusChanged }
+
+// RatingRepliedEvent is the payload of rating.replied
+type RatingRepliedEvent struct {
+	RatingID  string `json:"rating_id"`
+	ReplyID   string `json:"reply_id"`
+	ReplierID string `json:"replier_id"`
+	RaterID   string `json:"rater_id"`
+	Kind      string `json:"kind"` // "inventory" or "user"
+	Comment   string `json:"comment"`
+}
+
+func (RatingRepliedEvent) EventType() EventType { return RatingReplied }
+
+// SubscriptionChangedEvent is the payload of subscription.changed
+type SubscriptionChangedEvent struct {
+	UserID string `json:"user_id"`
+	Status string `json:"status"` // e.g. "activated", "cancelled"
+}
+
+func (SubscriptionChangedEvent) EventType() EventType { return SubscriptionChanged }
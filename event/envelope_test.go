@@ -0,0 +1,73 @@
+package event
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEnvelope(t *testing.T) {
+	booking := BookingCreatedEvent{
+		BookingID:   "6a7b83f0-30cb-4854-a32e-3576bf491858",
+		InventoryID: "5bce1593-c6a6-4d2d-ab6a-fd2962cffb59",
+		RenterID:    "7a937e9d-1dc2-4e6d-ba38-d1648b05730c",
+		Quantity:    2,
+		TotalAmount: 15000,
+	}
+
+	envelope, err := NewEnvelope("broker-service", booking, "req-123")
+	assert.NoError(t, err)
+
+	t.Log("Checking the envelope metadata")
+	assert.NotEmpty(t, envelope.ID)
+	assert.Equal(t, BookingCreated, envelope.Type)
+	assert.Equal(t, EnvelopeVersion, envelope.Version)
+	assert.Equal(t, "broker-service", envelope.Producer)
+	assert.Equal(t, "req-123", envelope.CorrelationID)
+	assert.Equal(t, "booking.created", envelope.RoutingKey())
+
+	t.Log("Checking the amqp message is json and carries the envelope id")
+	msg, err := envelope.Publishing()
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", msg.ContentType)
+	assert.Equal(t, envelope.ID, msg.MessageId)
+
+	t.Log("Checking the payload survives a round trip")
+	parsed, err := ParseEnvelope(msg.Body)
+	assert.NoError(t, err)
+
+	var decoded BookingCreatedEvent
+	assert.NoError(t, parsed.Decode(&decoded))
+	assert.Equal(t, booking, decoded)
+}
+
+func TestParseLegacyPayload(t *testing.T) {
+	body, _ := json.Marshal(Payload{Name: "log", Data: "hello"})
+
+	envelope, err := ParseEnvelope(body)
+	assert.NoError(t, err)
+	assert.Equal(t, EventType("log"), envelope.Type)
+	assert.Equal(t, 0, envelope.Version)
+
+	var data string
+	assert.NoError(t, envelope.Decode(&data))
+	assert.Equal(t, "hello", data)
+
+	t.Log("Checking that object data is kept as the producer sent it")
+	envelope, err = ParseEnvelope([]byte(`{"name":"persist_chat","data":{"sender":"user-1","content":"hi"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, EventType("persist_chat"), envelope.Type)
+
+	var chat struct {
+		Sender  string `json:"sender"`
+		Content string `json:"content"`
+	}
+	assert.NoError(t, envelope.Decode(&chat))
+	assert.Equal(t, "user-1", chat.Sender)
+	assert.Equal(t, "hi", chat.Content)
+
+	t.Log("Checking that a body without a type is rejected")
+	_, err = ParseEnvelope([]byte(`{"data":"hello"}`))
+	assert.Error(t, err)
+}
@@ -27,3 +27,29 @@ func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
 		nil,   //arguements?
 	)
 }
+
+// deadLetterExchange receives deliveries that failed every attempt
+const deadLetterExchange = "logs_topic.dlx"
+
+func declareDeadLetterExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		deadLetterExchange, //name
+		"direct",           //type
+		true,               //durable
+		false,              //auto-delete?
+		false,              //internal
+		false,              //no-wait?
+		nil,                //arguements
+	)
+}
+
+func declareDurableQueue(ch *amqp.Channel, name string, args amqp.Table) (amqp.Queue, error) {
+	return ch.QueueDeclare(
+		name,  //name?
+		true,  //durable
+		false, //deleteWhenUnused?
+		false, //exclusive
+		false, //no-wait?
+		args,  //arguements?
+	)
+}
@@ -0,0 +1,223 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrPublishNotConfirmed is returned when rabbitmq nacks a publish
+var ErrPublishNotConfirmed = errors.New("event was not confirmed by rabbitmq")
+
+// ErrPublishNotRouted is returned when a mandatory publish reached no queue
+var ErrPublishNotRouted = errors.New("event was not routed to any queue")
+
+// Publisher publishes to the logs_topic exchange over a pool of long-lived confirm channels.
+// It is safe for concurrent use, channels and the connection are re-opened when they close.
+type Publisher struct {
+	dial func() (*amqp.Connection, error)
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+
+	// pool holds at most size channels, a nil entry is a slot that needs a new channel
+	pool chan *pooledChannel
+}
+
+// pooledChannel is a confirm channel with the messages rabbitmq returned on it as unroutable
+type pooledChannel struct {
+	ch      *amqp.Channel
+	returns chan amqp.Return
+}
+
+// NewPublisher creates a publisher with size channels, dial is used to (re)connect to rabbitmq
+func NewPublisher(dial func() (*amqp.Connection, error), size int) (*Publisher, error) {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &Publisher{
+		dial: dial,
+		pool: make(chan *pooledChannel, size),
+	}
+
+	for i := 0; i < size; i++ {
+		p.pool <- nil
+	}
+
+	// make sure we can talk to rabbitmq and the exchange exists before we hand out the publisher
+	_, err := p.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Publish sends msg with routingKey and waits for rabbitmq to confirm it,
+// a message no queue is bound for is dropped by rabbitmq and still confirmed
+func (p *Publisher) Publish(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	return p.publish(ctx, routingKey, msg, false)
+}
+
+// PublishMandatory is Publish for messages that must reach a queue, it fails with
+// ErrPublishNotRouted when rabbitmq returns msg because nothing is bound for routingKey
+func (p *Publisher) PublishMandatory(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	return p.publish(ctx, routingKey, msg, true)
+}
+
+func (p *Publisher) publish(ctx context.Context, routingKey string, msg amqp.Publishing, mandatory bool) error {
+	var pc *pooledChannel
+	select {
+	case pc = <-p.pool:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// always hand the slot back, a broken channel goes back as nil so it is replaced next time
+	defer func() {
+		if pc != nil && pc.ch.IsClosed() {
+			pc = nil
+		}
+		p.pool <- pc
+	}()
+
+	if pc == nil || pc.ch.IsClosed() {
+		var err error
+		pc, err = p.openChannel()
+		if err != nil {
+			return err
+		}
+	}
+
+	// forget returns left over from earlier publishes on this channel
+	for len(pc.returns) > 0 {
+		<-pc.returns
+	}
+
+	confirmation, err := pc.ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"logs_topic",
+		routingKey,
+		mandatory,
+		false,
+		msg,
+	)
+	if err != nil {
+		pc.ch.Close()
+		pc = nil
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !acked {
+		return ErrPublishNotConfirmed
+	}
+
+	// rabbitmq sends basic.return before the ack, so a returned message is already waiting here
+	if mandatory {
+		select {
+		case <-pc.returns:
+			return ErrPublishNotRouted
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Push publishes a plain text event, it mirrors Emitter.Push
+func (p *Publisher) Push(ctx context.Context, event string, severity string) error {
+	return p.Publish(ctx, severity, amqp.Publishing{
+		ContentType:  "text/plain",
+		DeliveryMode: amqp.Persistent,
+		Body:         []byte(event),
+	})
+}
+
+// PublishEnvelope publishes e as json, routed by its event type
+func (p *Publisher) PublishEnvelope(ctx context.Context, e Envelope) error {
+	msg, err := e.Publishing()
+	if err != nil {
+		return err
+	}
+
+	return p.Publish(ctx, e.RoutingKey(), msg)
+}
+
+// Close closes the pooled channels, the connection is owned by the caller
+func (p *Publisher) Close() {
+	for i := 0; i < cap(p.pool); i++ {
+		pc := <-p.pool
+		if pc != nil {
+			pc.ch.Close()
+		}
+	}
+}
+
+// openChannel opens a confirm channel on the current connection
+func (p *Publisher) openChannel() (*pooledChannel, error) {
+	conn, err := p.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	// put the channel into confirm mode so every publish gets an ack/nack
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	// a channel publishes one message at a time, so one buffered return is enough
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	return &pooledChannel{ch: ch, returns: returns}, nil
+}
+
+// connection returns the current connection, dialing a new one if it was closed
+func (p *Publisher) connection() (*amqp.Connection, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != nil && !p.conn.IsClosed() {
+		return p.conn, nil
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer ch.Close()
+
+	err = declareExchange(ch)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if p.conn != nil {
+		log.Println("Publisher reconnected to RabbitMQ")
+	}
+	p.conn = conn
+
+	return conn, nil
+}
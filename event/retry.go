@@ -0,0 +1,277 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// attemptsHeader counts how many times a delivery has been handled
+	attemptsHeader = "x-attempts"
+	// lastErrorHeader keeps the error of the last failed attempt
+	lastErrorHeader = "x-last-error"
+
+	defaultMaxAttempts    = 5
+	defaultRetryBaseDelay = 5 * time.Second
+)
+
+// RetryPolicy controls how failed deliveries of a named queue are retried
+type RetryPolicy struct {
+	// MaxAttempts is how many times a delivery is handled before it is dead-lettered
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, it doubles on every attempt
+	BaseDelay time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultRetryBaseDelay
+	}
+
+	return p
+}
+
+// Delay returns the wait before retrying after the given attempt (1-based)
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	return p.BaseDelay * time.Duration(1<<(attempt-1))
+}
+
+// DeadLetterQueue returns the name of the queue holding queue's dead letters
+func DeadLetterQueue(queue string) string {
+	return queue + ".dead"
+}
+
+// retryQueue returns the name of the delay queue used after the given attempt
+func retryQueue(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, attempt)
+}
+
+// declareRetryTopology declares queue with a dead-letter queue and one delay queue per retry.
+// A delay queue has a message ttl and dead-letters expired messages back into queue,
+// so each retry tier waits its own (exponential) delay without blocking the others.
+func declareRetryTopology(ch *amqp.Channel, queue string, policy RetryPolicy) (amqp.Queue, error) {
+	err := declareDeadLetterExchange(ch)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	dead, err := declareDurableQueue(ch, DeadLetterQueue(queue), nil)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	err = ch.QueueBind(dead.Name, queue, deadLetterExchange, false, nil)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	for attempt := 1; attempt < policy.MaxAttempts; attempt++ {
+		_, err = declareDurableQueue(ch, retryQueue(queue, attempt), amqp.Table{
+			"x-message-ttl":             policy.Delay(attempt).Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return amqp.Queue{}, err
+		}
+	}
+
+	return declareDurableQueue(ch, queue, amqp.Table{
+		"x-dead-letter-exchange":    deadLetterExchange,
+		"x-dead-letter-routing-key": queue,
+	})
+}
+
+// deliveryAttempts reads how many times d has already been handled
+func deliveryAttempts(d amqp.Delivery) int {
+	switch v := d.Headers[attemptsHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// retryOrDeadLetter schedules d for another attempt, or dead-letters it once the attempts are used up
+func retryOrDeadLetter(ctx context.Context, ch *amqp.Channel, queue string, policy RetryPolicy, d amqp.Delivery, cause error) {
+	attempt := deliveryAttempts(d) + 1
+	if attempt >= policy.MaxAttempts {
+		log.Printf("dead-lettering %s after %d attempts: %v", d.MessageId, attempt, cause)
+		_ = d.Nack(false, false)
+		return
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[attemptsHeader] = int32(attempt)
+	headers[lastErrorHeader] = cause.Error()
+
+	err := ch.PublishWithContext(ctx, "", retryQueue(queue, attempt), false, false, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Type:          d.Type,
+		AppId:         d.AppId,
+		Timestamp:     d.Timestamp,
+		Body:          d.Body,
+	})
+	if err != nil {
+		// could not schedule the retry, let rabbitmq hand it back to us
+		log.Printf("scheduling retry of %s failed: %v", d.MessageId, err)
+		_ = d.Nack(false, true)
+		return
+	}
+
+	_ = d.Ack(false)
+}
+
+// DeadLetter is a dead-lettered delivery as shown to admins
+type DeadLetter struct {
+	MessageID  string    `json:"message_id"`
+	Type       string    `json:"type"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Body       string    `json:"body"`
+	RoutingKey string    `json:"routing_key"`
+}
+
+func toDeadLetter(d amqp.Delivery) DeadLetter {
+	lastError, _ := d.Headers[lastErrorHeader].(string)
+
+	return DeadLetter{
+		MessageID:  d.MessageId,
+		Type:       d.Type,
+		Attempts:   deliveryAttempts(d),
+		LastError:  lastError,
+		Timestamp:  d.Timestamp,
+		Body:       string(d.Body),
+		RoutingKey: d.RoutingKey,
+	}
+}
+
+// InspectDeadLetters returns up to limit dead letters of queue without removing them
+func InspectDeadLetters(conn *amqp.Connection, queue string, limit int) ([]DeadLetter, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	// closing the channel hands every unacked delivery back to the queue
+	defer ch.Close()
+
+	letters := []DeadLetter{}
+	for len(letters) < limit {
+		d, ok, err := ch.Get(DeadLetterQueue(queue), false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		letters = append(letters, toDeadLetter(d))
+	}
+
+	return letters, nil
+}
+
+// ReplayDeadLetters moves up to limit dead letters back onto queue with a fresh attempt count.
+// An empty messageID replays the oldest ones, otherwise only that message is replayed.
+func ReplayDeadLetters(ctx context.Context, conn *amqp.Connection, queue string, messageID string, limit int) (int, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	err = ch.Confirm(false)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for replayed < limit {
+		d, ok, err := ch.Get(DeadLetterQueue(queue), false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		if messageID != "" && d.MessageId != messageID {
+			// not the one we are after, it goes back when the channel closes
+			continue
+		}
+
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			if k == attemptsHeader || k == "x-death" {
+				continue
+			}
+			headers[k] = v
+		}
+
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, amqp.Publishing{
+			Headers:       headers,
+			ContentType:   d.ContentType,
+			DeliveryMode:  amqp.Persistent,
+			MessageId:     d.MessageId,
+			CorrelationId: d.CorrelationId,
+			Type:          d.Type,
+			AppId:         d.AppId,
+			Timestamp:     d.Timestamp,
+			Body:          d.Body,
+		})
+		if err != nil {
+			return replayed, err
+		}
+
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return replayed, err
+		}
+		if !acked {
+			return replayed, ErrPublishNotConfirmed
+		}
+
+		err = d.Ack(false)
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+
+		if messageID != "" {
+			break
+		}
+	}
+
+	return replayed, nil
+}
+
+// PurgeDeadLetters deletes every dead letter of queue and returns how many were removed
+func PurgeDeadLetters(conn *amqp.Connection, queue string) (int, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	return ch.QueuePurge(DeadLetterQueue(queue), false)
+}
@@ -0,0 +1,240 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrNotConnected is returned while the supervisor has no open connection
+var ErrNotConnected = errors.New("rabbitmq is not connected")
+
+// ConnectionState describes the supervised connection
+type ConnectionState int32
+
+const (
+	Disconnected ConnectionState = iota
+	Connecting
+	Connected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	default:
+		return "disconnected"
+	}
+}
+
+const (
+	minReconnectBackOff = 1 * time.Second
+	maxReconnectBackOff = 30 * time.Second
+)
+
+// Supervisor owns the rabbitmq connection, it reconnects with jittered backoff when
+// the connection closes and re-runs the registered OnConnect hooks (exchanges, consumers).
+type Supervisor struct {
+	url string
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	hooks     []func(*amqp.Connection) error
+	listeners []func(ConnectionState)
+
+	state      atomic.Int32
+	reconnects atomic.Int64
+	closing    atomic.Bool
+}
+
+// NewSupervisor creates a supervisor for url, call Start to connect
+func NewSupervisor(url string) *Supervisor {
+	return &Supervisor{url: url}
+}
+
+// OnConnect registers a hook that runs on every successful (re)connect.
+// If already connected, the hook runs straight away on the current connection.
+func (s *Supervisor) OnConnect(hook func(*amqp.Connection) error) error {
+	s.mu.Lock()
+	s.hooks = append(s.hooks, hook)
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn != nil && !conn.IsClosed() {
+		return hook(conn)
+	}
+
+	return nil
+}
+
+// OnStateChange registers a listener that is told about every state change
+func (s *Supervisor) OnStateChange(listener func(ConnectionState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, listener)
+}
+
+// Start connects, retrying until ctx is done, then keeps the connection alive in the background
+func (s *Supervisor) Start(ctx context.Context) error {
+	conn, err := s.dialWithBackOff(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.install(conn)
+	go s.watch(conn)
+
+	return nil
+}
+
+// Connection returns the current connection or ErrNotConnected
+func (s *Supervisor) Connection() (*amqp.Connection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.conn == nil || s.conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+
+	return s.conn, nil
+}
+
+// State returns the current connection state
+func (s *Supervisor) State() ConnectionState {
+	return ConnectionState(s.state.Load())
+}
+
+// Reconnects returns how many times the connection has been re-established
+func (s *Supervisor) Reconnects() int64 {
+	return s.reconnects.Load()
+}
+
+// Close stops supervising and closes the connection
+func (s *Supervisor) Close() error {
+	s.closing.Store(true)
+
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+
+	s.setState(Disconnected)
+
+	if conn != nil && !conn.IsClosed() {
+		return conn.Close()
+	}
+
+	return nil
+}
+
+// watch waits for the connection to close and reconnects until Close is called
+func (s *Supervisor) watch(conn *amqp.Connection) {
+	for {
+		reason, ok := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+		if s.closing.Load() {
+			return
+		}
+
+		if ok {
+			log.Printf("RabbitMQ connection closed: %v", reason)
+		} else {
+			log.Println("RabbitMQ connection closed")
+		}
+		s.setState(Disconnected)
+
+		next, err := s.dialWithBackOff(context.Background())
+		if err != nil {
+			// only happens when Close was called while reconnecting
+			return
+		}
+
+		s.reconnects.Add(1)
+		s.install(next)
+		conn = next
+	}
+}
+
+// install makes conn the current connection and runs the OnConnect hooks
+func (s *Supervisor) install(conn *amqp.Connection) {
+	s.mu.Lock()
+	s.conn = conn
+	hooks := append([]func(*amqp.Connection) error{}, s.hooks...)
+	s.mu.Unlock()
+
+	for _, hook := range hooks {
+		if err := hook(conn); err != nil {
+			log.Printf("RabbitMQ on-connect hook failed: %v", err)
+		}
+	}
+
+	log.Println("Connected to RabbitMQ!")
+	s.setState(Connected)
+}
+
+// dialWithBackOff dials until it succeeds, ctx is done or Close is called.
+// It uses exponential backoff with full jitter so replicas don't reconnect in lockstep.
+func (s *Supervisor) dialWithBackOff(ctx context.Context) (*amqp.Connection, error) {
+	s.setState(Connecting)
+
+	backOff := minReconnectBackOff
+	for {
+		conn, err := amqp.Dial(s.url)
+		if err == nil {
+			return conn, nil
+		}
+
+		log.Printf("RabbitMQ not yet ready... %v", err)
+
+		wait := time.Duration(rand.Int63n(int64(backOff))) + minReconnectBackOff/2
+		select {
+		case <-ctx.Done():
+			s.setState(Disconnected)
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if s.closing.Load() {
+			return nil, ErrNotConnected
+		}
+
+		backOff *= 2
+		if backOff > maxReconnectBackOff {
+			backOff = maxReconnectBackOff
+		}
+	}
+}
+
+func (s *Supervisor) setState(state ConnectionState) {
+	previous := ConnectionState(s.state.Swap(int32(state)))
+	if previous == state {
+		return
+	}
+
+	s.mu.RLock()
+	listeners := append([]func(ConnectionState){}, s.listeners...)
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(state)
+	}
+}
+
+// DeclareExchanges declares the exchanges the broker publishes to, it is meant as an OnConnect hook
+func DeclareExchanges(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return declareExchange(ch)
+}